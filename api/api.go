@@ -0,0 +1,192 @@
+// Package api holds the types that make up the wire format of the HTTP API.
+// They are kept apart from the model types so that the model can change
+// without changing what clients see.
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	NamingSnake = "snake"
+	NamingCamel = "camel"
+)
+
+// Naming selects the JSON field names of request and response bodies. Field
+// names are declared in snake_case; with NamingCamel they are rewritten to
+// camelCase on output and read back from camelCase on input. Query
+// parameters keep their snake_case names either way, and so do the fields
+// that problem responses report for them.
+var Naming = NamingSnake
+
+func SetNaming(naming string) error {
+	switch naming {
+	case NamingSnake, NamingCamel:
+		Naming = naming
+		return nil
+	default:
+		return fmt.Errorf("unknown naming %q, want %q or %q", naming, NamingSnake, NamingCamel)
+	}
+}
+
+type User struct {
+	XMLName xml.Name `json:"-" xml:"user" yaml:"-"`
+	Id      int      `json:"id" xml:"id" yaml:"id"`
+	Name    string   `json:"name" xml:"name" yaml:"name"`
+	Email   string   `json:"email" xml:"email" yaml:"email"`
+}
+
+func NewUser(user *model.User) *User {
+	if user == nil {
+		return nil
+	}
+	return &User{Id: user.Id, Name: user.Name, Email: user.Email}
+}
+
+func NewUsers(users []*model.User) []*User {
+	result := make([]*User, len(users))
+	for i, user := range users {
+		result[i] = NewUser(user)
+	}
+	return result
+}
+
+// UserV2 is the version 2 representation of a user: the id is an opaque
+// string and the user links to itself.
+type UserV2 struct {
+	XMLName xml.Name `json:"-" xml:"user" yaml:"-"`
+	Id      string   `json:"id" xml:"id" yaml:"id"`
+	Name    string   `json:"name" xml:"name" yaml:"name"`
+	Email   string   `json:"email" xml:"email" yaml:"email"`
+	Links   Links    `json:"links" xml:"links" yaml:"links"`
+}
+
+func NewUserV2(user *model.User) *UserV2 {
+	if user == nil {
+		return nil
+	}
+	id := strconv.Itoa(user.Id)
+	return &UserV2{Id: id, Name: user.Name, Email: user.Email, Links: Links{Self: "/v2/users/" + id}}
+}
+
+// Envelope wraps a collection with metadata and links.
+type Envelope struct {
+	Data  interface{} `json:"data" xml:"data" yaml:"data"`
+	Meta  Meta        `json:"meta" xml:"meta" yaml:"meta"`
+	Links Links       `json:"links" xml:"links" yaml:"links"`
+}
+
+type Meta struct {
+	Count int `json:"count" xml:"count" yaml:"count"`
+}
+
+type Links struct {
+	Self string `json:"self" xml:"self" yaml:"self"`
+	// Next is the following page of a paginated collection.
+	Next string `json:"next,omitempty" xml:"next,omitempty" yaml:"next,omitempty"`
+}
+
+// RenameJson rewrites the object keys of a JSON document to the configured
+// naming, keeping their order.
+func RenameJson(body []byte) ([]byte, error) {
+	return renameJson(body, CamelCase)
+}
+
+// UnrenameJson rewrites the object keys of a JSON document in the configured
+// naming back to the snake_case the types declare, for reading a request.
+func UnrenameJson(body []byte) ([]byte, error) {
+	return renameJson(body, SnakeCase)
+}
+
+func renameJson(body []byte, rename func(string) string) ([]byte, error) {
+	if Naming == NamingSnake {
+		return body, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	out := &bytes.Buffer{}
+	if err := renameValue(decoder, out, rename); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func renameValue(decoder *json.Decoder, out *bytes.Buffer, rename func(string) string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		out.WriteByte('{')
+		for i := 0; decoder.More(); i++ {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			name, _ := json.Marshal(rename(key.(string)))
+			out.Write(name)
+			out.WriteByte(':')
+			if err := renameValue(decoder, out, rename); err != nil {
+				return err
+			}
+		}
+		decoder.Token()
+		out.WriteByte('}')
+	case json.Delim('['):
+		out.WriteByte('[')
+		for i := 0; decoder.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := renameValue(decoder, out, rename); err != nil {
+				return err
+			}
+		}
+		decoder.Token()
+		out.WriteByte(']')
+	default:
+		value, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		out.Write(value)
+	}
+	return nil
+}
+
+// CamelCase turns a snake_case name into camelCase.
+func CamelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// SnakeCase turns a camelCase name into snake_case.
+func SnakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if 'A' <= r && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func TestUserWireFormat(t *testing.T) {
+	body, err := json.Marshal(NewUser(&model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"}))
+
+	require.NoError(t, err)
+	require.Equal(t, `{"id":1,"name":"Kaladin","email":"k@s.com"}`, string(body))
+}
+
+func TestUserV2WireFormat(t *testing.T) {
+	body, err := json.Marshal(NewUserV2(&model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"}))
+
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"1","name":"Kaladin","email":"k@s.com","links":{"self":"/v2/users/1"}}`, string(body))
+}
+
+func TestNewUserNil(t *testing.T) {
+	require.Nil(t, NewUser(nil))
+}
+
+func TestEnvelopeWireFormat(t *testing.T) {
+	envelope := &Envelope{
+		Data:  NewUsers([]*model.User{{Id: 1, Name: "Kaladin", Email: "k@s.com"}}),
+		Meta:  Meta{Count: 1},
+		Links: Links{Self: "/users"},
+	}
+	body, err := json.Marshal(envelope)
+
+	require.NoError(t, err)
+	require.Equal(t, `{"data":[{"id":1,"name":"Kaladin","email":"k@s.com"}],"meta":{"count":1},"links":{"self":"/users"}}`, string(body))
+}
+
+func TestSetNaming(t *testing.T) {
+	defer SetNaming(NamingSnake)
+
+	require.NoError(t, SetNaming(NamingCamel))
+	require.Equal(t, NamingCamel, Naming)
+	require.Error(t, SetNaming("kebab"))
+	require.Equal(t, NamingCamel, Naming)
+}
+
+func TestRenameJsonSnakeIsUnchanged(t *testing.T) {
+	body, err := RenameJson([]byte(`{"email_verified_at":null}`))
+
+	require.NoError(t, err)
+	require.Equal(t, `{"email_verified_at":null}`, string(body))
+}
+
+func TestRenameJsonCamel(t *testing.T) {
+	SetNaming(NamingCamel)
+	defer SetNaming(NamingSnake)
+
+	body, err := RenameJson([]byte(`{"data":[{"user_id":12345678901234567890,"display_name":"Kal \"Stormblessed\"","is_admin":false,"deleted_at":null}],"meta":{"total_count":1.5}}`))
+
+	require.NoError(t, err)
+	require.Equal(t, `{"data":[{"userId":12345678901234567890,"displayName":"Kal \"Stormblessed\"","isAdmin":false,"deletedAt":null}],"meta":{"totalCount":1.5}}`, string(body))
+}
+
+func TestUnrenameJsonCamel(t *testing.T) {
+	SetNaming(NamingCamel)
+	defer SetNaming(NamingSnake)
+
+	body, err := UnrenameJson([]byte(`{"userIds":[1,2],"filter":{"nameContains":"kal"}}`))
+
+	require.NoError(t, err)
+	require.Equal(t, `{"user_ids":[1,2],"filter":{"name_contains":"kal"}}`, string(body))
+}
+
+func TestSnakeCase(t *testing.T) {
+	require.Equal(t, "id", SnakeCase("id"))
+	require.Equal(t, "email_verified_at", SnakeCase("emailVerifiedAt"))
+	require.Equal(t, "email_verified_at", SnakeCase("email_verified_at"))
+}
+
+func TestCamelCase(t *testing.T) {
+	require.Equal(t, "id", CamelCase("id"))
+	require.Equal(t, "emailVerifiedAt", CamelCase("email_verified_at"))
+	require.Equal(t, "trailing", CamelCase("trailing_"))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+const (
+	requestIdHeader    = "X-Request-ID"
+	requestIdMaxLength = 200
+	anonymousActor     = "anonymous"
+	auditDefaultLimit  = 100
+	auditMaxLimit      = 1000
+)
+
+// auditActorHeader names the header that carries the caller's identity. The
+// API does no authentication of its own, so it is expected to be set by the
+// proxy in front of it, which must also strip it from incoming requests.
+var auditActorHeader = "X-Forwarded-User"
+
+type auditKey struct{}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Println(err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestId accepts ids of printable ASCII, so that they are safe to log
+// and echo back.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > requestIdMaxLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestAudit tags each request with an id, taken from X-Request-ID when
+// the caller sent a usable one, and an actor, and echoes the id back. Both
+// end up in the audit log for the changes the request makes.
+func withRequestAudit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+		actor := strings.TrimSpace(r.Header.Get(auditActorHeader))
+		if actor == "" {
+			actor = anonymousActor
+		}
+		audit := model.Audit{Actor: actor, RequestId: id}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditKey{}, audit)))
+	})
+}
+
+// requestAudit returns who is making the request with ctx.
+func requestAudit(ctx context.Context) model.Audit {
+	if audit, ok := ctx.Value(auditKey{}).(model.Audit); ok {
+		return audit
+	}
+	return model.Audit{Actor: anonymousActor}
+}
+
+type auditEntryResponse struct {
+	Id        int64       `json:"id" xml:"id" yaml:"id"`
+	UserId    int         `json:"user_id" xml:"user_id" yaml:"user_id"`
+	Actor     string      `json:"actor" xml:"actor" yaml:"actor"`
+	RequestId string      `json:"request_id,omitempty" xml:"request_id,omitempty" yaml:"request_id,omitempty"`
+	Operation string      `json:"operation" xml:"operation" yaml:"operation"`
+	Before    interface{} `json:"before,omitempty" xml:"before,omitempty" yaml:"before,omitempty"`
+	After     interface{} `json:"after,omitempty" xml:"after,omitempty" yaml:"after,omitempty"`
+	ChangedAt time.Time   `json:"changed_at" xml:"changed_at" yaml:"changed_at"`
+}
+
+func renderAuditEntries(r *http.Request, entries []*model.AuditEntry) []*auditEntryResponse {
+	result := make([]*auditEntryResponse, len(entries))
+	for i, entry := range entries {
+		result[i] = &auditEntryResponse{
+			Id:        entry.Id,
+			UserId:    entry.UserId,
+			Actor:     entry.Actor,
+			RequestId: entry.RequestId,
+			Operation: entry.Operation,
+			ChangedAt: entry.ChangedAt,
+		}
+		if entry.Before != nil {
+			result[i].Before = renderUser(r, entry.Before)
+		}
+		if entry.After != nil {
+			result[i].After = renderUser(r, entry.After)
+		}
+	}
+	return result
+}
+
+func userHistoryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+	entries, err := model.UserHistory(db, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, sql.ErrNoRows.Error(), 404)
+		return
+	}
+	marshalAndWrite(renderAuditEntries(r, entries), w, r)
+}
+
+// parseAuditFilter reads the actor, since and limit query parameters of
+// /audit.
+func parseAuditFilter(r *http.Request) (model.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := model.AuditFilter{Actor: query.Get("actor"), Limit: auditDefaultLimit}
+	v := validation.New()
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			v.Add("since", "date_time")
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		switch {
+		case err != nil:
+			v.Add("limit", "type", "integer")
+		case n < 1:
+			v.Add("limit", "minimum", 1)
+		case n > auditMaxLimit:
+			v.Add("limit", "maximum", auditMaxLimit)
+		}
+		filter.Limit = n
+	}
+	return filter, v.Err()
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeMutationError(w, r, err)
+		return
+	}
+	entries, err := model.ListAudit(db, filter)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	marshalAndWrite(renderAuditEntries(r, entries), w, r)
+}
+
+// addAuditRoutes registers the audit log of changes to every user.
+func addAuditRoutes(router *mux.Router, db *sql.DB) {
+	router.HandleFunc("/audit", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		auditHandler(w, r, db)
+	})).Methods(http.MethodGet)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var auditRowColumns = []string{"id", "user_id", "actor", "request_id", "operation", "before", "after", "changed_at"}
+
+func TestMutationRecordsActorAndRequestId(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO user_audit")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "dalinar", "req-42").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=password",
+		map[string]string{"X-Forwarded-User": "dalinar", "X-Request-ID": "req-42"})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "req-42", resp.Header.Get("X-Request-ID"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestIdIsGeneratedWhenMissing(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/readiness", map[string]string{"X-Request-ID": "bad id"})
+
+	require.NoError(t, err)
+	require.Len(t, resp.Header.Get("X-Request-ID"), 32)
+}
+
+func TestUserHistory(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	changedAt := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := mock.NewRows(auditRowColumns).
+		AddRow(1, 1, "dalinar", "req-1", "create", nil, []byte(`{"id": 1, "name": "Kal", "email": "k@s.com"}`), changedAt).
+		AddRow(2, 1, "dalinar", "req-2", "update", []byte(`{"id": 1, "name": "Kal", "email": "k@s.com"}`), []byte(`{"id": 1, "name": "Kaladin", "email": "k@s.com"}`), changedAt)
+	mock.ExpectQuery("FROM user_audit WHERE user_id").WithArgs(1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v2/users/1/history", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.JSONEq(t, `[
+		{"id":1,"user_id":1,"actor":"dalinar","request_id":"req-1","operation":"create",
+		 "after":{"id":"1","name":"Kal","email":"k@s.com","links":{"self":"/v2/users/1"}},"changed_at":"2020-09-01T12:00:00Z"},
+		{"id":2,"user_id":1,"actor":"dalinar","request_id":"req-2","operation":"update",
+		 "before":{"id":"1","name":"Kal","email":"k@s.com","links":{"self":"/v2/users/1"}},
+		 "after":{"id":"1","name":"Kaladin","email":"k@s.com","links":{"self":"/v2/users/1"}},"changed_at":"2020-09-01T12:00:00Z"}
+	]`, string(body))
+}
+
+func TestUserHistoryUnknownUser(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM user_audit WHERE user_id").WithArgs(9).WillReturnRows(mock.NewRows(auditRowColumns))
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/9/history", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAuditFilters(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM user_audit WHERE actor=\\$1 AND changed_at>=\\$2 ORDER BY id LIMIT \\$3").
+		WithArgs("dalinar", time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), 100).WillReturnRows(mock.NewRows(auditRowColumns))
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/audit?actor=dalinar&since=2020-09-01T00:00:00Z", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "[]", string(body))
+}
+
+func TestAuditRejectsBadFilters(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/audit?since=yesterday&limit=5000", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), `"field":"since","code":"date_time"`)
+	require.Contains(t, string(body), `"field":"limit","code":"maximum"`)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const maxBatchOperations = 1000
+
+var errUnknownBatchOp = errors.New("unknown op")
+
+type batchOperation struct {
+	Op       string `json:"op" xml:"op" yaml:"op"`
+	Id       int    `json:"id" xml:"id" yaml:"id"`
+	Name     string `json:"name" xml:"name" yaml:"name"`
+	Email    string `json:"email" xml:"email" yaml:"email"`
+	Password string `json:"password" xml:"password" yaml:"password"`
+}
+
+type batchResult struct {
+	Status int            `json:"status" xml:"status" yaml:"status"`
+	User   interface{}    `json:"user,omitempty" xml:"user,omitempty" yaml:"user,omitempty"`
+	Error  string         `json:"error,omitempty" xml:"error,omitempty" yaml:"error,omitempty"`
+	Errors []problemField `json:"errors,omitempty" xml:"errors>error,omitempty" yaml:"errors,omitempty"`
+}
+
+// applyBatchOperation runs a single operation of a batch against q, which is
+// either the database or the batch's transaction.
+func applyBatchOperation(q model.Querier, audit model.Audit, op batchOperation) (*model.User, error) {
+	switch op.Op {
+	case "create":
+		return model.CreateUser(q, audit, op.Name, op.Email, op.Password)
+	case "update":
+		return model.UpdateUser(q, audit, op.Id, op.Name, op.Email, op.Password)
+	case "delete":
+		return model.DeleteUser(q, audit, op.Id)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownBatchOp, op.Op)
+	}
+}
+
+func batchResultFor(r *http.Request, user *model.User, err error) batchResult {
+	if err == nil {
+		return batchResult{Status: 200, User: renderUser(r, user)}
+	}
+	status, fields := mutationErrorStatus(err)
+	if errors.Is(err, errUnknownBatchOp) {
+		status = 400
+	}
+	result := batchResult{Status: status, Error: err.Error()}
+	locale := requestLocale(r)
+	for _, f := range fields {
+		result.Errors = append(result.Errors, problemField{Field: f.Field, Code: f.Code, Message: f.Message(locale)})
+	}
+	return result
+}
+
+// batchUsersHandler applies a list of create, update and delete operations.
+// By default they run in one transaction and either all apply or none do;
+// with ?atomic=false each operation is applied on its own.
+func batchUsersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var ops []batchOperation
+	if err := decodeBody(r, &ops); err != nil {
+		writeParseError(w, err)
+		return
+	}
+	if len(ops) == 0 || len(ops) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("a batch must have between 1 and %d operations", maxBatchOperations), 400)
+		return
+	}
+
+	results := make([]batchResult, len(ops))
+	if r.URL.Query().Get("atomic") == "false" {
+		for i, op := range ops {
+			user, err := applyBatchOperation(db, requestAudit(r.Context()), op)
+			results[i] = batchResultFor(r, user, err)
+		}
+		marshalAndWrite(results, w, r)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for i, op := range ops {
+		user, err := applyBatchOperation(tx, requestAudit(r.Context()), op)
+		results[i] = batchResultFor(r, user, err)
+		if err == nil {
+			continue
+		}
+		log.Println(err)
+		if err := tx.Rollback(); err != nil {
+			log.Println(err)
+		}
+		// Nothing in the batch was applied, so every other operation is
+		// reported as failed because of this one.
+		for j := range results {
+			if j != i {
+				results[j] = batchResult{Status: http.StatusFailedDependency, Error: fmt.Sprintf("operation %d failed", i)}
+			}
+		}
+		marshalAndWriteStatus(results, results[i].Status, w, r)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	marshalAndWrite(results, w, r)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func postBatch(router http.Handler, url string, payload string) ([]batchResult, *http.Response, error) {
+	request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	resp := recorder.Result()
+	results := []batchResult{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &results)
+	return results, resp, err
+}
+
+const batchPayload = `[
+	{"op": "create", "name": "Kaladin", "email": "k@s.com", "password": "password"},
+	{"op": "update", "id": 2, "name": "Adolin", "email": "a@k.com", "password": "password"},
+	{"op": "delete", "id": 3}
+]`
+
+func TestHandleBatchUsersAtomicOk(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	mock.ExpectPrepare("UPDATE")
+	mock.ExpectQuery("UPDATE").WithArgs("Adolin", "a@k.com", hashOf("password"), 2, "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(2, "Adolin", "a@k.com", 2))
+	mock.ExpectPrepare("DELETE")
+	mock.ExpectQuery("DELETE").WithArgs(3, "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).AddRow(3, "Shallan", "s@d.com"))
+	mock.ExpectCommit()
+
+	results, resp, err := postBatch(router, "http://localhost:1234/users:batch", batchPayload)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		require.Equal(t, 200, result.Status)
+	}
+	require.Equal(t, "Shallan", results[2].User.(map[string]interface{})["name"])
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleBatchUsersAtomicRollsBack(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	mock.ExpectPrepare("UPDATE")
+	mock.ExpectQuery("UPDATE").WithArgs("Adolin", "a@k.com", hashOf("password"), 2, "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("sql: no rows in result set"))
+	mock.ExpectRollback()
+
+	results, resp, err := postBatch(router, "http://localhost:1234/users:batch", batchPayload)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, http.StatusFailedDependency, results[0].Status)
+	require.Nil(t, results[0].User)
+	require.Equal(t, http.StatusInternalServerError, results[1].Status)
+	require.Equal(t, http.StatusFailedDependency, results[2].Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleBatchUsersAtomicValidationError(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	results, resp, err := postBatch(router, "http://localhost:1234/users:batch", `[{"op": "create", "name": "Kaladin", "email": "1", "password": "password"}]`)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "email", results[0].Errors[0].Field)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleBatchUsersNonAtomic(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	mock.ExpectPrepare("UPDATE")
+	mock.ExpectQuery("UPDATE").WithArgs("Adolin", "a@k.com", hashOf("password"), 2, "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("error"))
+	mock.ExpectPrepare("DELETE")
+	mock.ExpectQuery("DELETE").WithArgs(3, "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).AddRow(3, "Shallan", "s@d.com"))
+
+	results, resp, err := postBatch(router, "http://localhost:1234/users:batch?atomic=false", batchPayload)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 200, results[0].Status)
+	require.Equal(t, 500, results[1].Status)
+	require.Equal(t, 200, results[2].Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleBatchUsersUnknownOp(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	results, resp, err := postBatch(router, "http://localhost:1234/users:batch?atomic=false", `[{"op": "merge", "id": 1}]`)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 400, results[0].Status)
+	require.Equal(t, "unknown op \"merge\"", results[0].Error)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleBatchUsersBadBody(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	for _, payload := range []string{"{", "[]"} {
+		request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users:batch", strings.NewReader(payload))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusBadRequest, recorder.Code, payload)
+	}
+}
@@ -0,0 +1,301 @@
+// Package client is a Go client for the users API.
+//
+// The API does not authenticate callers itself. It trusts the proxy in
+// front of it to do so and to pass the caller's identity on in
+// X-Forwarded-User. Clients that must present credentials to that proxy set
+// them up in HTTPClient, for instance with a Transport that adds them to
+// every request.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 100 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+	defaultPageSize   = 100
+)
+
+// User is a user as served by version 2 of the API.
+type User struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserInput holds the fields of a create or update.
+type UserInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a non-2xx response. Problem+json bodies fill in every field;
+// other bodies end up in Detail.
+type Error struct {
+	StatusCode int          `json:"-"`
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Detail     string       `json:"detail"`
+	Errors     []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("users api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func IsInvalid(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// Client calls the API at BaseURL. Requests that are safe to repeat are
+// retried with exponential backoff after network errors and 429, 502, 503
+// and 504 responses.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+	// PageSize is how many users Users fetches per request.
+	PageSize int
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: defaultMaxRetries,
+		Backoff:    defaultBackoff,
+		PageSize:   defaultPageSize,
+	}
+}
+
+func (c *Client) ListUsers(ctx context.Context) ([]*User, error) {
+	users := []*User{}
+	it := c.Users(ctx)
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	return users, it.Err()
+}
+
+func (c *Client) GetUser(ctx context.Context, id string) (*User, error) {
+	user := &User{}
+	err := c.do(ctx, http.MethodGet, "/v2/users/"+url.PathEscape(id), nil, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *Client) CreateUser(ctx context.Context, input *UserInput) (*User, error) {
+	user := &User{}
+	err := c.do(ctx, http.MethodPost, "/v2/users", input, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *Client) UpdateUser(ctx context.Context, id string, input *UserInput) (*User, error) {
+	user := &User{}
+	err := c.do(ctx, http.MethodPut, "/v2/users/"+url.PathEscape(id), input, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser deletes a user and returns it as it was.
+func (c *Client) DeleteUser(ctx context.Context, id string) (*User, error) {
+	user := &User{}
+	err := c.do(ctx, http.MethodDelete, "/v2/users/"+url.PathEscape(id), nil, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+type page struct {
+	Data  []*User `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// UserIterator walks the user list page by page, fetching each page as it
+// is reached.
+type UserIterator struct {
+	c     *Client
+	ctx   context.Context
+	next  string
+	users []*User
+	user  *User
+	err   error
+}
+
+// Users returns an iterator over every user, fetched PageSize at a time.
+// Pages are followed through the envelope's next link.
+func (c *Client) Users(ctx context.Context) *UserIterator {
+	return &UserIterator{c: c, ctx: ctx, next: "/v2/users?envelope=true&limit=" + strconv.Itoa(c.PageSize)}
+}
+
+func (it *UserIterator) Next() bool {
+	for len(it.users) == 0 {
+		if it.err != nil || it.next == "" {
+			return false
+		}
+		p := &page{}
+		if err := it.c.do(it.ctx, http.MethodGet, it.next, nil, p); err != nil {
+			it.err = err
+			return false
+		}
+		it.users, it.next = p.Data, p.Links.Next
+	}
+	it.user, it.users = it.users[0], it.users[1:]
+	return true
+}
+
+func (it *UserIterator) User() *User {
+	return it.user
+}
+
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay is how long to wait before retry attempt n, honouring Retry-After.
+func (c *Client) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	d := c.Backoff << uint(attempt)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	// Full jitter keeps clients that failed together from retrying together.
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body)
+		// POST is not idempotent, so it is never repeated.
+		canRetry := attempt < c.MaxRetries && method != http.MethodPost
+		if err != nil {
+			if !canRetry || ctx.Err() != nil {
+				return err
+			}
+		} else if !canRetry || !retryable(resp.StatusCode) {
+			return decodeResponse(resp, out)
+		} else {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(c.delay(attempt, resp))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.HTTPClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(body, out)
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" && json.Unmarshal(body, apiErr) == nil {
+		return apiErr
+	}
+	apiErr.Title = http.StatusText(resp.StatusCode)
+	apiErr.Detail = strings.TrimSpace(string(body))
+	return apiErr
+}
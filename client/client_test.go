@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	c := New(server.URL + "/")
+	c.Backoff = time.Millisecond
+	return c, server
+}
+
+func TestGetUserRetriesUnavailable(t *testing.T) {
+	var calls int32
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.Equal(t, "/v2/users/1", r.URL.Path)
+		io.WriteString(w, `{"id":"1","name":"Kaladin","email":"k@s.com"}`)
+	})
+	defer server.Close()
+
+	user, err := c.GetUser(context.Background(), "1")
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: "1", Name: "Kaladin", Email: "k@s.com"}, user)
+	require.Equal(t, int32(3), calls)
+}
+
+func TestGetUserGivesUpAfterMaxRetries(t *testing.T) {
+	var calls int32
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+	c.MaxRetries = 2
+
+	_, err := c.GetUser(context.Background(), "1")
+
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, err.(*Error).StatusCode)
+	require.Equal(t, int32(3), calls)
+}
+
+func TestCreateUserIsNotRetried(t *testing.T) {
+	var calls int32
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	_, err := c.CreateUser(context.Background(), &UserInput{Name: "Kaladin"})
+
+	require.Error(t, err)
+	require.Equal(t, int32(1), calls)
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.GetUser(ctx, "1")
+
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestProblemErrorsAreDecoded(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"type":"about:blank","title":"Conflict","status":409,"detail":"email already in use","errors":[{"field":"email","code":"taken","message":"is already in use"}]}`)
+	})
+	defer server.Close()
+
+	_, err := c.UpdateUser(context.Background(), "1", &UserInput{})
+
+	require.True(t, IsConflict(err))
+	apiErr := err.(*Error)
+	require.Equal(t, []FieldError{{Field: "email", Code: "taken", Message: "is already in use"}}, apiErr.Errors)
+	require.Equal(t, "users api: 409 Conflict: email already in use", err.Error())
+}
+
+func TestPlainTextErrorsAreDecoded(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "sql: no rows in result set", http.StatusNotFound)
+	})
+	defer server.Close()
+
+	_, err := c.DeleteUser(context.Background(), "1")
+
+	require.True(t, IsNotFound(err))
+	require.Equal(t, "sql: no rows in result set", err.(*Error).Detail)
+}
+
+func TestUsersFollowsNextLinks(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "2", r.URL.Query().Get("limit"))
+		if r.URL.Query().Get("after") == "2" {
+			io.WriteString(w, `{"data":[{"id":"3"}],"links":{}}`)
+			return
+		}
+		io.WriteString(w, `{"data":[{"id":"1"},{"id":"2"}],"links":{"next":"/v2/users?after=2&envelope=true&limit=2"}}`)
+	})
+	defer server.Close()
+	c.PageSize = 2
+
+	ids := []string{}
+	it := c.Users(context.Background())
+	for it.Next() {
+		ids = append(ids, it.User().Id)
+	}
+
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"1", "2", "3"}, ids)
+}
+
+func TestDelayHonoursRetryAfter(t *testing.T) {
+	c := New("http://localhost")
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+
+	require.Equal(t, 2*time.Second, c.delay(0, resp))
+	require.True(t, c.delay(30, nil) <= maxBackoff)
+}
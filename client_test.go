@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/client"
+)
+
+func TestClientAgainstRouter(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	// One user a page, so the second user is on the next page.
+	c.PageSize = 1
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	rows.AddRow("2", "Adolin", "a@k.com")
+	mock.ExpectQuery("SELECT .+ LIMIT").WithArgs(2).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT .+ WHERE id>").WithArgs(1, 2).WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).AddRow("2", "Adolin", "a@k.com"))
+	users, err := c.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*client.User{{Id: "1", Name: "Kaladin", Email: "k@s.com"}, {Id: "2", Name: "Adolin", Email: "a@k.com"}}, users)
+
+	mock.ExpectQuery("SELECT").WillReturnRows(mock.NewRows([]string{"id", "name", "email"}))
+	users, err = c.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Empty(t, users)
+
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	user, err := c.GetUser(ctx, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Kaladin", user.Name)
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	user, err = c.CreateUser(ctx, &client.UserInput{Name: "Kaladin", Email: "k@s.com", Password: "password"})
+	require.NoError(t, err)
+	require.Equal(t, "1", user.Id)
+
+	_, err = c.CreateUser(ctx, &client.UserInput{Name: "Kaladin", Email: "k@s", Password: "password"})
+	require.True(t, client.IsInvalid(err))
+	require.Equal(t, "email", err.(*client.Error).Errors[0].Field)
+
+	mock.ExpectPrepare("UPDATE")
+	mock.ExpectQuery("UPDATE").WithArgs("Kal", "k@s.com", hashOf("password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 2))
+	user, err = c.UpdateUser(ctx, "1", &client.UserInput{Name: "Kal", Email: "k@s.com", Password: "password"})
+	require.NoError(t, err)
+	require.Equal(t, "Kal", user.Name)
+
+	mock.ExpectPrepare("DELETE")
+	mock.ExpectQuery("DELETE").WithArgs(1, "anonymous", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email"}))
+	_, err = c.DeleteUser(ctx, "1")
+	require.True(t, client.IsNotFound(err))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+const bashCompletion = `_usersctl() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	-o|-output)
+		COMPREPLY=($(compgen -W "table json yaml" -- "$cur")); return ;;
+	-context)
+		COMPREPLY=($(compgen -W "$(usersctl config get-contexts 2>/dev/null)" -- "$cur")); return ;;
+	config)
+		COMPREPLY=($(compgen -W "get-contexts set-context use-context" -- "$cur")); return ;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh" -- "$cur")); return ;;
+	esac
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "-context -server -o" -- "$cur"))
+	fi
+}
+complete -F _usersctl usersctl
+`
+
+// zsh can run bash completion functions through bashcompinit.
+const zshCompletion = `autoload -U +X bashcompinit && bashcompinit
+`
+
+func writeCompletion(w io.Writer, shell string) error {
+	script := fmt.Sprintf(bashCompletion, commandNames())
+	switch shell {
+	case "bash":
+		_, err := io.WriteString(w, script)
+		return err
+	case "zsh":
+		_, err := io.WriteString(w, zshCompletion+script)
+		return err
+	default:
+		return fmt.Errorf("unknown shell %q, want bash or zsh", shell)
+	}
+}
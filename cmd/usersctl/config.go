@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Context is a named API endpoint.
+type Context struct {
+	BaseURL string `yaml:"base-url"`
+}
+
+// Config is the usersctl configuration file.
+type Config struct {
+	CurrentContext string              `yaml:"current-context"`
+	Contexts       map[string]*Context `yaml:"contexts"`
+}
+
+// configPath is $USERSCTL_CONFIG, or usersctl/config.yaml in the user's
+// config directory.
+func configPath() (string, error) {
+	if path := os.Getenv("USERSCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usersctl", "config.yaml"), nil
+}
+
+// loadConfig reads the configuration file, returning an empty configuration
+// if it does not exist yet.
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Contexts: map[string]*Context{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.Contexts == nil {
+		config.Contexts = map[string]*Context{}
+	}
+	return config, nil
+}
+
+func (c *Config) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The file lists the servers its owner works with, which are no one
+	// else's business.
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// context returns the named context, or the current one when name is empty.
+func (c *Config) context(name string) (*Context, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no context selected; use -server or `usersctl config set-context`")
+	}
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown context %q", name)
+	}
+	return ctx, nil
+}
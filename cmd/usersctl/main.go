@@ -0,0 +1,301 @@
+// Command usersctl manages users on a remote users API.
+//
+//	usersctl [-context name] [-server url] [-o table|json|yaml] command [args]
+//
+// Commands:
+//
+//	list                                      list all users
+//	get ID                                    show one user
+//	create -name N -email E -password P       create a user
+//	update ID -name N -email E -password P    replace a user
+//	delete ID                                 delete a user
+//	config get-contexts                       list the configured contexts
+//	config set-context NAME -server URL       add or change a context
+//	config use-context NAME                   select the current context
+//	completion bash|zsh                       print a shell completion script
+//
+// Contexts are read from $USERSCTL_CONFIG, or usersctl/config.yaml in the
+// user's config directory.
+//
+// The API trusts the proxy in front of it to authenticate callers, so
+// usersctl sends no credentials of its own; a context's URL should reach
+// the API through a proxy that identifies the operator, or directly from a
+// trusted network.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tammiec/go-rest-api/client"
+)
+
+type options struct {
+	context string
+	server  string
+	output  string
+}
+
+type command struct {
+	name string
+	run  func(ctx context.Context, opts *options, args []string, stdout io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", listCommand},
+		{"get", getCommand},
+		{"create", createCommand},
+		{"update", updateCommand},
+		{"delete", deleteCommand},
+		{"config", configCommand},
+		{"completion", completionCommand},
+	}
+}
+
+func commandNames() string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return strings.Join(names, " ")
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "usersctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	opts := &options{}
+	flags := flag.NewFlagSet("usersctl", flag.ContinueOnError)
+	flags.StringVar(&opts.context, "context", "", "configuration context to use (default: the current context)")
+	flags.StringVar(&opts.server, "server", "", "base URL of the API, overriding the context")
+	flags.StringVar(&opts.output, "o", outputTable, "output format: table, json or yaml")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: usersctl [flags] %s\n", strings.ReplaceAll(commandNames(), " ", "|"))
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no command given")
+	}
+	for _, c := range commands {
+		if c.name == flags.Arg(0) {
+			return c.run(ctx, opts, flags.Args()[1:], stdout)
+		}
+	}
+	return fmt.Errorf("unknown command %q", flags.Arg(0))
+}
+
+// newClient builds an API client from the selected context, with -server
+// taking precedence.
+func newClient(opts *options) (*client.Client, error) {
+	baseURL := opts.server
+	if baseURL == "" {
+		path, err := configPath()
+		if err != nil {
+			return nil, err
+		}
+		config, err := loadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		selected, err := config.context(opts.context)
+		if err != nil {
+			return nil, err
+		}
+		baseURL = selected.BaseURL
+	}
+	return client.New(baseURL), nil
+}
+
+// userArgs parses the id, when wantId is set, and the user fields of the
+// create and update commands. The id may come before or after the flags.
+func userArgs(name string, args []string, wantId bool) (string, *client.UserInput, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	input := &client.UserInput{}
+	flags.StringVar(&input.Name, "name", "", "user name")
+	flags.StringVar(&input.Email, "email", "", "email address")
+	flags.StringVar(&input.Password, "password", "", "password")
+
+	var id string
+	if wantId && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return "", nil, err
+	}
+	if wantId && id == "" {
+		id = flags.Arg(0)
+	}
+	if wantId && id == "" {
+		return "", nil, fmt.Errorf("%s: missing user id", name)
+	}
+	return id, input, nil
+}
+
+func idArg(name string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: usersctl %s ID", name)
+	}
+	return args[0], nil
+}
+
+func listCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	users, err := c.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	return writeUsers(stdout, opts.output, users)
+}
+
+func getCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	id, err := idArg("get", args)
+	if err != nil {
+		return err
+	}
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	user, err := c.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeUser(stdout, opts.output, user)
+}
+
+func createCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	_, input, err := userArgs("create", args, false)
+	if err != nil {
+		return err
+	}
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	user, err := c.CreateUser(ctx, input)
+	if err != nil {
+		return err
+	}
+	return writeUser(stdout, opts.output, user)
+}
+
+func updateCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	id, input, err := userArgs("update", args, true)
+	if err != nil {
+		return err
+	}
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	user, err := c.UpdateUser(ctx, id, input)
+	if err != nil {
+		return err
+	}
+	return writeUser(stdout, opts.output, user)
+}
+
+func deleteCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	id, err := idArg("delete", args)
+	if err != nil {
+		return err
+	}
+	c, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	user, err := c.DeleteUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeUser(stdout, opts.output, user)
+}
+
+func configCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: usersctl config get-contexts|set-context|use-context")
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "get-contexts":
+		names := make([]string, 0, len(config.Contexts))
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	case "set-context":
+		flags := flag.NewFlagSet("set-context", flag.ContinueOnError)
+		server := flags.String("server", "", "base URL of the API")
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: usersctl config set-context NAME -server URL")
+		}
+		name := args[1]
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+		selected, ok := config.Contexts[name]
+		if !ok {
+			selected = &Context{}
+			config.Contexts[name] = selected
+		}
+		if *server != "" {
+			selected.BaseURL = *server
+		}
+		if selected.BaseURL == "" {
+			return fmt.Errorf("context %q needs a -server", name)
+		}
+		if config.CurrentContext == "" {
+			config.CurrentContext = name
+		}
+		return config.save(path)
+	case "use-context":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: usersctl config use-context NAME")
+		}
+		if _, ok := config.Contexts[args[1]]; !ok {
+			return fmt.Errorf("unknown context %q", args[1])
+		}
+		config.CurrentContext = args[1]
+		return config.save(path)
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+}
+
+func completionCommand(ctx context.Context, opts *options, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: usersctl completion bash|zsh")
+	}
+	return writeCompletion(stdout, args[0])
+}
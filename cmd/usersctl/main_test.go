@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "usersctl")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "config.yaml")
+	os.Setenv("USERSCTL_CONFIG", path)
+	return path, func() {
+		os.Unsetenv("USERSCTL_CONFIG")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetPrintsTable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v2/users/1", r.URL.Path)
+		io.WriteString(w, `{"id":"1","name":"Kaladin","email":"k@s.com"}`)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-server", server.URL, "get", "1"}, &out)
+
+	require.NoError(t, err)
+	require.Equal(t, "ID  NAME     EMAIL\n1   Kaladin  k@s.com\n", out.String())
+}
+
+func TestListPrintsYaml(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"data":[{"id":"1","name":"Kaladin","email":"k@s.com"}]}`)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-server", server.URL, "-o", "yaml", "list"}, &out)
+
+	require.NoError(t, err)
+	require.Equal(t, "- id: \"1\"\n  name: Kaladin\n  email: k@s.com\n", out.String())
+}
+
+func TestUpdateSendsFlags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/v2/users/7", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		require.JSONEq(t, `{"name":"Shallan","email":"s@d.com","password":"password1"}`, string(body))
+		io.WriteString(w, `{"id":"7","name":"Shallan","email":"s@d.com"}`)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-server", server.URL, "-o", "json", "update", "7",
+		"-name", "Shallan", "-email", "s@d.com", "-password", "password1"}, &out)
+
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"7","name":"Shallan","email":"s@d.com"}`, out.String())
+}
+
+func TestContextsSelectServer(t *testing.T) {
+	_, cleanup := withConfig(t)
+	defer cleanup()
+	listed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed = true
+		io.WriteString(w, `{"data":[]}`)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	require.NoError(t, run(ctx, []string{"config", "set-context", "prod", "-server", "http://127.0.0.1:1"}, ioutil.Discard))
+	require.NoError(t, run(ctx, []string{"config", "set-context", "staging", "-server", server.URL}, ioutil.Discard))
+	require.Error(t, run(ctx, []string{"config", "use-context", "dev"}, ioutil.Discard))
+	require.NoError(t, run(ctx, []string{"config", "use-context", "staging"}, ioutil.Discard))
+
+	var out bytes.Buffer
+	require.NoError(t, run(ctx, []string{"config", "get-contexts"}, &out))
+	require.Equal(t, "prod\nstaging\n", out.String())
+	require.NoError(t, run(ctx, []string{"list"}, ioutil.Discard))
+	require.True(t, listed)
+}
+
+func TestMissingContext(t *testing.T) {
+	_, cleanup := withConfig(t)
+	defer cleanup()
+
+	err := run(context.Background(), []string{"list"}, ioutil.Discard)
+
+	require.EqualError(t, err, "no context selected; use -server or `usersctl config set-context`")
+}
+
+func TestCompletion(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, run(context.Background(), []string{"completion", "bash"}, &out))
+	require.Contains(t, out.String(), "list get create update delete config completion")
+	require.Error(t, run(context.Background(), []string{"completion", "fish"}, &out))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/tammiec/go-rest-api/client"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJson  = "json"
+	outputYaml  = "yaml"
+)
+
+// yamlUser gives YAML output the same field names as JSON output.
+type yamlUser struct {
+	Id    string `yaml:"id"`
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+func writeUsers(w io.Writer, format string, users []*client.User) error {
+	switch format {
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tEMAIL")
+		for _, user := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", user.Id, user.Name, user.Email)
+		}
+		return tw.Flush()
+	case outputJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(users)
+	case outputYaml:
+		list := make([]yamlUser, len(users))
+		for i, user := range users {
+			list[i] = yamlUser{Id: user.Id, Name: user.Name, Email: user.Email}
+		}
+		return yaml.NewEncoder(w).Encode(list)
+	default:
+		return fmt.Errorf("unknown output format %q, want table, json or yaml", format)
+	}
+}
+
+// writeUser prints a single user; JSON and YAML output is an object rather
+// than a one-element list.
+func writeUser(w io.Writer, format string, user *client.User) error {
+	switch format {
+	case outputJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(user)
+	case outputYaml:
+		return yaml.NewEncoder(w).Encode(yamlUser{Id: user.Id, Name: user.Name, Email: user.Email})
+	default:
+		return writeUsers(w, format, []*client.User{user})
+	}
+}
@@ -0,0 +1,205 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec encodes and decodes request and response bodies of one media type.
+type Codec struct {
+	MediaType string
+	Marshal   func(interface{}) ([]byte, error)
+	Unmarshal func([]byte, interface{}) error
+}
+
+// registry is in preference order: when a client accepts several media
+// types equally, the earliest registered one wins.
+var registry []*Codec
+
+func init() {
+	Register(&Codec{MediaType: "application/json", Marshal: json.Marshal, Unmarshal: json.Unmarshal})
+	Register(&Codec{MediaType: "application/xml", Marshal: marshalXml, Unmarshal: unmarshalXml})
+	Register(&Codec{MediaType: "application/yaml", Marshal: yaml.Marshal, Unmarshal: yaml.Unmarshal})
+	Register(&Codec{MediaType: "application/msgpack", Marshal: marshalMsgpack, Unmarshal: unmarshalMsgpack})
+	Register(&Codec{MediaType: "application/cbor", Marshal: cbor.Marshal, Unmarshal: cbor.Unmarshal})
+}
+
+// Register adds c to the registry, replacing any codec for the same media
+// type.
+func Register(c *Codec) {
+	for i, existing := range registry {
+		if existing.MediaType == c.MediaType {
+			registry[i] = c
+			return
+		}
+	}
+	registry = append(registry, c)
+}
+
+// Default is the codec used when a client expresses no preference.
+func Default() *Codec {
+	return registry[0]
+}
+
+// ForContentType returns the codec for a Content-Type header value, ignoring
+// parameters such as charset, or nil if none is registered.
+func ForContentType(contentType string) *Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, c := range registry {
+		if c.MediaType == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// specificity ranks exact types above "type/*" above "*/*".
+func (a acceptRange) specificity() int {
+	switch {
+	case a.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(a.mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (a acceptRange) matches(mediaType string) bool {
+	switch a.specificity() {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*"))
+	default:
+		return a.mediaType == mediaType || a.hasSuffixOf(mediaType)
+	}
+}
+
+// hasSuffixOf reports whether the range is a structured syntax type for
+// mediaType, e.g. "application/vnd.users.v2+json" for "application/json".
+func (a acceptRange) hasSuffixOf(mediaType string) bool {
+	i := strings.Index(mediaType, "/")
+	plus := strings.LastIndex(a.mediaType, "+")
+	return plus >= 0 && a.mediaType[plus+1:] == mediaType[i+1:]
+}
+
+// Negotiate picks the codec that best satisfies an Accept header, honouring
+// q-values. An empty header accepts anything. It returns nil when no
+// registered codec is acceptable.
+func Negotiate(accept string) *Codec {
+	if strings.TrimSpace(accept) == "" {
+		return Default()
+	}
+	ranges := parseAccept(accept)
+
+	var best *Codec
+	bestQ := 0.0
+	for _, c := range registry {
+		// The most specific range matching a media type decides its q-value.
+		var match *acceptRange
+		for i := range ranges {
+			r := &ranges[i]
+			if r.matches(c.MediaType) && (match == nil || r.specificity() > match.specificity()) {
+				match = r
+			}
+		}
+		if match != nil && match.q > bestQ {
+			best, bestQ = c, match.q
+		}
+	}
+	return best
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// xmlList gives slices the single root element that XML documents need.
+type xmlList struct {
+	XMLName xml.Name      `xml:"list"`
+	Items   []interface{} `xml:"item"`
+}
+
+func marshalXml(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		list := xmlList{Items: make([]interface{}, rv.Len())}
+		for i := range list.Items {
+			list.Items[i] = rv.Index(i).Interface()
+		}
+		v = list
+	}
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// unmarshalXml reads slices from the <list> form that marshalXml writes,
+// whatever the element names of the items.
+func unmarshalXml(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return xml.Unmarshal(data, v)
+	}
+	listType := reflect.StructOf([]reflect.StructField{
+		{Name: "Items", Type: rv.Elem().Type(), Tag: `xml:",any"`},
+	})
+	list := reflect.New(listType)
+	if err := xml.Unmarshal(data, list.Interface()); err != nil {
+		return err
+	}
+	rv.Elem().Set(list.Elem().Field(0))
+	return nil
+}
+
+// The MessagePack codec reads json struct tags, as the CBOR one does, so
+// that types only need to be tagged once.
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalMsgpack(data []byte, v interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(v)
+}
@@ -0,0 +1,93 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	Id   int    `json:"id" xml:"id" yaml:"id"`
+	Name string `json:"name" xml:"name" yaml:"name"`
+}
+
+func TestNegotiateEmptyAcceptUsesDefault(t *testing.T) {
+	require.Equal(t, "application/json", Negotiate("").MediaType)
+}
+
+func TestNegotiateExact(t *testing.T) {
+	require.Equal(t, "application/xml", Negotiate("application/xml").MediaType)
+	require.Equal(t, "application/cbor", Negotiate("application/cbor").MediaType)
+}
+
+func TestNegotiateQValues(t *testing.T) {
+	require.Equal(t, "application/yaml", Negotiate("application/json;q=0.5, application/yaml").MediaType)
+	require.Equal(t, "application/msgpack", Negotiate("application/msgpack;q=0.9, */*;q=0.1").MediaType)
+}
+
+func TestNegotiateWildcards(t *testing.T) {
+	require.Equal(t, "application/json", Negotiate("*/*").MediaType)
+	require.Equal(t, "application/json", Negotiate("text/html, application/*;q=0.8").MediaType)
+}
+
+func TestNegotiateMoreSpecificRangeWins(t *testing.T) {
+	require.Equal(t, "application/xml", Negotiate("application/*, application/json;q=0").MediaType)
+}
+
+func TestNegotiateStructuredSyntaxSuffix(t *testing.T) {
+	require.Equal(t, "application/json", Negotiate("application/vnd.users.v2+json").MediaType)
+	require.Equal(t, "application/xml", Negotiate("application/vnd.users.v2+xml").MediaType)
+	require.Nil(t, Negotiate("application/vnd.users.v2+protobuf"))
+}
+
+func TestNegotiateNoMatch(t *testing.T) {
+	require.Nil(t, Negotiate("text/html"))
+	require.Nil(t, Negotiate("application/json;q=0"))
+}
+
+func TestForContentType(t *testing.T) {
+	require.Equal(t, "application/json", ForContentType("application/json; charset=utf-8").MediaType)
+	require.Nil(t, ForContentType("text/plain"))
+	require.Nil(t, ForContentType(""))
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range registry {
+		body, err := c.Marshal(&user{Id: 1, Name: "Kaladin"})
+		require.NoError(t, err, c.MediaType)
+
+		result := &user{}
+		require.NoError(t, c.Unmarshal(body, result), c.MediaType)
+		require.Equal(t, &user{Id: 1, Name: "Kaladin"}, result, c.MediaType)
+	}
+}
+
+func TestRoundTripSlice(t *testing.T) {
+	for _, c := range registry {
+		body, err := c.Marshal([]user{{Id: 1, Name: "Kaladin"}, {Id: 2, Name: "Adolin"}})
+		require.NoError(t, err, c.MediaType)
+
+		result := []user{}
+		require.NoError(t, c.Unmarshal(body, &result), c.MediaType)
+		require.Equal(t, []user{{Id: 1, Name: "Kaladin"}, {Id: 2, Name: "Adolin"}}, result, c.MediaType)
+	}
+}
+
+func TestMarshalXmlSliceHasRoot(t *testing.T) {
+	body, err := marshalXml([]user{{Id: 1, Name: "Kaladin"}})
+
+	require.NoError(t, err)
+	require.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<list><item><id>1</id><name>Kaladin</name></item></list>", string(body))
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	original := registry
+	defer func() { registry = original }()
+	registry = append([]*Codec{}, original...)
+
+	replacement := &Codec{MediaType: "application/json"}
+	Register(replacement)
+
+	require.Len(t, registry, len(original))
+	require.Equal(t, replacement, Default())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tammiec/go-rest-api/exporter"
+	"github.com/tammiec/go-rest-api/importer"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+// commandAudit is who the audit log says made the changes of the admin
+// subcommands.
+var commandAudit = model.Audit{Actor: "command-line"}
+
+// runCommand runs one of the admin subcommands instead of the HTTP server.
+func runCommand(db *sql.DB, name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	switch name {
+	case "import":
+		return importCommand(db, args, stdin, stdout)
+	case "export":
+		return exportCommand(db, args, stdout)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// importCommand loads users from a file, or stdin when the file is "-" or
+// missing, and prints the import report.
+//
+//	import [-format csv|ndjson] [-dry-run] [file]
+func importCommand(db *sql.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or ndjson (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate the input without writing anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	input := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+		if *format == "" {
+			*format = formatFromExtension(path)
+		}
+	}
+
+	report, err := importer.Import(db, commandAudit, input, *format, *dryRun)
+	if err != nil {
+		return err
+	}
+	return writeIndentedJson(stdout, report)
+}
+
+// exportCommand writes every user to a file, or stdout when the file is "-"
+// or missing.
+//
+//	export [-format csv|ndjson|json] [file]
+func exportCommand(db *sql.DB, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "csv, ndjson or json (default: from the file extension, else json)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	output := stdout
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		output = f
+		if *format == "" {
+			*format = formatFromExtension(path)
+		}
+	}
+	if *format == "" {
+		*format = exporter.FormatJSON
+	}
+
+	buffered := bufio.NewWriter(output)
+	if err := exporter.Export(db, buffered, *format, defaultApiVersion.user); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func formatFromExtension(path string) string {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return importer.FormatCSV
+	case ".ndjson", ".jsonl":
+		return importer.FormatNDJSON
+	case ".json":
+		return exporter.FormatJSON
+	}
+	return ""
+}
+
+func writeIndentedJson(w io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
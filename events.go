@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tammiec/go-rest-api/events"
+	"github.com/tammiec/go-rest-api/outbox"
+)
+
+// userEventsReplaySize is how many events a client resuming with
+// Last-Event-ID may have missed; further behind, it is told to resynchronize.
+const userEventsReplaySize = 1000
+
+// userEvents carries the user changes published by the outbox relay of any
+// instance sharing the database.
+var userEvents = events.NewBroker()
+
+var (
+	// userEventsStreamDuration ends each stream, lifting the server's
+	// timeouts until then; clients reconnect and resume with Last-Event-ID
+	// after userEventsRetry.
+	userEventsStreamDuration = 5 * time.Minute
+	userEventsRetry          = time.Second
+)
+
+// eventIdPattern matches outbox event ids, which are UUIDs.
+var eventIdPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func writeEvent(w http.ResponseWriter, event events.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, event.Data)
+}
+
+// replayUserEvents returns the events published after the one with lastId.
+// ok is false when that event is unknown or too far back to replay, so the
+// client cannot resume without missing some.
+func replayUserEvents(db *sql.DB, lastId string) (replay []events.Event, ok bool, err error) {
+	published, err := outbox.PublishedAfter(db, lastId, userEventsReplaySize+1)
+	if err == outbox.ErrUnknownEvent || len(published) > userEventsReplaySize {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	for _, event := range published {
+		userEvent, err := outbox.UserEvent(event)
+		if err != nil {
+			return nil, false, err
+		}
+		replay = append(replay, userEvent)
+	}
+	return replay, true, nil
+}
+
+// userEventsHandler streams user changes as server-sent events. Event ids
+// are those of the outbox, so a client reconnecting to any instance first
+// gets the events published after its Last-Event-ID. When those cannot be
+// replayed, a "reset" event tells the client to resynchronize instead.
+func userEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", 500)
+		return
+	}
+	lastId := strings.ToLower(r.Header.Get("Last-Event-ID"))
+	if lastId != "" && !eventIdPattern.MatchString(lastId) {
+		http.Error(w, "Last-Event-ID must be an event id", 400)
+		return
+	}
+
+	extendDeadlines(w, userEventsStreamDuration+time.Second)
+	// Subscribing first means no event is missed between the replay and the
+	// stream; those in both are only sent once.
+	stream, cancel := userEvents.Subscribe()
+	defer cancel()
+	var replay []events.Event
+	ok = true
+	if lastId != "" {
+		var err error
+		if replay, ok, err = replayUserEvents(db, lastId); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", userEventsRetry.Milliseconds())
+	var last events.Event
+	if ok {
+		for _, event := range replay {
+			writeEvent(w, event)
+			last = event
+		}
+	} else {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	flusher.Flush()
+
+	timeout := time.NewTimer(userEventsStreamDuration)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timeout.C:
+			return
+		case event, open := <-stream:
+			if !open {
+				return
+			}
+			if !event.After(last) {
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package events fans user changes out to subscribers.
+//
+// The broker is fed with the events the outbox relay announces, so it sees
+// every committed change once a relay has published it. Events keep the id
+// and place they have in the outbox, which are the same on every instance
+// and across restarts, so subscribers that reconnect can resume from the
+// outbox where they left off.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	UserCreated = model.EventUserCreated
+	UserUpdated = model.EventUserUpdated
+	UserDeleted = model.EventUserDeleted
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped; it can then reconnect and resume from the outbox.
+const subscriberBuffer = 64
+
+type Event struct {
+	// Id is the id of the outbox event.
+	Id string
+	// Transaction and Sequence place the event in the order the outbox
+	// publishes events in.
+	Transaction uint64
+	Sequence    int64
+	Type        string
+	Data        []byte
+	// User is the changed user, for subscribers that filter events.
+	User *model.User
+}
+
+// After tells whether e is published after other.
+func (e Event) After(other Event) bool {
+	if e.Transaction != other.Transaction {
+		return e.Transaction > other.Transaction
+	}
+	return e.Sequence > other.Sequence
+}
+
+// NewUserEvent returns the event of a change to user, which is rendered the
+// same way as in API responses.
+func NewUserEvent(id string, transaction uint64, sequence int64, eventType string, user *model.User) (Event, error) {
+	data, err := json.Marshal(api.NewUser(user))
+	if err == nil {
+		data, err = api.RenameJson(data)
+	}
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Id: id, Transaction: transaction, Sequence: sequence, Type: eventType, Data: data, User: user}, nil
+}
+
+// Broker delivers each event to every subscriber.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan Event]struct{}{}}
+}
+
+// Publish sends an event to the current subscribers.
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel for the events published from now on. The
+// channel is closed if the subscriber falls too far behind; cancel must be
+// called once the subscriber is done.
+func (b *Broker) Subscribe() (events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
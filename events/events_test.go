@@ -0,0 +1,48 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func TestPublishDeliversToSubscribers(t *testing.T) {
+	broker := NewBroker()
+	events, cancel := broker.Subscribe()
+	defer cancel()
+
+	event, err := NewUserEvent("4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", 740, 1, UserUpdated, &model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"})
+	require.NoError(t, err)
+	broker.Publish(event)
+
+	received := <-events
+	require.Equal(t, "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", received.Id)
+	require.Equal(t, UserUpdated, received.Type)
+	require.Equal(t, `{"id":1,"name":"Kaladin","email":"k@s.com"}`, string(received.Data))
+}
+
+func TestEventAfter(t *testing.T) {
+	event := Event{Transaction: 740, Sequence: 5}
+
+	require.True(t, Event{Transaction: 741, Sequence: 2}.After(event))
+	require.True(t, Event{Transaction: 740, Sequence: 6}.After(event))
+	require.False(t, event.After(event))
+	require.False(t, Event{Transaction: 739, Sequence: 9}.After(event))
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	broker := NewBroker()
+	events, cancel := broker.Subscribe()
+	defer cancel()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.Publish(Event{Type: UserCreated, Data: []byte("{}")})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	require.Equal(t, subscriberBuffer, received)
+}
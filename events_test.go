@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/events"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func withUserEvents(t *testing.T) {
+	broker, duration := userEvents, userEventsStreamDuration
+	userEvents = events.NewBroker()
+	userEventsStreamDuration = 10 * time.Millisecond
+	t.Cleanup(func() {
+		userEvents, userEventsStreamDuration = broker, duration
+	})
+}
+
+var lastTestEventSequence int64
+
+// publishUserEvent publishes a change to user as the next event of the
+// outbox.
+func publishUserEvent(t *testing.T, eventType string, user *model.User) {
+	event, err := events.NewUserEvent("", 1, atomic.AddInt64(&lastTestEventSequence, 1), eventType, user)
+	require.NoError(t, err)
+	userEvents.Publish(event)
+}
+
+const (
+	createdEventId = "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10"
+	deletedEventId = "9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b"
+)
+
+func outboxRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"xid", "id", "event_id", "event_type", "payload", "created_at"})
+}
+
+func TestUserEventsResumeFromLastEventId(t *testing.T) {
+	withUserEvents(t)
+	userEventsStreamDuration = 50 * time.Millisecond
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT xid::text, id FROM outbox WHERE event_id=\\$1 AND published_at IS NOT NULL").
+		WithArgs(createdEventId).WillReturnRows(sqlmock.NewRows([]string{"xid", "id"}).AddRow("740", 1))
+	mock.ExpectQuery("SELECT (.+) FROM outbox WHERE published_at IS NOT NULL AND \\(xid, id\\) > \\(\\$1::xid8, \\$2\\) ORDER BY xid, id").
+		WithArgs("740", 1, userEventsReplaySize+1).
+		WillReturnRows(outboxRows().AddRow("741", 2, deletedEventId, "user.deleted", `{"id" : 1, "name" : "Kaladin", "email" : "k@s.com"}`, time.Now()))
+
+	// The replayed event reaching this instance's broker as well is only
+	// sent once.
+	broker := userEvents
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				event, _ := events.NewUserEvent(deletedEventId, 741, 2, events.UserDeleted, &model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"})
+				broker.Publish(event)
+			}
+		}
+	}()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/events", map[string]string{"Last-Event-ID": createdEventId})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, "retry: 1000\n\nid: "+deletedEventId+"\nevent: user.deleted\ndata: {\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}\n\n", string(body))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserEventsResetForUnknownEvent(t *testing.T) {
+	withUserEvents(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	// An id from another database, or of an event since removed.
+	mock.ExpectQuery("SELECT xid::text, id FROM outbox").WithArgs(createdEventId).WillReturnRows(sqlmock.NewRows([]string{"xid", "id"}))
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/events", map[string]string{"Last-Event-ID": createdEventId})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, strings.HasSuffix(string(body), "event: reset\ndata: {}\n\n"), string(body))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserEventsResetWhenBehind(t *testing.T) {
+	withUserEvents(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT xid::text, id FROM outbox").WillReturnRows(sqlmock.NewRows([]string{"xid", "id"}).AddRow("740", 1))
+	rows := outboxRows()
+	for i := 0; i <= userEventsReplaySize; i++ {
+		rows.AddRow("741", i+2, deletedEventId, "user.deleted", `{}`, time.Now())
+	}
+	mock.ExpectQuery("SELECT (.+) FROM outbox").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/events", map[string]string{"Last-Event-ID": createdEventId})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "retry: 1000\n\nevent: reset\ndata: {}\n\n", string(body))
+}
+
+func TestUserEventsBadLastEventId(t *testing.T) {
+	withUserEvents(t)
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	// Ids used to be counters of a single instance.
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/events", map[string]string{"Last-Event-ID": "12"})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
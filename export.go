@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/tammiec/go-rest-api/exporter"
+)
+
+// trackingWriter records whether anything has been sent, so that errors
+// after streaming has begun are not written into the body.
+type trackingWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *trackingWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// exportUsersHandler streams every user as ?format=csv|ndjson|json, JSON by
+// default, taking up to httpTransferTimeout.
+func exportUsersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	extendDeadlines(w, httpTransferTimeout)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exporter.FormatJSON
+	}
+	contentType, ok := exporter.ContentTypes[format]
+	if !ok {
+		http.Error(w, "unknown export format "+format, 400)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=users."+format)
+
+	tw := &trackingWriter{ResponseWriter: w}
+	err := exporter.Export(db, tw, format, requestVersion(r).version.user)
+	if err != nil {
+		log.Println(err)
+		if !tw.wrote {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), 500)
+		}
+	}
+}
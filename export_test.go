@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleExportUsersCSV(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/export?format=csv", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, "attachment; filename=users.csv", resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "id,name,email\n1,Kaladin,k@s.com\n", string(body))
+}
+
+func TestHandleExportUsersOutlastsServerTimeouts(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	server := newTimingOutServer(router, 50*time.Millisecond)
+	defer server.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillDelayFor(200 * time.Millisecond).WillReturnRows(rows)
+
+	resp, err := http.Get(server.URL + "/users/export?format=csv")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "id,name,email\n1,Kaladin,k@s.com\n", string(body))
+}
+
+func TestHandleExportUsersDefaultsToJson(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnRows(mock.NewRows([]string{"id", "name", "email"}))
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/export", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "[]", string(body))
+}
+
+func TestHandleExportUsersUnknownFormat(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/export?format=xml", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+}
+
+func TestHandleExportUsersSqlError(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnError(errors.New("error"))
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/export?format=ndjson", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, string(body))
+	require.Empty(t, resp.Header.Get("Content-Disposition"))
+}
+
+func TestExportCommandStdout(t *testing.T) {
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	out := &bytes.Buffer{}
+	err := runCommand(db, "export", []string{"-format", "ndjson"}, nil, out)
+
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}\n", out.String())
+}
@@ -0,0 +1,193 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// flushEvery is how many rows are written between flushes of the output.
+const flushEvery = 100
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ContentTypes maps each export format to its media type.
+var ContentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+	FormatJSON:   "application/json",
+}
+
+type flusher interface {
+	Flush()
+}
+
+// rowWriter writes one export format; begin runs before the first row and
+// end after the last, even when there are no rows.
+type rowWriter interface {
+	begin() error
+	row(*model.User) error
+	end() error
+	flush() error
+}
+
+// Export streams every user to w in the given format. Rows go straight from
+// the database cursor to w, which is flushed every flushEvery rows if it
+// has a Flush method, so memory use does not grow with the table. The JSON
+// formats encode whatever render returns for each user.
+func Export(db model.Querier, w io.Writer, format string, render func(*model.User) interface{}) error {
+	var rw rowWriter
+	switch format {
+	case FormatCSV:
+		rw = &csvWriter{w: csv.NewWriter(w)}
+	case FormatNDJSON:
+		rw = &ndjsonWriter{w: w, render: render}
+	case FormatJSON:
+		rw = &jsonWriter{w: w, render: render}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	flush := func() error {
+		if err := rw.flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	n := 0
+	err := model.EachUser(db, func(user *model.User) error {
+		if n == 0 {
+			if err := rw.begin(); err != nil {
+				return err
+			}
+		}
+		n++
+		if err := rw.row(user); err != nil {
+			return err
+		}
+		if n%flushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := rw.begin(); err != nil {
+			return err
+		}
+	}
+	if err := rw.end(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) begin() error {
+	return c.w.Write([]string{"id", "name", "email"})
+}
+
+func (c *csvWriter) row(user *model.User) error {
+	return c.w.Write([]string{strconv.Itoa(user.Id), user.Name, user.Email})
+}
+
+func (c *csvWriter) end() error {
+	return nil
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w      io.Writer
+	render func(*model.User) interface{}
+}
+
+func (n *ndjsonWriter) begin() error {
+	return nil
+}
+
+func (n *ndjsonWriter) row(user *model.User) error {
+	body, err := marshalJson(n.render(user))
+	if err != nil {
+		return err
+	}
+	_, err = n.w.Write(append(body, '\n'))
+	return err
+}
+
+func (n *ndjsonWriter) end() error {
+	return nil
+}
+
+func (n *ndjsonWriter) flush() error {
+	return nil
+}
+
+// jsonWriter writes a single JSON array, one element at a time.
+type jsonWriter struct {
+	w      io.Writer
+	render func(*model.User) interface{}
+	count  int
+}
+
+func (j *jsonWriter) begin() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) row(user *model.User) error {
+	body, err := marshalJson(j.render(user))
+	if err != nil {
+		return err
+	}
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	_, err = j.w.Write(body)
+	return err
+}
+
+func (j *jsonWriter) end() error {
+	_, err := io.WriteString(j.w, "]")
+	return err
+}
+
+func (j *jsonWriter) flush() error {
+	return nil
+}
+
+// marshalJson encodes v with the API's configured field naming.
+func marshalJson(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return api.RenameJson(body)
+}
@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+func expectUsers(mock sqlmock.Sqlmock) {
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow(1, "Kaladin", "k@s.com")
+	rows.AddRow(2, "Adolin, Prince", "a@k.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+}
+
+func render(user *model.User) interface{} {
+	return api.NewUser(user)
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+}
+
+func TestExportCSV(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	expectUsers(mock)
+
+	out := &flushRecorder{}
+	err := Export(db, out, FormatCSV, render)
+
+	require.NoError(t, err)
+	require.Equal(t, "id,name,email\n1,Kaladin,k@s.com\n2,\"Adolin, Prince\",a@k.com\n", out.String())
+	require.Equal(t, 1, out.flushes)
+}
+
+func TestExportNDJSON(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	expectUsers(mock)
+
+	out := &bytes.Buffer{}
+	err := Export(db, out, FormatNDJSON, render)
+
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}\n{\"id\":2,\"name\":\"Adolin, Prince\",\"email\":\"a@k.com\"}\n", out.String())
+}
+
+func TestExportJSON(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	expectUsers(mock)
+
+	out := &bytes.Buffer{}
+	err := Export(db, out, FormatJSON, render)
+
+	require.NoError(t, err)
+	require.Equal(t, "[{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"},{\"id\":2,\"name\":\"Adolin, Prince\",\"email\":\"a@k.com\"}]", out.String())
+}
+
+func TestExportJSONEmpty(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	mock.ExpectQuery("SELECT").WillReturnRows(mock.NewRows([]string{"id", "name", "email"}))
+
+	out := &bytes.Buffer{}
+	err := Export(db, out, FormatJSON, render)
+
+	require.NoError(t, err)
+	require.Equal(t, "[]", out.String())
+}
+
+func TestExportFlushesPeriodically(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	for i := 1; i <= 250; i++ {
+		rows.AddRow(i, "Bridgeman", fmt.Sprintf("b%d@s.com", i))
+	}
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	out := &flushRecorder{}
+	err := Export(db, out, FormatNDJSON, render)
+
+	require.NoError(t, err)
+	require.Equal(t, 250, strings.Count(out.String(), "\n"))
+	require.Equal(t, 3, out.flushes)
+}
+
+func TestExportQueryErrorWritesNothing(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	mock.ExpectQuery("SELECT").WillReturnError(errors.New("Mock Error"))
+
+	out := &bytes.Buffer{}
+	err := Export(db, out, FormatJSON, render)
+
+	require.EqualError(t, err, "Mock Error")
+	require.Equal(t, 0, out.Len())
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	db, _ := getMockDB()
+	defer db.Close()
+
+	err := Export(db, &bytes.Buffer{}, "xml", render)
+
+	require.True(t, errors.Is(err, ErrUnknownFormat))
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+const (
+	graphQLMaxDepth      = 8
+	graphQLMaxComplexity = 1000
+	graphQLDefaultFirst  = 20
+	graphQLMaxFirst      = 100
+)
+
+// graphiQLEnabled serves the GraphiQL IDE to browsers that GET /graphql.
+var graphiQLEnabled = false
+
+// graphQLError carries a machine-readable code, and field errors for invalid
+// input, in the error's extensions.
+type graphQLError struct {
+	message    string
+	extensions map[string]interface{}
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	return e.extensions
+}
+
+// graphQLMutationError maps model errors the same way as mutationErrorStatus.
+func graphQLMutationError(err error) error {
+	status, fields := mutationErrorStatus(err)
+	switch status {
+	case 404:
+		return &graphQLError{"user not found", map[string]interface{}{"code": "NOT_FOUND"}}
+	case 409:
+		return &graphQLError{err.Error(), map[string]interface{}{"code": "CONFLICT", "fields": graphQLFields(fields)}}
+	case 400:
+		return &graphQLError{"invalid user", map[string]interface{}{"code": "BAD_USER_INPUT", "fields": graphQLFields(fields)}}
+	default:
+		log.Println(err)
+		return &graphQLError{"internal error", map[string]interface{}{"code": "INTERNAL"}}
+	}
+}
+
+func graphQLFields(fields validation.Errors) []problemField {
+	result := make([]problemField, len(fields))
+	for i, f := range fields {
+		result[i] = problemField{Field: f.Field, Code: f.Code, Message: f.Message(validation.DefaultLocale)}
+	}
+	return result
+}
+
+func graphQLUser(user *model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":    strconv.Itoa(user.Id),
+		"name":  user.Name,
+		"email": user.Email,
+	}
+}
+
+func graphQLId(p graphql.ResolveParams) (int, error) {
+	id, err := strconv.Atoi(p.Args["id"].(string))
+	if err != nil {
+		return 0, &graphQLError{"id must be numeric", map[string]interface{}{"code": "BAD_USER_INPUT"}}
+	}
+	return id, nil
+}
+
+// Cursors are opaque to clients but are just the user id.
+func userCursor(id int) string {
+	return base64.StdEncoding.EncodeToString([]byte("user:" + strconv.Itoa(id)))
+}
+
+func parseUserCursor(cursor string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(raw), "user:") {
+		if id, err := strconv.Atoi(strings.TrimPrefix(string(raw), "user:")); err == nil {
+			return id, nil
+		}
+	}
+	return 0, &graphQLError{"invalid cursor", map[string]interface{}{"code": "BAD_USER_INPUT"}}
+}
+
+// newUserFilter reads the users query's filter argument.
+func newUserFilter(arg interface{}) model.UserFilter {
+	values, _ := arg.(map[string]interface{})
+	email, _ := values["email"].(string)
+	nameContains, _ := values["nameContains"].(string)
+	return model.UserFilter{Email: email, NameContains: nameContains}
+}
+
+// resolveUsers builds a Relay connection from the users after the cursor in
+// id order, reading one user past the page to learn whether there is a next
+// page.
+func resolveUsers(db *sql.DB, p graphql.ResolveParams) (interface{}, error) {
+	first := graphQLDefaultFirst
+	if arg, ok := p.Args["first"].(int); ok {
+		first = arg
+	}
+	if first < 0 || first > graphQLMaxFirst {
+		return nil, &graphQLError{fmt.Sprintf("first must be between 0 and %d", graphQLMaxFirst), map[string]interface{}{"code": "BAD_USER_INPUT"}}
+	}
+	filter := newUserFilter(p.Args["filter"])
+	if arg, ok := p.Args["after"].(string); ok {
+		var err error
+		if filter.After, err = parseUserCursor(arg); err != nil {
+			return nil, err
+		}
+	}
+	filter.Limit = first + 1
+
+	users, err := model.ListUsers(db, filter)
+	if err != nil {
+		return nil, graphQLMutationError(err)
+	}
+	hasNextPage := len(users) > first
+	if hasNextPage {
+		users = users[:first]
+	}
+	edges := []interface{}{}
+	for _, user := range users {
+		edges = append(edges, map[string]interface{}{"cursor": userCursor(user.Id), "node": graphQLUser(user)})
+	}
+
+	pageInfo := map[string]interface{}{"hasNextPage": hasNextPage, "endCursor": nil}
+	if len(edges) > 0 {
+		pageInfo["endCursor"] = edges[len(edges)-1].(map[string]interface{})["cursor"]
+	}
+	return map[string]interface{}{"edges": edges, "pageInfo": pageInfo}, nil
+}
+
+func graphQLInput(p graphql.ResolveParams) (string, string, string) {
+	input := p.Args["input"].(map[string]interface{})
+	return input["name"].(string), input["email"].(string), input["password"].(string)
+}
+
+// newGraphQLSchema builds the schema with resolvers bound to db.
+func newGraphQLSchema(db *sql.DB) graphql.Schema {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"email": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+				Name: "UserEdge",
+				Fields: graphql.Fields{
+					"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+					"node":   &graphql.Field{Type: graphql.NewNonNull(userType)},
+				},
+			}))))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+				Name: "PageInfo",
+				Fields: graphql.Fields{
+					"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+					"endCursor":   &graphql.Field{Type: graphql.String},
+				},
+			}))},
+		},
+	})
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"email":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"nameContains": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+	inputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"email":    &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"password": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	idArgs := graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := graphQLId(p)
+					if err != nil {
+						return nil, err
+					}
+					user, err := model.GetUser(db, id)
+					if err == sql.ErrNoRows {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, graphQLMutationError(err)
+					}
+					return graphQLUser(user), nil
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: filterType},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return resolveUsers(db, p)
+				},
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputType)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					name, email, password := graphQLInput(p)
+					user, err := model.CreateUser(db, requestAudit(p.Context), name, email, password)
+					if err != nil {
+						return nil, graphQLMutationError(err)
+					}
+					return graphQLUser(user), nil
+				},
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":    idArgs["id"],
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := graphQLId(p)
+					if err != nil {
+						return nil, err
+					}
+					name, email, password := graphQLInput(p)
+					user, err := model.UpdateUser(db, requestAudit(p.Context), id, name, email, password)
+					if err != nil {
+						return nil, graphQLMutationError(err)
+					}
+					return graphQLUser(user), nil
+				},
+			},
+			"deleteUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := graphQLId(p)
+					if err != nil {
+						return nil, err
+					}
+					user, err := model.DeleteUser(db, requestAudit(p.Context), id)
+					if err != nil {
+						return nil, graphQLMutationError(err)
+					}
+					return graphQLUser(user), nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// queryCost walks a parsed query and returns its depth and complexity. Each
+// field costs one, and fields below a users connection cost as many times as
+// the page size the query asks for.
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+func (c *queryCost) selectionSet(set *ast.SelectionSet, multiplier int) (depth int, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var d, n int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			childMultiplier := multiplier
+			if selection.Name.Value == "users" {
+				childMultiplier *= c.first(selection)
+			}
+			d, n = c.selectionSet(selection.SelectionSet, childMultiplier)
+			d, n = d+1, n+multiplier
+		case *ast.InlineFragment:
+			d, n = c.selectionSet(selection.SelectionSet, multiplier)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := c.fragments[name]
+			if !ok || c.visiting[name] {
+				// Unknown and cyclic fragments are left for validation to
+				// reject.
+				continue
+			}
+			c.visiting[name] = true
+			d, n = c.selectionSet(fragment.SelectionSet, multiplier)
+			c.visiting[name] = false
+		}
+		if d > depth {
+			depth = d
+		}
+		complexity += n
+	}
+	return depth, complexity
+}
+
+// first is the page size a users field asks for. Sizes out of range are
+// rejected when the query runs, but cost as much as the largest page here:
+// a negative one would otherwise make the query look cheaper than it is.
+func (c *queryCost) first(field *ast.Field) int {
+	first := graphQLDefaultFirst
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			n, err := strconv.Atoi(value.Value)
+			if err != nil {
+				return graphQLMaxFirst
+			}
+			first = n
+		case *ast.Variable:
+			if n, ok := c.variables[value.Name.Value].(float64); ok {
+				first = int(n)
+			}
+		}
+	}
+	if first < 0 || first > graphQLMaxFirst {
+		return graphQLMaxFirst
+	}
+	return first
+}
+
+// checkGraphQLLimits rejects queries nested deeper than graphQLMaxDepth or
+// costing more than graphQLMaxComplexity before they run.
+func checkGraphQLLimits(query string, variables map[string]interface{}) error {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		// Syntax errors are reported by graphql.Do.
+		return nil
+	}
+	cost := &queryCost{fragments: map[string]*ast.FragmentDefinition{}, variables: variables, visiting: map[string]bool{}}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			cost.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	for _, def := range doc.Definitions {
+		operation, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		depth, complexity := cost.selectionSet(operation.SelectionSet, 1)
+		if depth > graphQLMaxDepth {
+			return fmt.Errorf("query depth %d exceeds the limit of %d", depth, graphQLMaxDepth)
+		}
+		if complexity > graphQLMaxComplexity {
+			return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, graphQLMaxComplexity)
+		}
+	}
+	return nil
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+func writeGraphQLResult(w http.ResponseWriter, status int, result interface{}) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	writeGraphQLResult(w, status, map[string]interface{}{
+		"errors": []map[string]interface{}{{"message": message}},
+	})
+}
+
+// graphQLHandler serves GraphQL over HTTP: queries by GET or POST and
+// mutations by POST only. Requests that cannot be run at all get a 400;
+// errors from resolvers are reported in the result's errors.
+func graphQLHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if r.Method == http.MethodGet {
+			query := r.URL.Query()
+			if query.Get("query") == "" && graphiQLEnabled && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				io.WriteString(w, graphiQLPage)
+				return
+			}
+			req.Query = query.Get("query")
+			req.OperationName = query.Get("operationName")
+			if raw := query.Get("variables"); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+					writeGraphQLError(w, 400, "variables must be a JSON object")
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeGraphQLError(w, 400, "body must be a JSON object with a query")
+			return
+		}
+		if req.Query == "" {
+			writeGraphQLError(w, 400, "query is required")
+			return
+		}
+		if err := checkGraphQLLimits(req.Query, req.Variables); err != nil {
+			writeGraphQLError(w, 400, err.Error())
+			return
+		}
+		if r.Method == http.MethodGet {
+			if doc, err := parser.Parse(parser.ParseParams{Source: req.Query}); err == nil && hasMutation(doc, req.OperationName) {
+				writeGraphQLError(w, http.StatusMethodNotAllowed, "mutations must use POST")
+				return
+			}
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        r.Context(),
+		})
+		writeGraphQLResult(w, 200, result)
+	}
+}
+
+func hasMutation(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		operation, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName != "" && (operation.Name == nil || operation.Name.Value != operationName) {
+			continue
+		}
+		if operation.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+	return false
+}
+
+// graphiQLPage loads exact versions of GraphiQL and React, each checked
+// against its Subresource Integrity hash, so that a changed or compromised
+// CDN file is refused by the browser instead of run with access to the API.
+// When bumping a version, update its hash as well, e.g. with
+// curl -s URL | openssl dgst -sha256 -binary | openssl base64 -A.
+const graphiQLPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Users GraphQL</title>
+    <meta charset="utf-8"/>
+    <link rel="stylesheet" href="https://unpkg.com/graphiql@3.0.6/graphiql.min.css"
+      integrity="sha256-wTzfn13a+pLMB5rMeysPPR1hO7x0SwSeQI+cnw7VdbE=" crossorigin="anonymous"/>
+  </head>
+  <body style="margin: 0">
+    <div id="graphiql" style="height: 100vh"></div>
+    <script src="https://unpkg.com/react@18.2.0/umd/react.production.min.js"
+      integrity="sha256-S0lp+k7zWUMk2ixteM6HZvu8L9Eh//OVrt+ZfbCpmgY=" crossorigin="anonymous"></script>
+    <script src="https://unpkg.com/react-dom@18.2.0/umd/react-dom.production.min.js"
+      integrity="sha256-IXWO0ITNDjfnNXIu5POVfqlgYoop36bDzhodR6LW5Pc=" crossorigin="anonymous"></script>
+    <script src="https://unpkg.com/graphiql@3.0.6/graphiql.min.js"
+      integrity="sha256-eNxH+Ah7Z9up9aJYTQycgyNuy953zYZwE9Rqf5rH+r4=" crossorigin="anonymous"></script>
+    <script>
+      ReactDOM.render(
+        React.createElement(GraphiQL, {fetcher: GraphiQL.createFetcher({url: "/graphql"})}),
+        document.getElementById("graphiql"));
+    </script>
+  </body>
+</html>
+`
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type graphQLTestResult struct {
+	Data   map[string]interface{}   `json:"data"`
+	Errors []map[string]interface{} `json:"errors"`
+}
+
+func postGraphQL(router http.Handler, query string, variables map[string]interface{}) (*graphQLTestResult, *http.Response, error) {
+	payload, _ := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/graphql", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	result := &graphQLTestResult{}
+	err := json.Unmarshal(recorder.Body.Bytes(), result)
+	return result, recorder.Result(), err
+}
+
+func TestGraphQLUser(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
+
+	result, resp, err := postGraphQL(router, `{ user(id: "1") { name } }`, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, result.Errors)
+	require.Equal(t, map[string]interface{}{"user": map[string]interface{}{"name": "Kaladin"}}, result.Data)
+}
+
+func TestGraphQLUsersConnection(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("2", "Adolin", "a@k.com")
+	rows.AddRow("4", "Adolin", "adolin@k.com")
+	mock.ExpectQuery("SELECT id, name, email FROM users WHERE id>\\$1 AND strpos\\(lower\\(name\\), lower\\(\\$2\\)\\)>0 ORDER BY id LIMIT \\$3").
+		WithArgs(1, "adol", 2).WillReturnRows(rows)
+
+	query := `query($after: String) {
+		users(first: 1, after: $after, filter: {nameContains: "adol"}) {
+			edges { cursor node { id } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`
+	result, _, err := postGraphQL(router, query, map[string]interface{}{"after": userCursor(1)})
+
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	users := result.Data["users"].(map[string]interface{})
+	edges := users["edges"].([]interface{})
+	require.Len(t, edges, 1)
+	require.Equal(t, "2", edges[0].(map[string]interface{})["node"].(map[string]interface{})["id"])
+	require.Equal(t, map[string]interface{}{"hasNextPage": true, "endCursor": userCursor(2)}, users["pageInfo"])
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGraphQLCreateUserInvalid(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	query := `mutation { createUser(input: {name: "Kaladin", email: "nope", password: "password1"}) { id } }`
+	result, resp, err := postGraphQL(router, query, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, result.Errors, 1)
+	extensions := result.Errors[0]["extensions"].(map[string]interface{})
+	require.Equal(t, "BAD_USER_INPUT", extensions["code"])
+	require.Equal(t, "email", extensions["fields"].([]interface{})[0].(map[string]interface{})["field"])
+}
+
+func TestGraphQLDepthLimit(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	query := `{ __schema { types { fields { type { ofType { ofType { ofType { ofType { name } } } } } } } } }`
+	result, resp, err := postGraphQL(router, query, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "query depth 9 exceeds the limit of 8", result.Errors[0]["message"])
+}
+
+func TestGraphQLComplexityLimit(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	query := `query($n: Int) {
+		a: users(first: 100) { edges { node { id name email } } }
+		b: users(first: $n) { edges { node { id name email } } }
+	}`
+	result, resp, err := postGraphQL(router, query, map[string]interface{}{"n": 100})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "query complexity 1002 exceeds the limit of 1000", result.Errors[0]["message"])
+}
+
+func TestGraphQLComplexityOfOutOfRangeFirst(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	// A negative page size must not offset the cost of the other fields.
+	query := `query($n: Int) {
+		a: users(first: -1000000) { edges { node { id name email } } }
+		b: users(first: $n) { edges { node { id name email } } }
+	}`
+	result, resp, err := postGraphQL(router, query, map[string]interface{}{"n": 100000})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "query complexity 1002 exceeds the limit of 1000", result.Errors[0]["message"])
+}
+
+func TestGraphQLMutationOverGet(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	query := url.QueryEscape(`mutation { deleteUser(id: "1") { id } }`)
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/graphql?query="+query, nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestGraphiQL(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+	headers := map[string]string{"Accept": "text/html"}
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/graphql", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	graphiQLEnabled = true
+	defer func() { graphiQLEnabled = false }()
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/graphql", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), "GraphiQL")
+
+	// Every asset is pinned to a version and checked against a hash.
+	assets := regexp.MustCompile(`(?:src|href)="(https://[^"]+)"\s+integrity="sha\d+-[^"]+"`).FindAllStringSubmatch(string(body), -1)
+	require.Len(t, assets, 4)
+	for _, asset := range assets {
+		require.Regexp(t, `@\d+\.\d+\.\d+/`, asset[1])
+	}
+}
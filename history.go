@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+// ifMatchVersion reads the user version a write is conditional on from the
+// If-Match header, which holds it as an entity tag such as "3". It returns 0
+// when the write is unconditional, and false when the header names no
+// version, in which case the precondition cannot hold.
+func ifMatchVersion(r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	tag := strings.TrimPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// writeUser writes a single user with its version as the ETag, which is what
+// If-Match takes back. Users read without their version get no ETag.
+func writeUser(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if user.Version > 0 {
+		w.Header().Set("ETag", `"`+strconv.Itoa(user.Version)+`"`)
+	}
+	marshalAndWrite(renderUser(r, user), w, r)
+}
+
+type userVersionResponse struct {
+	Version   int         `json:"version" xml:"version" yaml:"version"`
+	User      interface{} `json:"user" xml:"user" yaml:"user"`
+	ValidFrom time.Time   `json:"valid_from" xml:"valid_from" yaml:"valid_from"`
+	// ValidTo is unset for the current version.
+	ValidTo *time.Time `json:"valid_to,omitempty" xml:"valid_to,omitempty" yaml:"valid_to,omitempty"`
+}
+
+func renderUserVersion(r *http.Request, v *model.UserVersion) *userVersionResponse {
+	result := &userVersionResponse{Version: v.Version, User: renderUser(r, &v.User), ValidFrom: v.ValidFrom}
+	if !v.ValidTo.IsZero() {
+		result.ValidTo = &v.ValidTo
+	}
+	return result
+}
+
+// getUserAsOfHandler serves GET /users/{id}?as_of=, the user as it was at a
+// point in time.
+func getUserAsOfHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, asOf string) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		writeProblem(w, r, 400, err.Error(), validation.Errors{{Field: "as_of", Code: "date_time"}})
+		return
+	}
+	v, err := model.GetUserAsOf(db, id, t)
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	marshalAndWrite(renderUser(r, &v.User), w, r)
+}
+
+func userVersionsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+	versions, err := model.UserVersions(db, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, sql.ErrNoRows.Error(), 404)
+		return
+	}
+	result := make([]*userVersionResponse, len(versions))
+	for i, v := range versions {
+		result[i] = renderUserVersion(r, v)
+	}
+	marshalAndWrite(result, w, r)
+}
+
+// revertUserHandler serves POST /users/{id}/revert?version=N, which restores
+// the user's name and email from version N, honouring If-Match.
+func revertUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		writeProblem(w, r, 400, "version must be a positive integer", validation.Errors{{Field: "version", Code: "minimum", Args: []interface{}{1}}})
+		return
+	}
+	ifVersion, ok := ifMatchVersion(r)
+	if !ok {
+		http.Error(w, model.ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	user, err := model.RevertUser(db, requestAudit(r.Context()), id, version, ifVersion)
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	writeUser(w, r, user)
+}
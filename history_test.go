@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var userVersionRowColumns = []string{"user_id", "version", "name", "email", "valid_from", "valid_to"}
+
+func TestIfMatchVersion(t *testing.T) {
+	for header, want := range map[string]int{"": 0, "*": 0, `"3"`: 3, `W/"12"`: 12} {
+		r, _ := http.NewRequest(http.MethodPut, "/users/1", nil)
+		r.Header.Set("If-Match", header)
+		version, ok := ifMatchVersion(r)
+		require.True(t, ok, header)
+		require.Equal(t, want, version, header)
+	}
+	for _, header := range []string{"3", `"abc"`, `"0"`} {
+		r, _ := http.NewRequest(http.MethodPut, "/users/1", nil)
+		r.Header.Set("If-Match", header)
+		_, ok := ifMatchVersion(r)
+		require.False(t, ok, header)
+	}
+}
+
+func TestGetUserAsOf(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("FROM user_versions")
+	rows := mock.NewRows(userVersionRowColumns).AddRow(1, 1, "Kal", "k@s.com", time.Now(), time.Now())
+	mock.ExpectQuery("FROM user_versions").WithArgs(1, time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1?as_of=2020-09-01T12:00:00Z", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, `{"id":1,"name":"Kal","email":"k@s.com"}`, string(body))
+}
+
+func TestGetUserAsOfBeforeCreation(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("FROM user_versions")
+	mock.ExpectQuery("FROM user_versions").WillReturnError(sql.ErrNoRows)
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1?as_of=2000-01-01T00:00:00Z", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGetUserAsOfMalformed(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1?as_of=yesterday", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), `"field":"as_of","code":"date_time"`)
+}
+
+func TestUserVersions(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	first := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := mock.NewRows(userVersionRowColumns).
+		AddRow(1, 1, "Kal", "k@s.com", first, second).
+		AddRow(1, 2, "Kaladin", "k@s.com", second, nil)
+	mock.ExpectQuery("FROM user_versions WHERE user_id=\\$1 ORDER BY version").WithArgs(1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1/versions", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.JSONEq(t, `[
+		{"version":1,"user":{"id":1,"name":"Kal","email":"k@s.com"},"valid_from":"2020-08-01T00:00:00Z","valid_to":"2020-09-01T00:00:00Z"},
+		{"version":2,"user":{"id":1,"name":"Kaladin","email":"k@s.com"},"valid_from":"2020-09-01T00:00:00Z"}
+	]`, string(body))
+}
+
+func TestUpdateUserIfMatchConflict(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("AND version=\\$5")
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, 2, "anonymous", sqlmock.AnyArg()).WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 2))
+
+	_, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=k@s.com&password=password",
+		map[string]string{"If-Match": `"2"`})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+func TestRevertUser(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("FROM user_versions")
+	mock.ExpectQuery("FROM user_versions").WithArgs(1, 1).
+		WillReturnRows(mock.NewRows(userVersionRowColumns).AddRow(1, 1, "Kal", "k@s.com", time.Now(), time.Now()))
+	mock.ExpectPrepare("UPDATE users SET name=\\$1, email=\\$2 WHERE id=\\$3 AND version=\\$4")
+	mock.ExpectQuery("UPDATE").WithArgs("Kal", "k@s.com", 1, 3, "anonymous", sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 4))
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users/1/revert?version=1", map[string]string{"If-Match": `"3"`})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, `{"id":1,"name":"Kal","email":"k@s.com"}`, string(body))
+	require.Equal(t, `"4"`, resp.Header.Get("ETag"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertUserRequiresVersion(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	_, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users/1/revert", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tammiec/go-rest-api/idempotency"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyKeyMaxLength = 255
+	// idempotencyLease is how long a request may hold its key before a
+	// retry is allowed to run again in its place.
+	idempotencyLease = time.Minute
+)
+
+var (
+	idempotencyKeys idempotency.Store = idempotency.NewMemoryStore()
+	idempotencyTTL                    = 24 * time.Hour
+)
+
+// idempotencyRecorder captures the response to a request holding a key
+// while passing it on to the client.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// requestFingerprint identifies what a request asks for, so that a key
+// reused for a different request can be told apart from a retry.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n" + r.Header.Get("Content-Type") + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent makes POST requests carrying an Idempotency-Key safe to retry.
+// The first request with a key runs and its response is kept for
+// idempotencyTTL; retries with the same key and request get that response
+// again, marked with Idempotent-Replayed. A retry that arrives while the
+// first request is still running gets 409, and reusing a key for a
+// different request gets 422. Keys are scoped to the actor, and server
+// errors are not kept, so that the request can be retried.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			http.Error(w, "Idempotency-Key is too long", 400)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		key = requestAudit(r.Context()).Actor + "\x00" + key
+		fingerprint := requestFingerprint(r, body)
+
+		record, err := idempotencyKeys.Claim(r.Context(), key, fingerprint, idempotencyLease)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				writeProblem(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+			case record.Response == nil:
+				writeProblem(w, r, http.StatusConflict, "a request with this Idempotency-Key is still in progress", nil)
+			default:
+				if record.Response.ContentType != "" {
+					w.Header().Set("Content-Type", record.Response.ContentType)
+				}
+				if record.Response.ETag != "" {
+					w.Header().Set("ETag", record.Response.ETag)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Response.Status)
+				w.Write(record.Response.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// The outcome is stored even if the client has gone away meanwhile.
+		ctx := context.Background()
+		if rec.status >= 500 {
+			err = idempotencyKeys.Release(ctx, key)
+		} else {
+			err = idempotencyKeys.Complete(ctx, key, idempotency.Response{
+				Status:      rec.status,
+				ContentType: w.Header().Get("Content-Type"),
+				ETag:        w.Header().Get("ETag"),
+				Body:        rec.body.Bytes(),
+			}, idempotencyTTL)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package idempotency remembers the responses to requests sent with an
+// Idempotency-Key, so that a client retrying such a request gets the
+// original response instead of repeating its effect.
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Response is a stored response.
+type Response struct {
+	Status      int
+	ContentType string
+	ETag        string
+	Body        []byte
+}
+
+// Record is what a store holds for a key: the fingerprint of the request
+// that claimed it and, once that request has finished, its response.
+type Record struct {
+	Fingerprint string
+	// Response is nil while the request is still in flight.
+	Response *Response
+}
+
+// Store keeps records by key. Claims expire after their lease, so that a key
+// whose request never finished can be used again; completed records expire
+// after their TTL.
+type Store interface {
+	// Claim reserves key for a request with the given fingerprint. It
+	// returns nil when the key was free, and the record holding it
+	// otherwise.
+	Claim(ctx context.Context, key string, fingerprint string, lease time.Duration) (*Record, error)
+	// Complete stores the response to the request that claimed key.
+	Complete(ctx context.Context, key string, response Response, ttl time.Duration) error
+	// Release gives up a claim without storing a response.
+	Release(ctx context.Context, key string) error
+}
+
+type memoryRecord struct {
+	Record
+	expiresAt time.Time
+}
+
+// MemoryStore keeps records in this process, for single-instance
+// deployments and tests.
+type MemoryStore struct {
+	mu         sync.Mutex
+	records    map[string]*memoryRecord
+	lastPruned time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*memoryRecord{}}
+}
+
+// prune drops expired records, at most once a minute.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPruned) < time.Minute {
+		return
+	}
+	for key, record := range s.records {
+		if !now.Before(record.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+	s.lastPruned = now
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, fingerprint string, lease time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+	if record, ok := s.records[key]; ok && now.Before(record.expiresAt) {
+		copied := record.Record
+		return &copied, nil
+	}
+	s.records[key] = &memoryRecord{Record: Record{Fingerprint: fingerprint}, expiresAt: now.Add(lease)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, response Response, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok {
+		record.Response = &response
+		record.expiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// PostgresStore keeps records in the idempotency_keys table, so that every
+// instance sharing the database sees them.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, key string, fingerprint string, lease time.Duration) (*Record, error) {
+	// An expired record is taken over as if the key were free.
+	var claimed bool
+	err := s.DB.QueryRowContext(ctx, `INSERT INTO idempotency_keys (key, fingerprint, expires_at)
+		VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, content_type = NULL, etag = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= now()
+		RETURNING true`, key, fingerprint, lease.Milliseconds()).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	record := &Record{}
+	var status sql.NullInt64
+	var contentType, etag sql.NullString
+	var body []byte
+	err = s.DB.QueryRowContext(ctx, "SELECT fingerprint, status, content_type, etag, body FROM idempotency_keys WHERE key=$1", key).
+		Scan(&record.Fingerprint, &status, &contentType, &etag, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status.Valid {
+		record.Response = &Response{Status: int(status.Int64), ContentType: contentType.String, ETag: etag.String, Body: body}
+	}
+	return record, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, response Response, ttl time.Duration) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE idempotency_keys
+		SET status=$2, content_type=$3, etag=NULLIF($4, ''), body=$5, expires_at = now() + $6 * interval '1 millisecond'
+		WHERE key=$1`, key, response.Status, response.ContentType, response.ETag, response.Body, ttl.Milliseconds())
+	return err
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key=$1 AND status IS NULL", key)
+	return err
+}
+
+// Run deletes expired records every interval until ctx is done.
+func (s *PostgresStore) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if _, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()"); err != nil {
+			log.Printf("idempotency: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
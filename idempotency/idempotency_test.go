@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+func TestMemoryStoreClaimCompleteReplay(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	record, err := s.Claim(ctx, "k", "fp", time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	record, err = s.Claim(ctx, "k", "fp", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, &Record{Fingerprint: "fp"}, record)
+
+	require.NoError(t, s.Complete(ctx, "k", Response{Status: 200, ContentType: "application/json", Body: []byte("{}")}, time.Hour))
+	record, err = s.Claim(ctx, "k", "other", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "fp", record.Fingerprint)
+	require.Equal(t, &Response{Status: 200, ContentType: "application/json", Body: []byte("{}")}, record.Response)
+}
+
+func TestMemoryStoreExpiredAndReleasedKeysAreFree(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Claim(ctx, "expired", "fp", -time.Second)
+	record, err := s.Claim(ctx, "expired", "fp", time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	require.NoError(t, s.Release(ctx, "expired"))
+	record, err = s.Claim(ctx, "expired", "fp", time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
+
+func TestPostgresStoreClaimsFreeKey(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO idempotency_keys .+ ON CONFLICT \\(key\\) DO UPDATE .+ WHERE idempotency_keys.expires_at <= now\\(\\)").
+		WithArgs("k", "fp", int64(60000)).WillReturnRows(mock.NewRows([]string{"bool"}).AddRow(true))
+
+	record, err := NewPostgresStore(db).Claim(context.Background(), "k", "fp", time.Minute)
+
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
+
+func TestPostgresStoreReturnsHeldRecord(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT fingerprint, status, content_type, etag, body FROM idempotency_keys").WithArgs("k").
+		WillReturnRows(mock.NewRows([]string{"fingerprint", "status", "content_type", "etag", "body"}).AddRow("fp", 200, "application/json", `"1"`, []byte("{}")))
+
+	record, err := NewPostgresStore(db).Claim(context.Background(), "k", "fp", time.Minute)
+
+	require.NoError(t, err)
+	require.Equal(t, &Record{Fingerprint: "fp", Response: &Response{Status: 200, ContentType: "application/json", ETag: `"1"`, Body: []byte("{}")}}, record)
+}
+
+func TestPostgresStoreInFlightRecord(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT fingerprint").
+		WillReturnRows(mock.NewRows([]string{"fingerprint", "status", "content_type", "etag", "body"}).AddRow("fp", nil, nil, nil, nil))
+
+	record, err := NewPostgresStore(db).Claim(context.Background(), "k", "fp", time.Minute)
+
+	require.NoError(t, err)
+	require.Equal(t, &Record{Fingerprint: "fp"}, record)
+}
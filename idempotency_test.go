@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/idempotency"
+)
+
+func withIdempotencyKeys(t *testing.T) {
+	store := idempotencyKeys
+	idempotencyKeys = idempotency.NewMemoryStore()
+	t.Cleanup(func() { idempotencyKeys = store })
+}
+
+const createKaladinURL = "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=password"
+
+func TestCreateUserRetryIsReplayed(t *testing.T) {
+	withIdempotencyKeys(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+	headers := map[string]string{"Idempotency-Key": "signup-1"}
+
+	first, resp, err := httpRequest(router, http.MethodPost, createKaladinURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(first))
+	require.Equal(t, `"1"`, resp.Header.Get("ETag"))
+
+	second, resp, err := httpRequest(router, http.MethodPost, createKaladinURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	require.Equal(t, string(first), string(second))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	withIdempotencyKeys(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WillReturnRows(rows)
+	headers := map[string]string{"Idempotency-Key": "signup-1"}
+
+	_, _, err := httpRequest(router, http.MethodPost, createKaladinURL, headers)
+	require.NoError(t, err)
+	_, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Adolin&email=a@k.com&password=password", headers)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func TestIdempotencyKeyInFlight(t *testing.T) {
+	withIdempotencyKeys(t)
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	r, _ := http.NewRequest(http.MethodPost, createKaladinURL, nil)
+	idempotencyKeys.Claim(context.Background(), "anonymous\x00signup-1", requestFingerprint(r, nil), idempotencyLease)
+
+	_, resp, err := httpRequest(router, http.MethodPost, createKaladinURL, map[string]string{"Idempotency-Key": "signup-1"})
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestServerErrorsAreNotKept(t *testing.T) {
+	withIdempotencyKeys(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT").WillReturnError(errors.New("connection refused"))
+	mock.ExpectPrepare("INSERT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WillReturnRows(rows)
+	headers := map[string]string{"Idempotency-Key": "signup-1"}
+
+	_, resp, err := httpRequest(router, http.MethodPost, createKaladinURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	_, resp, err = httpRequest(router, http.MethodPost, createKaladinURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+}
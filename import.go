@@ -0,0 +1,35 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/tammiec/go-rest-api/importer"
+)
+
+// importUsersHandler loads users from a CSV or NDJSON request body. The
+// format comes from ?format= or the Content-Type, and ?dry_run=true only
+// validates. The upload may take up to httpTransferTimeout.
+func importUsersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	extendDeadlines(w, httpTransferTimeout)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importer.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	report, err := importer.Import(db, requestAudit(r.Context()), r.Body, format, dryRun)
+	if err != nil {
+		log.Println(err)
+		switch {
+		case errors.Is(err, importer.ErrUnknownFormat), errors.Is(err, importer.ErrCSVHeader):
+			http.Error(w, err.Error(), 400)
+		default:
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+	marshalAndWrite(report, w, r)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const ndjsonUsers = `{"name": "Kaladin", "email": "k@s.com", "password": "password"}
+{"name": "Adolin", "email": "a@k", "password": "password"}
+`
+
+func TestHandleImportUsersOutlastsServerTimeouts(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	server := newTimingOutServer(router, 50*time.Millisecond)
+	defer server.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	copy := mock.ExpectPrepare("COPY")
+	copy.ExpectExec().WithArgs(1, "Kaladin", "k@s.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO users .+ FROM user_import").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com"))
+	mock.ExpectCommit()
+
+	// The upload is slower than the server's read timeout.
+	body, upload := io.Pipe()
+	go func() {
+		io.WriteString(upload, `{"name": "Kaladin", "email": "k@s.com", `)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(upload, `"password": "password"}`+"\n")
+		upload.Close()
+	}()
+	resp, err := http.Post(server.URL+"/users/import", "application/x-ndjson", body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	report, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(report))
+	require.Equal(t, `{"dry_run":false,"accepted":1,"rejected":[]}`, string(report))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleImportUsersOk(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	copy := mock.ExpectPrepare("COPY")
+	copy.ExpectExec().WithArgs(1, "Kaladin", "k@s.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO users .+ FROM user_import").WithArgs("admin", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com"))
+	mock.ExpectCommit()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users/import", strings.NewReader(ndjsonUsers))
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set("X-Forwarded-User", "admin")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Equal(t, "{\"dry_run\":false,\"accepted\":1,\"rejected\":[{\"line\":2,\"reason\":\"email: must be a valid email address\"}]}", recorder.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleImportUsersUnknownFormat(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users/import?dry_run=true", strings.NewReader(ndjsonUsers))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+}
+
+func TestHandleImportUsersCSVWithoutHeader(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("COPY")
+	mock.ExpectRollback()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users/import", strings.NewReader("Kaladin,k@s.com,password\n"))
+	request.Header.Set("Content-Type", "text/csv")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportCommandDryRun(t *testing.T) {
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "import")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.ndjson")
+	require.NoError(t, ioutil.WriteFile(path, []byte(ndjsonUsers), 0600))
+	mock.ExpectQuery("SELECT lower\\(email\\) FROM users").WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	out := &bytes.Buffer{}
+	err = runCommand(db, "import", []string{"-dry-run", path}, nil, out)
+
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "\"accepted\": 1")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportCommandStdin(t *testing.T) {
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+	mock.ExpectQuery("SELECT lower\\(email\\) FROM users").WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	out := &bytes.Buffer{}
+	err := runCommand(db, "import", []string{"-format", "csv", "-dry-run"}, strings.NewReader("name,email,password\nKaladin,k@s.com,password\n"), out)
+
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "\"accepted\": 1")
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	err := runCommand(nil, "frobnicate", nil, nil, nil)
+
+	require.EqualError(t, err, "unknown command \"frobnicate\"")
+}
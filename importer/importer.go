@@ -0,0 +1,248 @@
+package importer
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// maxLineSize bounds a single NDJSON record.
+const maxLineSize = 1 << 20
+
+var ErrUnknownFormat = errors.New("unknown import format")
+
+// ErrCSVHeader is returned when a CSV import has no header, or one that does
+// not name every column.
+var ErrCSVHeader = errors.New("csv: bad header")
+
+// reasonTaken is why a record whose email already belongs to a user is
+// rejected.
+const reasonTaken = "email: already in use"
+
+type Rejection struct {
+	Line   int    `json:"line" xml:"line" yaml:"line"`
+	Reason string `json:"reason" xml:"reason" yaml:"reason"`
+}
+
+type Report struct {
+	DryRun   bool        `json:"dry_run" xml:"dry_run" yaml:"dry_run"`
+	Accepted int         `json:"accepted" xml:"accepted" yaml:"accepted"`
+	Rejected []Rejection `json:"rejected" xml:"rejected>rejection" yaml:"rejected"`
+}
+
+type record struct {
+	Line     int    `json:"-"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// FormatFromContentType picks an import format from a request's media type.
+func FormatFromContentType(contentType string) string {
+	switch strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]) {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/ndjson":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// Import reads users from r in the given format, validates each one and
+// loads the valid ones with a single COPY. Invalid records, and those whose
+// email already belongs to a user, are reported in the result rather than
+// failing the import; a database error aborts the whole import. Each user
+// created is audited as the work of audit's actor. With dryRun nothing is
+// written.
+func Import(db *sql.DB, audit model.Audit, r io.Reader, format string, dryRun bool) (*Report, error) {
+	var next func() (*record, error)
+	switch format {
+	case FormatCSV:
+		next = csvRecords(r)
+	case FormatNDJSON:
+		next = ndjsonRecords(r)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	report := &Report{DryRun: dryRun, Rejected: []Rejection{}}
+	var tx *sql.Tx
+	var copier *model.UserCopier
+	if !dryRun {
+		var err error
+		tx, err = db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		copier, err = model.NewUserCopier(tx, audit)
+		if err != nil {
+			return nil, err
+		}
+		defer copier.Abort()
+	}
+
+	seen := make(map[string]int)
+	for {
+		rec, err := next()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			report.Rejected = append(report.Rejected, Rejection{Line: lineErr.line, Reason: lineErr.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		email, err := model.ValidateUser(rec.Name, rec.Email, rec.Password)
+		if err != nil {
+			report.Rejected = append(report.Rejected, Rejection{Line: rec.Line, Reason: err.Error()})
+			continue
+		}
+		key := strings.ToLower(email)
+		if first, ok := seen[key]; ok {
+			report.Rejected = append(report.Rejected, Rejection{Line: rec.Line, Reason: fmt.Sprintf("email: duplicates line %d", first)})
+			continue
+		}
+		seen[key] = rec.Line
+
+		if copier != nil {
+			if err := copier.Add(rec.Line, rec.Name, email, rec.Password); err != nil {
+				return nil, err
+			}
+		}
+		report.Accepted++
+	}
+
+	var taken []int
+	if copier != nil {
+		var err error
+		if taken, err = copier.Close(); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	} else if len(seen) > 0 {
+		emails := make([]string, 0, len(seen))
+		for email := range seen {
+			emails = append(emails, email)
+		}
+		takenEmails, err := model.TakenEmails(db, emails)
+		if err != nil {
+			return nil, err
+		}
+		for email := range takenEmails {
+			taken = append(taken, seen[email])
+		}
+	}
+	for _, line := range taken {
+		report.Rejected = append(report.Rejected, Rejection{Line: line, Reason: reasonTaken})
+	}
+	report.Accepted -= len(taken)
+	sort.SliceStable(report.Rejected, func(i, j int) bool {
+		return report.Rejected[i].Line < report.Rejected[j].Line
+	})
+	return report, nil
+}
+
+// lineError is a record that could not be parsed; the import carries on.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return e.err.Error()
+}
+
+// csvRecords reads a CSV file whose header names the name, email and
+// password columns, in any order.
+func csvRecords(r io.Reader) func() (*record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var columns map[string]int
+	return func() (*record, error) {
+		if columns == nil {
+			header, err := reader.Read()
+			if err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("%w: the input is empty", ErrCSVHeader)
+				}
+				return nil, err
+			}
+			columns = make(map[string]int)
+			for i, name := range header {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			for _, name := range []string{"name", "email", "password"} {
+				if _, ok := columns[name]; !ok {
+					return nil, fmt.Errorf("%w: no %q column", ErrCSVHeader, name)
+				}
+			}
+		}
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &lineError{line: parseErr.Line, err: err}
+			}
+			return nil, err
+		}
+		field := func(name string) string {
+			if i := columns[name]; i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		// A quoted field may span lines, so the record's line is where its
+		// first field starts.
+		line, _ := reader.FieldPos(0)
+		return &record{Line: line, Name: field("name"), Email: field("email"), Password: field("password")}, nil
+	}
+}
+
+// ndjsonRecords reads one JSON object per line, skipping blank lines.
+func ndjsonRecords(r io.Reader) func() (*record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	line := 0
+	return func() (*record, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			rec := &record{}
+			if err := json.Unmarshal([]byte(text), rec); err != nil {
+				return nil, &lineError{line: line, err: err}
+			}
+			rec.Line = line
+			return rec, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+func init() {
+	model.PasswordHashCost = bcrypt.MinCost
+}
+
+var testAudit = model.Audit{Actor: "admin", RequestId: "req-1"}
+
+// hashOf matches a query argument holding the stored hash of a password.
+type hashOf string
+
+func (h hashOf) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && model.CheckPassword(hash, string(h))
+}
+
+const csvInput = `email,name,password
+k@s.com,Kaladin,password
+a@k.com,Adolin,short
+K@S.COM,Kal,password
+s@d.com,Shallan,password
+`
+
+func TestImportCSV(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	copy := mock.ExpectPrepare("COPY \"user_import\"")
+	copy.ExpectExec().WithArgs(2, "Kaladin", "k@s.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs(5, "Shallan", "s@d.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("INSERT INTO users .+ FROM user_import ORDER BY line ON CONFLICT DO NOTHING .+ INSERT INTO outbox .+'user.created'.+ INSERT INTO user_audit .+'create'").
+		WithArgs("admin", "req-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com").AddRow(2, "Shallan", "s@d.com"))
+	mock.ExpectCommit()
+
+	report, err := Import(db, testAudit, strings.NewReader(csvInput), FormatCSV, false)
+
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Accepted)
+	require.Equal(t, []Rejection{
+		{Line: 3, Reason: "password: must be at least 8 characters"},
+		{Line: 4, Reason: "email: duplicates line 2"},
+	}, report.Rejected)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportCSVMissingColumn(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("COPY")
+	mock.ExpectRollback()
+
+	_, err := Import(db, testAudit, strings.NewReader("name,email\nKaladin,k@s.com\n"), FormatCSV, false)
+
+	require.True(t, errors.Is(err, ErrCSVHeader))
+	require.Equal(t, "csv: bad header: no \"password\" column", err.Error())
+}
+
+func TestImportCSVEmpty(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	_, err := Import(db, testAudit, strings.NewReader(""), FormatCSV, true)
+
+	require.True(t, errors.Is(err, ErrCSVHeader))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportCSVMultilineField(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT lower\\(email\\) FROM users").WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	// A quoted field may span lines; records after it are numbered by the
+	// line they start on.
+	input := "name,email,password\n\"Kaladin\nStormblessed\",k@s.com,password\nShallan,s@d.com,password\nAdolin,a@k.com,short\n"
+	report, err := Import(db, testAudit, strings.NewReader(input), FormatCSV, true)
+
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Accepted)
+	require.Equal(t, []int{2, 5}, []int{report.Rejected[0].Line, report.Rejected[1].Line})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportNDJSONDryRun(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	input := `{"name": "Kaladin", "email": "k@s.com", "password": "password"}
+
+{"name": "Adolin", "email": "a@k.com"
+{"name": "", "email": "s@d.com", "password": "password"}
+{"name": "Adolin", "email": "a@k.com", "password": "password"}
+`
+	mock.ExpectQuery("SELECT lower\\(email\\) FROM users WHERE lower\\(email\\) = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("a@k.com"))
+
+	report, err := Import(db, testAudit, strings.NewReader(input), FormatNDJSON, true)
+
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, 1, report.Accepted)
+	require.Len(t, report.Rejected, 3)
+	require.Equal(t, 3, report.Rejected[0].Line)
+	require.Equal(t, 4, report.Rejected[1].Line)
+	require.Equal(t, "name: is required", report.Rejected[1].Reason)
+	require.Equal(t, Rejection{Line: 5, Reason: "email: already in use"}, report.Rejected[2])
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportEmailTaken(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE user_import").WillReturnResult(sqlmock.NewResult(0, 0))
+	copy := mock.ExpectPrepare("COPY")
+	copy.ExpectExec().WithArgs(1, "Kaladin", "k@s.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs(2, "Shallan", "s@d.com", hashOf("password")).WillReturnResult(sqlmock.NewResult(0, 1))
+	copy.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	// Kaladin's email is taken, so only Shallan is inserted.
+	mock.ExpectQuery("INSERT INTO users .+ ON CONFLICT DO NOTHING").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(2, "Shallan", "s@d.com"))
+	mock.ExpectCommit()
+
+	input := `{"name": "Kaladin", "email": "k@s.com", "password": "password"}
+{"name": "Shallan", "email": "s@d.com", "password": "password"}`
+	report, err := Import(db, testAudit, strings.NewReader(input), FormatNDJSON, false)
+
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Accepted)
+	require.Equal(t, []Rejection{{Line: 1, Reason: "email: already in use"}}, report.Rejected)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportUnknownFormat(t *testing.T) {
+	db, _ := getMockDB()
+	defer db.Close()
+
+	_, err := Import(db, testAudit, strings.NewReader(""), "xml", false)
+
+	require.True(t, errors.Is(err, ErrUnknownFormat))
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	require.Equal(t, FormatCSV, FormatFromContentType("text/csv; charset=utf-8"))
+	require.Equal(t, FormatNDJSON, FormatFromContentType("application/x-ndjson"))
+	require.Equal(t, "", FormatFromContentType("application/json"))
+}
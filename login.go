@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	// loginBaseDelay is how long a client waits after its first failed login
+	// before it may try again; the wait doubles with every further failure,
+	// up to loginMaxDelay.
+	loginBaseDelay = time.Second
+	loginMaxDelay  = time.Minute
+)
+
+var (
+	// loginMaxFailures failed logins to one account lock it, and
+	// loginMaxIPFailures from one address lock out the address, for
+	// loginLockDuration. Failures older than that are forgotten.
+	loginMaxFailures   = 5
+	loginMaxIPFailures = 50
+	loginLockDuration  = 15 * time.Minute
+	// adminActors are the actors allowed to unlock accounts.
+	adminActors = map[string]bool{}
+)
+
+// parseAdminActors reads a comma-separated list of actors. The anonymous
+// actor is never an administrator.
+func parseAdminActors(spec string) map[string]bool {
+	actors := map[string]bool{}
+	for _, actor := range strings.Split(spec, ",") {
+		if actor = strings.TrimSpace(actor); actor != "" && actor != anonymousActor {
+			actors[actor] = true
+		}
+	}
+	return actors
+}
+
+type loginInput struct {
+	Email    string `json:"email" xml:"email" yaml:"email"`
+	Password string `json:"password" xml:"password" yaml:"password"`
+}
+
+// parseLogin reads credentials from the body only: ones in the query string
+// would end up in proxy and server logs.
+func parseLogin(r *http.Request) (string, string, error) {
+	if isForm(r) {
+		return r.PostFormValue("email"), r.PostFormValue("password"), nil
+	}
+	input := loginInput{}
+	if err := decodeBody(r, &input); err != nil {
+		return "", "", err
+	}
+	return input.Email, input.Password, nil
+}
+
+// loginWait returns how long a key with the given failures must wait before
+// its next attempt: the progressive delay below max failures, and the lock
+// from there on.
+func loginWait(failures model.LoginFailures, max int) time.Duration {
+	if failures.Count == 0 {
+		return 0
+	}
+	wait := loginLockDuration
+	if failures.Count < max {
+		wait = loginMaxDelay
+		if failures.Count <= 16 {
+			if delay := loginBaseDelay << uint(failures.Count-1); delay < wait {
+				wait = delay
+			}
+		}
+	}
+	return wait - failures.Age
+}
+
+// loginHandler checks an email and password. Failed attempts are counted
+// against both the account and the client address, which must then wait
+// longer and longer before trying again and are locked out altogether after
+// too many failures. The response never tells whether the email belongs to a
+// user: unknown emails are counted and locked like real accounts.
+//
+// Each attempt is counted as failed before the password is checked and taken
+// back if it succeeds, so that concurrent attempts cannot all check their
+// passwords against the same count and get past the limits together.
+func loginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	email, password, err := parseLogin(r)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+	accountKey, ipKey := model.AccountLoginKey(email), "ip:"+clientIP(r)
+	failures, err := model.GetLoginFailures(db, loginLockDuration, accountKey, ipKey)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	wait := loginWait(failures[accountKey], loginMaxFailures)
+	if ipWait := loginWait(failures[ipKey], loginMaxIPFailures); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		writeTooManyLogins(w, r, wait)
+		return
+	}
+
+	ipCount, err := model.RecordLoginFailure(db, loginLockDuration, ipKey)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	accountCount, err := model.RecordLoginFailure(db, loginLockDuration, accountKey)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if accountCount > loginMaxFailures || ipCount > loginMaxIPFailures {
+		writeTooManyLogins(w, r, loginLockDuration)
+		return
+	}
+
+	user, verified, err := model.Authenticate(db, email, password)
+	if err == model.ErrInvalidCredentials {
+		if accountCount == loginMaxFailures {
+			if err := model.AuditLockout(db, requestAudit(r.Context()), email); err != nil {
+				log.Println(err)
+			}
+		}
+		writeProblem(w, r, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := model.ClearLoginFailures(db, accountKey); err != nil {
+		log.Println(err)
+	}
+	if err := model.ForgiveLoginFailure(db, ipKey); err != nil {
+		log.Println(err)
+	}
+	if !verified && unverifiedAccounts == unverifiedBlockLogin {
+		writeProblem(w, r, http.StatusForbidden, errEmailNotVerified.Error(), nil)
+		return
+	}
+	marshalAndWrite(renderUser(r, user), w, r)
+}
+
+func writeTooManyLogins(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", ceilSeconds(wait))
+	writeProblem(w, r, http.StatusTooManyRequests, "too many failed logins, try again later", nil)
+}
+
+// unlockUserHandler lifts the lock on an account. Only adminActors may, since
+// anyone else could use it to undo the lockout of an account they are
+// guessing the password of.
+func unlockUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+	audit := requestAudit(r.Context())
+	if !adminActors[audit.Actor] {
+		writeProblem(w, r, http.StatusForbidden, "only administrators may unlock accounts", nil)
+		return
+	}
+	user, err := model.UnlockUser(db, audit, id)
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	marshalAndWrite(renderUser(r, user), w, r)
+}
+
+// addAuthRoutes registers the routes that check and recover users'
+// credentials.
+func addAuthRoutes(router *mux.Router, db *sql.DB) {
+	router.HandleFunc("/auth/login", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		loginHandler(w, r, db)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password-reset", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		passwordResetHandler(w, r, db)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password-reset/confirm", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		confirmPasswordResetHandler(w, r, db)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/auth/verify", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		verifyEmailHandler(w, r, db)
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/auth/verify/resend", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		resendVerificationHandler(w, r, db)
+	})).Methods(http.MethodPost)
+}
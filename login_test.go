@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	loginURL  = "http://localhost:1234/auth/login"
+	loginForm = "email=k@s.com&password=password"
+)
+
+var (
+	loginFailureColumns = []string{"key", "failures", "age"}
+	loginUserColumns    = []string{"id", "name", "email", "password", "verified"}
+)
+
+// postForm posts a form body to url, the way credentials must be sent.
+func postForm(router http.Handler, url string, form string) ([]byte, *http.Response, error) {
+	request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	resp := recorder.Result()
+	body, err := ioutil.ReadAll(recorder.Body)
+	return body, resp, err
+}
+
+// expectLoginAttempt expects a login attempt to be counted against the client
+// address and then the account, which come to ipCount and accountCount.
+func expectLoginAttempt(mock sqlmock.Sqlmock, ipCount int, accountCount int) {
+	mock.ExpectPrepare("INSERT INTO login_failures")
+	mock.ExpectQuery("INSERT").WithArgs("ip:192.0.2.1", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"failures"}).AddRow(ipCount))
+	mock.ExpectPrepare("INSERT INTO login_failures")
+	mock.ExpectQuery("INSERT").WithArgs("email:k@s.com", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"failures"}).AddRow(accountCount))
+}
+
+// expectLoginSuccess expects a successful login's failures to be cleared and
+// its attempt taken back from the client address.
+func expectLoginSuccess(mock sqlmock.Sqlmock) {
+	mock.ExpectPrepare("DELETE FROM login_failures")
+	mock.ExpectExec("DELETE").WithArgs("email:k@s.com").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("UPDATE login_failures SET failures = failures - 1")
+	mock.ExpectExec("UPDATE").WithArgs("ip:192.0.2.1").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestLoginSucceedsAndClearsFailures(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM login_failures").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows(loginFailureColumns).AddRow("email:k@s.com", 2, 10.0))
+	expectLoginAttempt(mock, 1, 3)
+	mock.ExpectPrepare("SELECT id, name, email, password, .+ FROM users")
+	mock.ExpectQuery("SELECT").WithArgs("k@s.com").WillReturnRows(mock.NewRows(loginUserColumns).AddRow(1, "Kaladin", "k@s.com", storedPassword("password"), true))
+	expectLoginSuccess(mock)
+
+	body, resp, err := postForm(router, loginURL, loginForm)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Contains(t, string(body), `"email":"k@s.com"`)
+	require.NotContains(t, string(body), "password")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginFailureDoesNotRevealWhetherEmailExists(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	for _, found := range []bool{true, false} {
+		mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns))
+		expectLoginAttempt(mock, 1, 1)
+		mock.ExpectPrepare("SELECT id, name, email, password, .+ FROM users")
+		if found {
+			mock.ExpectQuery("SELECT").WillReturnRows(mock.NewRows(loginUserColumns).AddRow(1, "Kaladin", "k@s.com", storedPassword("other-password"), true))
+		} else {
+			mock.ExpectQuery("SELECT").WillReturnError(sql.ErrNoRows)
+		}
+	}
+
+	found, resp, err := postForm(router, loginURL, loginForm)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	missing, resp, err := postForm(router, loginURL, loginForm)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, string(found), string(missing))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginLocksAccountAtThresholdAndAuditsIt(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns).AddRow("email:k@s.com", loginMaxFailures-1, 3600.0))
+	expectLoginAttempt(mock, 1, loginMaxFailures)
+	mock.ExpectPrepare("SELECT id, name, email, password, .+ FROM users")
+	mock.ExpectQuery("SELECT").WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("INSERT INTO user_audit .+ 'lock'")
+	mock.ExpectExec("INSERT").WithArgs("k@s.com", "anonymous", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, resp, err := postForm(router, loginURL, loginForm)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginRefusedWhileLockedOrWaiting(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns).AddRow("email:k@s.com", loginMaxFailures, 60.0))
+	mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns).AddRow("ip:192.0.2.1", 3, 1.0))
+
+	body, resp, err := postForm(router, loginURL, loginForm)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "840", resp.Header.Get("Retry-After"))
+	require.Contains(t, string(body), "too many failed logins")
+
+	_, resp, err = postForm(router, loginURL, loginForm)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "3", resp.Header.Get("Retry-After"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConcurrentLoginRefusedPastLimit(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	// Another attempt was counted after this one read the failures, taking the
+	// account past the limit: the password is not checked.
+	mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns).AddRow("email:k@s.com", loginMaxFailures-1, 3600.0))
+	expectLoginAttempt(mock, 1, loginMaxFailures+1)
+
+	_, resp, err := postForm(router, loginURL, loginForm)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "900", resp.Header.Get("Retry-After"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseLoginIgnoresQueryString(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, loginURL+"?email=q@s.com&password=query", strings.NewReader("email=k@s.com"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	email, password, err := parseLogin(request)
+
+	require.NoError(t, err)
+	require.Equal(t, "k@s.com", email)
+	require.Equal(t, "", password)
+}
+
+func TestLoginWait(t *testing.T) {
+	require.Equal(t, time.Duration(0), loginWait(model.LoginFailures{}, 5))
+	require.Equal(t, time.Second, loginWait(model.LoginFailures{Count: 1}, 5))
+	require.Equal(t, 8*time.Second, loginWait(model.LoginFailures{Count: 4}, 5))
+	require.Equal(t, loginLockDuration-time.Minute, loginWait(model.LoginFailures{Count: 5, Age: time.Minute}, 5))
+	require.Equal(t, loginMaxDelay, loginWait(model.LoginFailures{Count: 40}, 50))
+	require.True(t, loginWait(model.LoginFailures{Count: 2, Age: time.Hour}, 5) < 0)
+}
+
+func TestUnlockUser(t *testing.T) {
+	adminActors = parseAdminActors("admin, ops")
+	defer func() { adminActors = map[string]bool{} }()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("DELETE FROM login_failures")
+	mock.ExpectQuery("WITH").WithArgs(1, "admin", sqlmock.AnyArg()).WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com"))
+	mock.ExpectPrepare("DELETE FROM login_failures")
+	mock.ExpectQuery("WITH").WithArgs(2, "admin", sqlmock.AnyArg()).WillReturnError(sql.ErrNoRows)
+	headers := map[string]string{"X-Forwarded-User": "admin"}
+
+	_, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users/1/unlock", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, resp, err = httpRequest(router, http.MethodPost, "http://localhost:1234/users/2/unlock", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnlockUserRequiresAdmin(t *testing.T) {
+	adminActors = parseAdminActors("admin,anonymous")
+	defer func() { adminActors = map[string]bool{} }()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	for _, headers := range []map[string]string{nil, {"X-Forwarded-User": "kaladin"}} {
+		body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users/1/unlock", headers)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.Contains(t, string(body), "only administrators")
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+}
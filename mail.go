@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tammiec/go-rest-api/mailer"
+)
+
+// userMailer sends the emails written to users. Tests read what it was given.
+var userMailer mailer.Mailer = mailer.NewMemoryMailer()
+
+// newMailer builds the mailer named by spec: "smtp" sends through
+// SMTP_ADDR, "file:DIR" drops messages in a directory and "memory" keeps
+// them, which only suits tests.
+func newMailer(spec string, from string) (mailer.Mailer, error) {
+	switch {
+	case spec == "smtp":
+		return mailer.NewSMTPMailer(getEnv("SMTP_ADDR"), from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case strings.HasPrefix(spec, "file:"):
+		return mailer.NewFileMailer(strings.TrimPrefix(spec, "file:"), from), nil
+	case spec == "memory":
+		return mailer.NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", spec)
+	}
+}
+
+// sendMail sends msg in the background, so that how long sending takes says
+// nothing to the client about whether there was anything to send.
+func sendMail(msg mailer.Message) {
+	m := userMailer
+	go func() {
+		if err := m.Send(context.Background(), msg); err != nil {
+			log.Printf("mail to %s: %v", msg.To, err)
+		}
+	}()
+}
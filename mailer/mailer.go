@@ -0,0 +1,186 @@
+// Package mailer sends the emails the API writes to its users, through an
+// SMTP server, as files dropped in a directory, or into memory for tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// headerValue drops line breaks, so that a value cannot add headers of its
+// own.
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// format renders msg as an RFC 5322 message sent at t.
+func format(from string, msg Message, t time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", t.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// defaultSMTPTimeout bounds a send through an SMTPMailer with no Timeout.
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPMailer sends messages through an SMTP server, using STARTTLS when the
+// server offers it and authenticating with PLAIN auth when it has a
+// username.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	// Timeout bounds each send, from dialing the server to its answer to
+	// the message, within whatever deadline the caller's context has.
+	Timeout time.Duration
+}
+
+func NewSMTPMailer(addr string, from string, username string, password string) *SMTPMailer {
+	return &SMTPMailer{Addr: addr, From: from, Username: username, Password: password, Timeout: defaultSMTPTimeout}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// A context cancelled before its deadline interrupts the exchange too.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(headerValue(m.From)); err != nil {
+		return err
+	}
+	if err := c.Rcpt(headerValue(msg.To)); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(format(m.From, msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileMailer writes each message to its own .eml file in Dir, for local
+// development without a mail server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	f, err := ioutil.TempFile(m.Dir, now.UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(format(m.From, msg, now)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// MemoryMailer keeps the messages it is given, for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,121 @@
+package mailer
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatEscapesHeaders(t *testing.T) {
+	msg := Message{To: "k@s.com\r\nBcc: a@k.com", Subject: "Reset", Body: "line one\nline two"}
+
+	b := string(format("noreply@s.com", msg, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	require.Contains(t, b, "To: k@s.comBcc: a@k.com\r\n")
+	require.NotContains(t, b, "\r\nBcc:")
+	require.Contains(t, b, "Date: Thu, 02 Jan 2020 03:04:05 +0000\r\n")
+	require.True(t, strings.HasSuffix(b, "\r\n\r\nline one\r\nline two"))
+}
+
+func TestFileMailerDropsMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := NewFileMailer(filepath.Join(dir, "mail"), "noreply@s.com")
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "k@s.com", Subject: "One", Body: "1"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "k@s.com", Subject: "Two", Body: "2"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	b, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(b), "From: noreply@s.com\r\n")
+}
+
+func TestMemoryMailerKeepsMessages(t *testing.T) {
+	m := NewMemoryMailer()
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "k@s.com", Subject: "One"}))
+
+	require.Equal(t, []Message{{To: "k@s.com", Subject: "One"}}, m.Messages())
+}
+
+// serveSMTP answers one SMTP session on l just well enough to take a
+// message, which it sends to received.
+func serveSMTP(l net.Listener, received chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+		case "EHLO":
+			tp.PrintfLine("250 localhost")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			body, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			received <- string(body)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	received := make(chan string, 1)
+	go serveSMTP(l, received)
+
+	m := NewSMTPMailer(l.Addr().String(), "noreply@s.com", "", "")
+	require.NoError(t, m.Send(context.Background(), Message{To: "k@s.com", Subject: "One", Body: "1"}))
+
+	require.Contains(t, <-received, "Subject: One\n")
+}
+
+func TestSMTPMailerTimesOut(t *testing.T) {
+	// A server that accepts connections but never greets the client.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	m := NewSMTPMailer(l.Addr().String(), "noreply@s.com", "", "")
+	m.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	err = m.Send(context.Background(), Message{To: "k@s.com", Subject: "One"})
+
+	require.Error(t, err)
+	require.True(t, time.Since(start) < time.Second/2, time.Since(start))
+}
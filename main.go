@@ -1,33 +1,77 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/idempotency"
 	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/outbox"
+	"github.com/tammiec/go-rest-api/ratelimit"
+	"github.com/tammiec/go-rest-api/rpc"
+	"github.com/tammiec/go-rest-api/validation"
+	"github.com/tammiec/go-rest-api/webhooks"
+	"google.golang.org/grpc"
 )
 
 var (
 	httpReadTimeout  = 5 * time.Second
 	httpWriteTimeout = 5 * time.Second
 	httpIdleTimeout  = 1 * time.Minute
+	// httpTransferTimeout replaces the read and write timeouts for the
+	// requests that upload or download the whole table.
+	httpTransferTimeout = time.Hour
 )
 
+// extendDeadlines gives the handler writing to w until timeout from now to
+// read its request and write its response, instead of the server's
+// httpReadTimeout and httpWriteTimeout.
+func extendDeadlines(w http.ResponseWriter, timeout time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Println(err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Println(err)
+	}
+}
+
+// envelopeCollections wraps collection responses in an api.Envelope unless a
+// request asks otherwise with ?envelope=false.
+var envelopeCollections = false
+
 func readinessHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: add a DB ping check
 	w.WriteHeader(http.StatusOK)
 	io.WriteString(w, "Ready")
 }
 
+// maxUsersPageSize bounds ?limit= on the user list.
+const maxUsersPageSize = 500
+
+// getUsersHandler lists every user or, with ?limit=, a page of users in id
+// order starting after ?after=. When more users follow a page, its envelope
+// links to the next one, as does a Link header.
 func getUsersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	query := r.URL.Query()
+	if query.Get("limit") != "" {
+		getUsersPageHandler(w, r, db)
+		return
+	}
 	users, err := model.GetUsers(db)
 	if err != nil {
 		log.Println(err)
@@ -40,7 +84,58 @@ func getUsersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 		return
 	}
-	marshalAndWriteJson(users, w)
+	if envelopeRequested(r) {
+		marshalAndWrite(&api.Envelope{
+			Data:  renderUsers(r, users),
+			Meta:  api.Meta{Count: len(users)},
+			Links: api.Links{Self: r.URL.Path},
+		}, w, r)
+		return
+	}
+	marshalAndWrite(renderUsers(r, users), w, r)
+}
+
+func getUsersPageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 {
+		writeProblem(w, r, 400, "limit must be a positive integer", validation.Errors{{Field: "limit", Code: "minimum", Args: []interface{}{1}}})
+		return
+	}
+	if limit > maxUsersPageSize {
+		writeProblem(w, r, 400, "limit is too large", validation.Errors{{Field: "limit", Code: "maximum", Args: []interface{}{maxUsersPageSize}}})
+		return
+	}
+	after := 0
+	if query.Get("after") != "" {
+		if after, err = strconv.Atoi(query.Get("after")); err != nil || after < 0 {
+			writeProblem(w, r, 400, "after must be a user id", validation.Errors{{Field: "after", Code: "minimum", Args: []interface{}{0}}})
+			return
+		}
+	}
+	// One more user than asked for tells whether there is a next page.
+	users, err := model.ListUsers(db, model.UserFilter{After: after, Limit: limit + 1})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	links := api.Links{Self: r.URL.RequestURI()}
+	if len(users) > limit {
+		users = users[:limit]
+		query.Set("after", strconv.Itoa(users[limit-1].Id))
+		links.Next = r.URL.Path + "?" + query.Encode()
+		w.Header().Set("Link", "<"+links.Next+">; rel=\"next\"")
+	}
+	if envelopeRequested(r) {
+		marshalAndWrite(&api.Envelope{
+			Data:  renderUsers(r, users),
+			Meta:  api.Meta{Count: len(users)},
+			Links: links,
+		}, w, r)
+		return
+	}
+	marshalAndWrite(renderUsers(r, users), w, r)
 }
 
 func getUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
@@ -55,11 +150,11 @@ func getUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int)
 		}
 		return
 	}
-	marshalAndWriteJson(user, w)
+	writeUser(w, r, user)
 }
 
 func deleteUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
-	user, err := model.DeleteUser(db, id)
+	user, err := model.DeleteUser(db, requestAudit(r.Context()), id)
 	if err != nil {
 		log.Println(err)
 		switch err {
@@ -70,50 +165,123 @@ func deleteUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id in
 		}
 		return
 	}
-	marshalAndWriteJson(user, w)
+	marshalAndWrite(renderUser(r, user), w, r)
 }
 
 func createUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, name string, email string, password string) {
-	user, err := model.CreateUser(db, name, email, password)
+	user, err := model.CreateUser(db, requestAudit(r.Context()), name, email, password)
 	if err != nil {
 		log.Println(err)
-		switch err {
-		case sql.ErrNoRows:
-			http.Error(w, err.Error(), 404)
-		default:
-			http.Error(w, err.Error(), 500)
-		}
+		writeMutationError(w, r, err)
 		return
 	}
-	marshalAndWriteJson(user, w)
+	writeUser(w, r, user)
 }
 
 func updateUserHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, name string, email string, password string) {
-	user, err := model.UpdateUser(db, id, name, email, password)
+	version, ok := ifMatchVersion(r)
+	if !ok {
+		http.Error(w, model.ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	var user *model.User
+	var err error
+	if version != 0 {
+		user, err = model.UpdateUserIfVersion(db, requestAudit(r.Context()), id, version, name, email, password)
+	} else {
+		user, err = model.UpdateUser(db, requestAudit(r.Context()), id, name, email, password)
+	}
 	if err != nil {
 		log.Println(err)
-		switch err {
-		case sql.ErrNoRows:
-			http.Error(w, err.Error(), 404)
-		default:
-			http.Error(w, err.Error(), 500)
-		}
+		writeMutationError(w, r, err)
 		return
 	}
-	marshalAndWriteJson(user, w)
+	writeUser(w, r, user)
 }
 
-func marshalAndWriteJson(data interface{}, w http.ResponseWriter) {
-	var body []byte
-	body, err := json.Marshal(data)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), 500)
+// writeMutationError reports an error from a create or update, turning input
+// problems into field-level problem+json responses.
+func writeMutationError(w http.ResponseWriter, r *http.Request, err error) {
+	status, fields := mutationErrorStatus(err)
+	if fields != nil {
+		writeProblem(w, r, status, err.Error(), fields)
 		return
 	}
+	http.Error(w, err.Error(), status)
+}
+
+// mutationErrorStatus maps an error from a user write to an HTTP status and,
+// for input problems, the fields at fault.
+func mutationErrorStatus(err error) (int, validation.Errors) {
+	var fieldErrs validation.Errors
+	switch {
+	case err == sql.ErrNoRows:
+		return 404, nil
+	case errors.As(err, &fieldErrs):
+		return 400, fieldErrs
+	case err == model.ErrEmailTaken:
+		return 409, validation.Errors{{Field: "email", Code: "taken"}}
+	case err == model.ErrVersionConflict:
+		return http.StatusPreconditionFailed, nil
+	case err == model.ErrInvalidToken:
+		return 400, validation.Errors{{Field: "token", Code: "token"}}
+	default:
+		return 500, nil
+	}
+}
+
+type problemField struct {
+	Field   string `json:"field" xml:"field" yaml:"field"`
+	Code    string `json:"code" xml:"code" yaml:"code"`
+	Message string `json:"message" xml:"message" yaml:"message"`
+}
+
+type problem struct {
+	Type   string         `json:"type"`
+	Title  string         `json:"title"`
+	Status int            `json:"status"`
+	Detail string         `json:"detail,omitempty"`
+	Errors []problemField `json:"errors,omitempty"`
+}
+
+// writeProblem writes an RFC 7807 application/problem+json response, with
+// field messages in the request's preferred language.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, fields validation.Errors) {
+	locale := requestLocale(r)
+	p := problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+	for _, f := range fields {
+		p.Errors = append(p.Errors, problemField{Field: f.Field, Code: f.Code, Message: f.Message(locale)})
+	}
+	body, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
+// requestLocale returns the primary language subtag of the first
+// Accept-Language entry, e.g. "es" for "es-MX,es;q=0.9".
+func requestLocale(r *http.Request) string {
+	lang := r.Header.Get("Accept-Language")
+	if i := strings.IndexAny(lang, ",;"); i >= 0 {
+		lang = lang[:i]
+	}
+	if i := strings.Index(lang, "-"); i >= 0 {
+		lang = lang[:i]
+	}
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return validation.DefaultLocale
+	}
+	return lang
+}
+
+func envelopeRequested(r *http.Request) bool {
+	if v, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil {
+		return v
+	}
+	return envelopeCollections
+}
+
 func validateId(idString string, w http.ResponseWriter) int {
 	id, err := strconv.Atoi(idString)
 	if err != nil {
@@ -123,39 +291,120 @@ func validateId(idString string, w http.ResponseWriter) int {
 	return id
 }
 
-func parseRequest(r *http.Request) (string, string, string) {
-	r.ParseForm()
-	name := r.Form["name"][0]
-	email := r.Form["email"][0]
-	password := r.Form["password"][0]
-	return name, email, password
+type userInput struct {
+	Name     string `json:"name" xml:"name" yaml:"name"`
+	Email    string `json:"email" xml:"email" yaml:"email"`
+	Password string `json:"password" xml:"password" yaml:"password"`
+}
+
+// parseRequest reads the user fields from a form or query string, or from a
+// body in any registered media type.
+func parseRequest(r *http.Request) (string, string, string, error) {
+	if isForm(r) {
+		return r.FormValue("name"), r.FormValue("email"), r.FormValue("password"), nil
+	}
+	input := userInput{}
+	if err := decodeBody(r, &input); err != nil {
+		return "", "", "", err
+	}
+	return input.Name, input.Email, input.Password, nil
+}
+
+// writeParseError reports a body that could not be decoded.
+func writeParseError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	if err == errUnsupportedMediaType {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	http.Error(w, err.Error(), 400)
 }
 
 func getRouter(db *sql.DB) *mux.Router {
 	router := mux.NewRouter()
 
+	router.Use(withRequestAudit)
+	router.Use(rateLimited)
+	router.Use(restrictUnverified(db))
+	router.Use(newSpecValidator(openAPISpec()).middleware)
 	router.HandleFunc("/readiness", readinessHandler).Methods(http.MethodGet)
-	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/openapi.json", openAPIHandler).Methods(http.MethodGet)
+	router.HandleFunc("/docs", docsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/ws", wsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/graphql", graphQLHandler(newGraphQLSchema(db))).Methods(http.MethodGet, http.MethodPost)
+	addWebhookRoutes(router, db)
+	addAuditRoutes(router, db)
+	addAuthRoutes(router, db)
+	for _, v := range apiVersions {
+		versionRouter := router.PathPrefix("/" + v.name).Subrouter()
+		versionRouter.Use(versioned(v))
+		addUserRoutes(versionRouter, db)
+	}
+	unversionedRouter := router.NewRoute().Subrouter()
+	unversionedRouter.Use(versioned(nil))
+	addUserRoutes(unversionedRouter, db)
+
+	return router
+}
+
+// addUserRoutes registers the users resource on router, which is either the
+// root router or one of the versioned subrouters.
+func addUserRoutes(router *mux.Router, db *sql.DB) {
+	router.HandleFunc("/users:batch", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		batchUsersHandler(w, r, db)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/users/events", func(w http.ResponseWriter, r *http.Request) {
+		userEventsHandler(w, r, db)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/users/export", func(w http.ResponseWriter, r *http.Request) {
+		exportUsersHandler(w, r, db)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/users/import", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		importUsersHandler(w, r, db)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/users", negotiated(idempotent(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			getUsersHandler(w, r, db)
 		} else if r.Method == http.MethodPost {
-			name, email, password := parseRequest(r)
+			name, email, password, err := parseRequest(r)
+			if err != nil {
+				writeParseError(w, err)
+				return
+			}
 			createUserHandler(w, r, db, name, email, password)
 		}
-	}).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+	}))).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/users/{id:[0-9]+}", negotiated(func(w http.ResponseWriter, r *http.Request) {
 		id := validateId(mux.Vars(r)["id"], w)
 		if r.Method == http.MethodGet {
+			if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+				getUserAsOfHandler(w, r, db, id, asOf)
+				return
+			}
 			getUserHandler(w, r, db, id)
 		} else if r.Method == http.MethodDelete {
 			deleteUserHandler(w, r, db, id)
 		} else if r.Method == http.MethodPut {
-			name, email, password := parseRequest(r)
+			name, email, password, err := parseRequest(r)
+			if err != nil {
+				writeParseError(w, err)
+				return
+			}
 			updateUserHandler(w, r, db, id, name, email, password)
 		}
-	}).Methods(http.MethodGet, http.MethodDelete, http.MethodPut)
-
-	return router
+	})).Methods(http.MethodGet, http.MethodDelete, http.MethodPut)
+	router.HandleFunc("/users/{id:[0-9]+}/versions", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		userVersionsHandler(w, r, db, validateId(mux.Vars(r)["id"], w))
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id:[0-9]+}/revert", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		revertUserHandler(w, r, db, validateId(mux.Vars(r)["id"], w))
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/users/{id:[0-9]+}/unlock", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		unlockUserHandler(w, r, db, validateId(mux.Vars(r)["id"], w))
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/users/{id:[0-9]+}/history", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		userHistoryHandler(w, r, db, validateId(mux.Vars(r)["id"], w))
+	})).Methods(http.MethodGet)
 }
 
 func httpServer(host string, port string, db *sql.DB) {
@@ -170,6 +419,80 @@ func httpServer(host string, port string, db *sql.DB) {
 	log.Fatal(srv.ListenAndServe())
 }
 
+// grpcRoute is the HTTP route a gRPC method mirrors.
+type grpcRoute struct {
+	method string
+	path   string
+}
+
+// grpcRoutes maps each gRPC method to its HTTP route, so that a call is rate
+// limited and restricted like the request it mirrors.
+var grpcRoutes = map[string]grpcRoute{
+	"/users.v1.UserService/GetUser":    {http.MethodGet, "/users/{id}"},
+	"/users.v1.UserService/ListUsers":  {http.MethodGet, "/users"},
+	"/users.v1.UserService/CreateUser": {http.MethodPost, "/users"},
+	"/users.v1.UserService/UpdateUser": {http.MethodPut, "/users/{id}"},
+	"/users.v1.UserService/DeleteUser": {http.MethodDelete, "/users/{id}"},
+}
+
+// grpcCheck decides whether a gRPC call may go ahead, returning the status
+// to fail it with if not.
+type grpcCheck func(ctx context.Context, fullMethod string) error
+
+// grpcChecked runs checks, in order, before every unary and streaming call.
+func grpcChecked(checks ...grpcCheck) []grpc.ServerOption {
+	check := func(ctx context.Context, fullMethod string) error {
+		for _, c := range checks {
+			if err := c(ctx, fullMethod); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// newGRPCServer returns the gRPC user service, held to the same rate limits
+// and unverified accounts policy as the HTTP API.
+func newGRPCServer(db *sql.DB) *grpc.Server {
+	srv := grpc.NewServer(grpcChecked(grpcRateLimited, grpcRestrictUnverified(db))...)
+	rpc.Register(srv, db)
+	return srv
+}
+
+// grpcServer serves the gRPC user service on its own port.
+func grpcServer(host string, port string, db *sql.DB) {
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%s", host, port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := newGRPCServer(db)
+	log.Printf("Listening grpc://%s", lis.Addr())
+	log.Fatal(srv.Serve(lis))
+}
+
+// getEnvDefault returns the value of an optional variable, or def when it is
+// not set or blank.
+func getEnvDefault(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
 func getEnv(key string) string {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -182,11 +505,130 @@ func getEnv(key string) string {
 
 func main() {
 	dbUrl := getEnv("DATABASE_URL")
+	if err := api.SetNaming(getEnvDefault("JSON_NAMING", api.NamingSnake)); err != nil {
+		log.Fatal(err)
+	}
+	envelope, err := strconv.ParseBool(getEnvDefault("RESPONSE_ENVELOPE", "false"))
+	if err != nil {
+		log.Fatalf("RESPONSE_ENVELOPE: %v", err)
+	}
+	envelopeCollections = envelope
+	switch mode := getEnvDefault("OPENAPI_VALIDATION", validationOff); mode {
+	case validationOff, validationAudit, validationEnforce:
+		openAPIRequestMode = mode
+	default:
+		log.Fatalf("OPENAPI_VALIDATION: unknown mode %q", mode)
+	}
+	openAPIValidateResponses, err = strconv.ParseBool(getEnvDefault("OPENAPI_VALIDATE_RESPONSES", "false"))
+	if err != nil {
+		log.Fatalf("OPENAPI_VALIDATE_RESPONSES: %v", err)
+	}
+	webhooksEnabled, err = strconv.ParseBool(getEnvDefault("WEBHOOKS", "false"))
+	if err != nil {
+		log.Fatalf("WEBHOOKS: %v", err)
+	}
+	auditActorHeader = getEnvDefault("AUDIT_ACTOR_HEADER", auditActorHeader)
+	rpc.ActorMetadataKey = strings.ToLower(auditActorHeader)
+	idempotencyTTL, err = time.ParseDuration(getEnvDefault("IDEMPOTENCY_TTL", idempotencyTTL.String()))
+	if err != nil {
+		log.Fatalf("IDEMPOTENCY_TTL: %v", err)
+	}
+	rateLimits, err = ratelimit.ParseRules(getEnvDefault("RATE_LIMITS", defaultRateLimits))
+	if err != nil {
+		log.Fatalf("RATE_LIMITS: %v", err)
+	}
+	rateLimitKeyHeader = getEnvDefault("RATE_LIMIT_KEY_HEADER", rateLimitKeyHeader)
+	rateLimitIPHeader = getEnvDefault("RATE_LIMIT_IP_HEADER", rateLimitIPHeader)
+	loginMaxFailures, err = strconv.Atoi(getEnvDefault("LOGIN_MAX_FAILURES", strconv.Itoa(loginMaxFailures)))
+	if err != nil {
+		log.Fatalf("LOGIN_MAX_FAILURES: %v", err)
+	}
+	loginMaxIPFailures, err = strconv.Atoi(getEnvDefault("LOGIN_MAX_IP_FAILURES", strconv.Itoa(loginMaxIPFailures)))
+	if err != nil {
+		log.Fatalf("LOGIN_MAX_IP_FAILURES: %v", err)
+	}
+	loginLockDuration, err = time.ParseDuration(getEnvDefault("LOGIN_LOCK_DURATION", loginLockDuration.String()))
+	if err != nil {
+		log.Fatalf("LOGIN_LOCK_DURATION: %v", err)
+	}
+	adminActors = parseAdminActors(getEnvDefault("ADMIN_ACTORS", ""))
+	passwordResetTTL, err = time.ParseDuration(getEnvDefault("PASSWORD_RESET_TTL", passwordResetTTL.String()))
+	if err != nil {
+		log.Fatalf("PASSWORD_RESET_TTL: %v", err)
+	}
+	passwordResetURL = getEnvDefault("PASSWORD_RESET_URL", passwordResetURL)
+	userMailer, err = newMailer(getEnvDefault("MAILER", "file:mail"), getEnvDefault("MAIL_FROM", "noreply@localhost"))
+	if err != nil {
+		log.Fatalf("MAILER: %v", err)
+	}
+	switch policy := getEnvDefault("UNVERIFIED_ACCOUNTS", unverifiedAccounts); policy {
+	case unverifiedAllow, unverifiedBlockLogin, unverifiedReadOnly:
+		unverifiedAccounts = policy
+	default:
+		log.Fatalf("UNVERIFIED_ACCOUNTS: unknown policy %q", policy)
+	}
+	emailVerificationTTL, err = time.ParseDuration(getEnvDefault("EMAIL_VERIFICATION_TTL", emailVerificationTTL.String()))
+	if err != nil {
+		log.Fatalf("EMAIL_VERIFICATION_TTL: %v", err)
+	}
+	emailVerificationURL = getEnvDefault("EMAIL_VERIFICATION_URL", emailVerificationURL)
+	graphiQLEnabled, err = strconv.ParseBool(getEnvDefault("GRAPHIQL", "false"))
+	if err != nil {
+		log.Fatalf("GRAPHIQL: %v", err)
+	}
+	if sunset := getEnvDefault("API_V1_SUNSET", ""); sunset != "" {
+		apiV1.sunset, err = time.Parse("2006-01-02", sunset)
+		if err != nil {
+			log.Fatalf("API_V1_SUNSET: %v", err)
+		}
+	}
+
+	if len(os.Args) > 1 {
+		db := model.GetDb(dbUrl)
+		defer db.Close()
+		if err := runCommand(db, os.Args[1], os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	httpHost := getEnv("HTTP_HOST")
 	httpPort := getEnv("HTTP_PORT")
 
 	db := model.GetDb(dbUrl)
 	defer db.Close()
 
+	sinks, err := outboxSinks(getEnvDefault("OUTBOX_SINKS", "broker,webhooks,verification"), db)
+	if err != nil {
+		log.Fatalf("OUTBOX_SINKS: %v", err)
+	}
+	go outbox.NewRelay(db, sinks...).Run(context.Background(), outboxPollInterval)
+	if err := listenForUserEvents(context.Background(), dbUrl); err != nil {
+		log.Fatalf("listening for user events: %v", err)
+	}
+	if webhooksEnabled {
+		go webhooks.NewDispatcher(db).Run(context.Background(), webhookPollInterval)
+	}
+	switch store := getEnvDefault("IDEMPOTENCY_STORE", "postgres"); store {
+	case "postgres":
+		pgStore := idempotency.NewPostgresStore(db)
+		go pgStore.Run(context.Background(), time.Hour)
+		idempotencyKeys = pgStore
+	case "memory":
+	default:
+		log.Fatalf("IDEMPOTENCY_STORE: unknown store %q", store)
+	}
+	switch store := getEnvDefault("RATE_LIMIT_STORE", "memory"); store {
+	case "postgres":
+		pgStore := ratelimit.NewPostgresStore(db)
+		go pgStore.Run(context.Background(), time.Hour)
+		rateLimitStore = pgStore
+	case "memory":
+	default:
+		log.Fatalf("RATE_LIMIT_STORE: unknown store %q", store)
+	}
+	if grpcPort := getEnvDefault("GRPC_PORT", ""); grpcPort != "" {
+		go grpcServer(httpHost, grpcPort, db)
+	}
 	httpServer(httpHost, httpPort, db)
 }
@@ -1,22 +1,69 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/api"
 	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/userspb"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
 )
 
+// TestMain checks every response the suite provokes against the OpenAPI
+// document, so that handlers cannot drift from it unnoticed.
+func TestMain(m *testing.M) {
+	model.PasswordHashCost = bcrypt.MinCost
+	violations := []string{}
+	openAPIValidateResponses = true
+	openAPIResponseViolation = func(r *http.Request, status int, err error) {
+		violations = append(violations, fmt.Sprintf("%s %s -> %d: %v", r.Method, r.URL, status, err))
+	}
+
+	code := m.Run()
+	for _, violation := range violations {
+		fmt.Fprintln(os.Stderr, "response does not match the OpenAPI document:", violation)
+	}
+	if code == 0 && len(violations) > 0 {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// hashOf matches a query argument holding the stored hash of a password.
+type hashOf string
+
+func (h hashOf) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && model.CheckPassword(hash, string(h))
+}
+
+// storedPassword returns what the users table holds for password.
+func storedPassword(password string) string {
+	hash, err := model.HashPassword(password)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
 func httpRequest(router *mux.Router, method string, url string, headers map[string]string) ([]byte, *http.Response, error) {
 	request := httptest.NewRequest(method, url, nil)
 	for key, val := range headers {
@@ -29,6 +76,16 @@ func httpRequest(router *mux.Router, method string, url string, headers map[stri
 	return body, resp, err
 }
 
+// newTimingOutServer serves router with read and write timeouts like
+// httpServer's, but short enough for tests to outlast.
+func newTimingOutServer(router http.Handler, timeout time.Duration) *httptest.Server {
+	server := httptest.NewUnstartedServer(router)
+	server.Config.ReadTimeout = timeout
+	server.Config.WriteTimeout = timeout
+	server.Start()
+	return server
+}
+
 func getMockDBAndRouter() (*sql.DB, sqlmock.Sqlmock, *mux.Router) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -38,6 +95,23 @@ func getMockDBAndRouter() (*sql.DB, sqlmock.Sqlmock, *mux.Router) {
 	return db, mock, router
 }
 
+// getMockDBAndGRPC serves newGRPCServer over an in-memory listener.
+func getMockDBAndGRPC(t *testing.T) (*sql.DB, sqlmock.Sqlmock, userspb.UserServiceClient) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(db)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return db, mock, userspb.NewUserServiceClient(conn)
+}
+
 func TestGetEnvOk(t *testing.T) {
 	varname := "test"
 	os.Setenv(varname, "1")
@@ -79,7 +153,7 @@ func TestHandleGetUsersHttpOk(t *testing.T) {
 	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
-	require.Equal(t, "[{\"Id\":1,\"Name\":\"Kaladin\",\"Email\":\"k@s.com\"},{\"Id\":2,\"Name\":\"Adolin\",\"Email\":\"a@k.com\"}]", string(body))
+	require.Equal(t, "[{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"},{\"id\":2,\"name\":\"Adolin\",\"email\":\"a@k.com\"}]", string(body))
 
 	result := [2]model.User{}
 	err = json.Unmarshal(body, &result)
@@ -87,6 +161,108 @@ func TestHandleGetUsersHttpOk(t *testing.T) {
 	require.Equal(t, "Kaladin", result[0].Name)
 }
 
+func TestHandleGetUsersEnvelope(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users?envelope=true", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "{\"data\":[{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}],\"meta\":{\"count\":1},\"links\":{\"self\":\"/users\"}}", string(body))
+}
+
+func TestHandleGetUsersPage(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("3", "Kaladin", "k@s.com")
+	rows.AddRow("4", "Adolin", "a@k.com")
+	rows.AddRow("7", "Shallan", "s@d.com")
+	mock.ExpectQuery("SELECT id, name, email FROM users WHERE id>\\$1 ORDER BY id LIMIT \\$2").WithArgs(2, 3).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users?envelope=true&limit=2&after=2", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "{\"data\":[{\"id\":3,\"name\":\"Kaladin\",\"email\":\"k@s.com\"},{\"id\":4,\"name\":\"Adolin\",\"email\":\"a@k.com\"}],"+
+		"\"meta\":{\"count\":2},\"links\":{\"self\":\"/users?envelope=true\\u0026limit=2\\u0026after=2\",\"next\":\"/users?after=4\\u0026envelope=true\\u0026limit=2\"}}", string(body))
+	require.Equal(t, "</users?after=4&envelope=true&limit=2>; rel=\"next\"", resp.Header.Get("Link"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleGetUsersLastPage(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("7", "Shallan", "s@d.com")
+	mock.ExpectQuery("SELECT id, name, email FROM users WHERE id>\\$1 ORDER BY id LIMIT \\$2").WithArgs(4, 3).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users?envelope=true&limit=2&after=4", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.NotContains(t, string(body), "\"next\"")
+	require.Empty(t, resp.Header.Get("Link"))
+}
+
+func TestHandleGetUsersPageTooLarge(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users?limit=5000", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+}
+
+func TestHandleGetUsersEnvelopeByDefault(t *testing.T) {
+	envelopeCollections = true
+	defer func() { envelopeCollections = false }()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	rows = mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, _, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users", nil)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "\"data\":")
+
+	body, _, err = httpRequest(router, http.MethodGet, "http://localhost:1234/users?envelope=false", nil)
+	require.NoError(t, err)
+	require.Equal(t, "[{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}]", string(body))
+}
+
+func TestCamelNaming(t *testing.T) {
+	api.SetNaming(api.NamingCamel)
+	defer api.SetNaming(api.NamingSnake)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows(auditRowColumns).
+		AddRow(1, 1, "dalinar", "req-1", "create", nil, []byte(`{"id": 1, "name": "Kal", "email": "k@s.com"}`), time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC))
+	mock.ExpectQuery("FROM user_audit WHERE user_id").WithArgs(1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1/history", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.JSONEq(t, `[{"id":1,"userId":1,"actor":"dalinar","requestId":"req-1","operation":"create",
+		"after":{"id":1,"name":"Kal","email":"k@s.com"},"changedAt":"2020-09-01T12:00:00Z"}]`, string(body))
+
+	// The OpenAPI document describes the same names.
+	body, _, err = httpRequest(router, http.MethodGet, "http://localhost:1234/openapi.json", nil)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"changedAt"`)
+	require.NotContains(t, string(body), `"changed_at"`)
+}
+
 func TestHandleGetUsersNoRows(t *testing.T) {
 	db, mock, router := getMockDBAndRouter()
 	defer db.Close()
@@ -115,14 +291,15 @@ func TestHandleGetUserHttpOk(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("SELECT")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow("1", "Kaladin", "k@s.com")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
 	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
-	require.Equal(t, "{\"Id\":1,\"Name\":\"Kaladin\",\"Email\":\"k@s.com\"}", string(body))
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}", string(body))
+	require.Equal(t, `"1"`, resp.Header.Get("ETag"))
 
 	result := &model.User{}
 	err = json.Unmarshal(body, &result)
@@ -162,12 +339,12 @@ func TestHandleDeleteUserHttpOk(t *testing.T) {
 	mock.ExpectPrepare("DELETE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
 	rows.AddRow("1", "Kaladin", "k@s.com")
-	mock.ExpectQuery("DELETE").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("DELETE").WithArgs(1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodDelete, "http://localhost:1234/users/1", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
-	require.Equal(t, "{\"Id\":1,\"Name\":\"Kaladin\",\"Email\":\"k@s.com\"}", string(body))
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}", string(body))
 
 	result := &model.User{}
 	err = json.Unmarshal(body, &result)
@@ -181,7 +358,7 @@ func TestHandleDeleteUserNotFound(t *testing.T) {
 
 	mock.ExpectPrepare("DELETE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
-	mock.ExpectQuery("DELETE").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("DELETE").WithArgs(1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodDelete, "http://localhost:1234/users/1", nil)
 	require.NoError(t, err)
@@ -193,7 +370,7 @@ func TestHandleDeleteUserSqlError(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("DELETE")
-	mock.ExpectQuery("DELETE").WithArgs(1).WillReturnError(errors.New("error"))
+	mock.ExpectQuery("DELETE").WithArgs(1, "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("error"))
 
 	body, resp, err := httpRequest(router, http.MethodDelete, "http://localhost:1234/users/1", nil)
 	require.NoError(t, err)
@@ -205,14 +382,14 @@ func TestHandleCreateUserHttpOk(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("INSERT")
-	rows := mock.NewRows([]string{"name", "email", "password"})
-	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", "password").WillReturnRows(rows)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=password", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
-	require.Equal(t, "{\"Id\":1,\"Name\":\"Kaladin\",\"Email\":\"k@s.com\"}", string(body))
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}", string(body))
 
 	result := &model.User{}
 	err = json.Unmarshal(body, &result)
@@ -224,14 +401,66 @@ func TestHandleCreateUserBadArgs(t *testing.T) {
 	db, mock, router := getMockDBAndRouter()
 	defer db.Close()
 
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=1&password=password", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(body), "\"field\":\"email\"")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleCreateUserMissingArgs(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+
+	result := &problem{}
+	err = json.Unmarshal(body, result)
+	require.NoError(t, err, string(body))
+	require.Len(t, result.Errors, 3)
+	require.Equal(t, "is required", result.Errors[0].Message)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleCreateUserLocalizedErrors(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	headers := map[string]string{"Accept-Language": "es-MX,es;q=0.9"}
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=short", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.Contains(t, string(body), "debe tener al menos 8 caracteres")
+}
+
+func TestHandleCreateUserNormalizesEmail(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
 	mock.ExpectPrepare("INSERT")
-	rows := mock.NewRows([]string{"name", "email", "password"})
-	rows.AddRow(1, "Kaladin", 1)
-	mock.ExpectQuery("INSERT").WithArgs("Kaladin", 1, "password").WillReturnRows(rows)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "Kal@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "Kal@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
-	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=1&password=password", nil)
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=%20Kal@S.COM%20&password=password", nil)
 	require.NoError(t, err)
-	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, string(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+}
+
+func TestHandleCreateUserEmailTaken(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_lower_key"})
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=password", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, resp.StatusCode, string(body))
+	require.Contains(t, string(body), "\"field\":\"email\"")
 }
 
 func TestHandleCreateUserSqlError(t *testing.T) {
@@ -239,7 +468,7 @@ func TestHandleCreateUserSqlError(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("INSERT")
-	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", "password").WillReturnError(errors.New("error"))
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("error"))
 
 	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=password", nil)
 	require.NoError(t, err)
@@ -251,14 +480,15 @@ func TestHandleUpdateUserHttpOk(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("UPDATE")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", "password", 1).WillReturnRows(rows)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 2)
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=k@s.com&password=password", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
-	require.Equal(t, "{\"Id\":1,\"Name\":\"Kaladin\",\"Email\":\"k@s.com\"}", string(body))
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}", string(body))
+	require.Equal(t, `"2"`, resp.Header.Get("ETag"))
 
 	result := &model.User{}
 	err = json.Unmarshal(body, &result)
@@ -270,14 +500,11 @@ func TestHandleUpdateUserBadArgs(t *testing.T) {
 	db, mock, router := getMockDBAndRouter()
 	defer db.Close()
 
-	mock.ExpectPrepare("UPDATE")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", nil, 1).WillReturnRows(rows)
-
 	body, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=k@s.com&password=", nil)
 	require.NoError(t, err)
-	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, string(body))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.Contains(t, string(body), "\"field\":\"password\"")
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestHandleUpdateUserSqlError(t *testing.T) {
@@ -285,20 +512,30 @@ func TestHandleUpdateUserSqlError(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("UPDATE")
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", "password", 1).WillReturnError(errors.New("error"))
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("error"))
 
 	body, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=k@s.com&password=password", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, string(body))
 }
 
+func TestHandleUpdateUserBadEmail(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=kaladin&password=password", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestHandleUpdateUserNotFound(t *testing.T) {
 	db, mock, router := getMockDBAndRouter()
 	defer db.Close()
 
 	mock.ExpectPrepare("UPDATE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", "password", 1).WillReturnRows(rows)
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
 
 	body, resp, err := httpRequest(router, http.MethodPut, "http://localhost:1234/users/1?name=Kaladin&email=k@s.com&password=password", nil)
 	require.NoError(t, err)
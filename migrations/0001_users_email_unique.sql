@@ -0,0 +1,3 @@
+-- Emails are compared case-insensitively, so two users may not share an
+-- address that differs only in case.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
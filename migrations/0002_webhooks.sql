@@ -0,0 +1,26 @@
+-- Partner callbacks for user lifecycle events, and the queue of deliveries
+-- still to be made to them.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id         serial PRIMARY KEY,
+    url        text NOT NULL,
+    secret     text NOT NULL,
+    events     text[] NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- status is pending until a delivery succeeds (delivered) or runs out of
+-- attempts (dead). Pending deliveries are retried from next_attempt_at.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              bigserial PRIMARY KEY,
+    webhook_id      integer NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_type      text NOT NULL,
+    payload         text NOT NULL,
+    status          text NOT NULL DEFAULT 'pending',
+    attempts        integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error      text NOT NULL DEFAULT '',
+    created_at      timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
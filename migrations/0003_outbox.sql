@@ -0,0 +1,19 @@
+-- Every user write adds its event here in the same statement, so an event
+-- exists exactly when its change was committed. The relay publishes events
+-- in id order and stamps published_at; event_id lets consumers drop the
+-- duplicates that at-least-once publication can produce.
+CREATE TABLE IF NOT EXISTS outbox (
+    id           bigserial PRIMARY KEY,
+    event_id     uuid NOT NULL UNIQUE DEFAULT md5(random()::text || clock_timestamp()::text)::uuid,
+    event_type   text NOT NULL,
+    payload      text NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    published_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished ON outbox (id) WHERE published_at IS NULL;
+
+-- Webhook deliveries are queued once per event, however often the relay
+-- hands the event over.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id uuid;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event ON webhook_deliveries (webhook_id, event_id);
@@ -0,0 +1,28 @@
+-- Who changed which user, and how. Rows are written in the same statement
+-- as the change and are never updated or deleted; before and after hold the
+-- user without its password, and are null for creates and deletes
+-- respectively. user_id has no foreign key so that history outlives the user.
+CREATE TABLE IF NOT EXISTS user_audit (
+    id         bigserial PRIMARY KEY,
+    user_id    integer NOT NULL,
+    actor      text NOT NULL,
+    request_id text NOT NULL DEFAULT '',
+    operation  text NOT NULL,
+    before     jsonb,
+    after      jsonb,
+    changed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user ON user_audit (user_id, id);
+CREATE INDEX IF NOT EXISTS user_audit_actor ON user_audit (actor, changed_at);
+CREATE INDEX IF NOT EXISTS user_audit_changed_at ON user_audit (changed_at);
+
+CREATE OR REPLACE FUNCTION user_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'user_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS user_audit_append_only ON user_audit;
+CREATE TRIGGER user_audit_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON user_audit
+    FOR EACH STATEMENT EXECUTE FUNCTION user_audit_append_only();
@@ -0,0 +1,54 @@
+-- Every version of every user, for reading a user as of a point in time and
+-- reverting to an earlier version. users.version counts the writes to a row
+-- and is what conditional updates compare against. Both are kept by triggers,
+-- so that every write path, COPY included, is versioned. A version is
+-- current from valid_from until valid_to, which is null for the current
+-- version and set when the user is changed again or deleted. Passwords are
+-- not kept.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS user_versions (
+    user_id    integer NOT NULL,
+    version    integer NOT NULL,
+    name       text NOT NULL,
+    email      text NOT NULL,
+    valid_from timestamptz NOT NULL DEFAULT now(),
+    valid_to   timestamptz,
+    PRIMARY KEY (user_id, version)
+);
+
+-- Users that exist before versioning start with their current row.
+INSERT INTO user_versions (user_id, version, name, email)
+    SELECT id, version, name, email FROM users
+    ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION users_bump_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.version := 1;
+    ELSE
+        NEW.version := OLD.version + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION users_record_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        UPDATE user_versions SET valid_to = now() WHERE user_id = OLD.id AND valid_to IS NULL;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        INSERT INTO user_versions (user_id, version, name, email) VALUES (NEW.id, NEW.version, NEW.name, NEW.email);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_bump_version();
+
+DROP TRIGGER IF EXISTS users_record_version ON users;
+CREATE TRIGGER users_record_version AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_record_version();
@@ -0,0 +1,15 @@
+-- Responses to requests sent with an Idempotency-Key, replayed when the
+-- request is retried. status is null while the first request is in flight;
+-- expires_at is then the end of its lease, and afterwards the end of the
+-- time the response is kept.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key          text PRIMARY KEY,
+    fingerprint  text NOT NULL,
+    status       integer,
+    content_type text,
+    body         bytea,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    expires_at   timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,11 @@
+-- Token buckets of the rate limiter, shared by every instance. tokens is what
+-- was left at updated_at; the bucket refills from there on each request, and
+-- allowed records whether that request got a token.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key        text PRIMARY KEY,
+    tokens     double precision NOT NULL,
+    allowed    boolean NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
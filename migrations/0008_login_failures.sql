@@ -0,0 +1,12 @@
+-- Failed logins, counted per account ("email:" and the normalized email,
+-- whether or not a user has it) and per client address ("ip:" and the
+-- address). A row is forgotten once its last failure is older than the lock
+-- duration, and an account's row is deleted when it logs in or is unlocked.
+-- The counts are kept apart from users so that they do not version the user.
+CREATE TABLE IF NOT EXISTS login_failures (
+    key            text PRIMARY KEY,
+    failures       integer NOT NULL,
+    last_failed_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_last_failed_at ON login_failures (last_failed_at);
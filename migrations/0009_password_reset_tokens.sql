@@ -0,0 +1,13 @@
+-- Tokens sent to users who asked to reset their password. Only the SHA-256
+-- of a token is kept, so that reading the table does not give tokens away.
+-- A token works once, before expires_at; used_at is set when it is used, or
+-- when another token of the same user is.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    token_hash text PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user ON password_reset_tokens (user_id);
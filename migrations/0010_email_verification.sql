@@ -0,0 +1,32 @@
+-- When each user proved they own their email, by following a token emailed
+-- to them. Changing a user's email clears it, by trigger so that every write
+-- path does, until the new address is verified in turn.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
+
+CREATE OR REPLACE FUNCTION users_unverify_email() RETURNS trigger AS $$
+BEGIN
+    IF NEW.email IS DISTINCT FROM OLD.email AND NEW.email_verified_at IS NOT DISTINCT FROM OLD.email_verified_at THEN
+        NEW.email_verified_at := NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_unverify_email ON users;
+CREATE TRIGGER users_unverify_email BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_unverify_email();
+
+-- Tokens emailed to users to verify an address. Like password reset tokens,
+-- only their SHA-256 is kept, and each works once before expires_at. A token
+-- verifies the email it was sent to, and nothing once the user's email has
+-- changed.
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    token_hash text PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS email_verification_tokens_user ON email_verification_tokens (user_id, email);
@@ -0,0 +1,14 @@
+-- Passwords used to be stored as given; they are now bcrypt hashes. This
+-- hashes the rows written before, with pgcrypto's bcrypt, which the API can
+-- check. The versioning triggers are off meanwhile, since a password is not
+-- part of a user's versions.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE users DISABLE TRIGGER users_bump_version;
+ALTER TABLE users DISABLE TRIGGER users_record_version;
+
+UPDATE users SET password = crypt(password, gen_salt('bf', 10))
+    WHERE password !~ '^\$2[aby]\$[0-9]{2}\$';
+
+ALTER TABLE users ENABLE TRIGGER users_bump_version;
+ALTER TABLE users ENABLE TRIGGER users_record_version;
@@ -0,0 +1,10 @@
+-- Outbox ids are drawn when a row is written, not when it commits, so a
+-- transaction may commit an event with a lower id after the relay has
+-- published higher ones. Each event now records the transaction that wrote
+-- it, and the relay only publishes events of transactions older than any
+-- still running, in (xid, id) order. Nothing can later commit before an
+-- event already published in that order. This needs Postgres 13.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS xid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+DROP INDEX IF EXISTS outbox_unpublished;
+CREATE INDEX IF NOT EXISTS outbox_unpublished ON outbox (xid, id) WHERE published_at IS NULL;
@@ -0,0 +1,4 @@
+-- Subscribers to /users/events resume after the last event they saw by
+-- reading the events published after it, in the order the relay publishes
+-- them.
+CREATE INDEX IF NOT EXISTS outbox_order ON outbox (xid, id);
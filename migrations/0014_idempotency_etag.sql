@@ -0,0 +1,3 @@
+-- The ETag of a stored response, replayed with it. Null for responses that
+-- have none.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS etag text;
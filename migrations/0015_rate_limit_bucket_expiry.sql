@@ -0,0 +1,8 @@
+-- When each bucket is full again, a period after its last request. Buckets
+-- are kept until then rather than for a fixed time, which is too short for
+-- limits with longer periods: a bucket dropped early would come back full.
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS expires_at timestamptz NOT NULL DEFAULT now() + interval '1 hour';
+ALTER TABLE rate_limit_buckets ALTER COLUMN expires_at DROP DEFAULT;
+
+DROP INDEX IF EXISTS rate_limit_buckets_updated_at;
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_expires_at ON rate_limit_buckets (expires_at);
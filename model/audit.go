@@ -0,0 +1,149 @@
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Audit identifies who is making a change, for the audit log.
+type Audit struct {
+	Actor     string
+	RequestId string
+}
+
+// AuditEntry is one change to a user. Before is nil for creates and After is
+// nil for deletes; neither carries the password.
+type AuditEntry struct {
+	Id        int64
+	UserId    int
+	Actor     string
+	RequestId string
+	Operation string
+	Before    *User
+	After     *User
+	ChangedAt time.Time
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	Actor string
+	Since time.Time
+	Limit int
+}
+
+// userJson renders the user in table t the way the audit log stores it.
+func userJson(t string) string {
+	return "json_build_object('id', " + t + ".id, 'name', " + t + ".name, 'email', " + t + ".email)::jsonb"
+}
+
+// withChange wraps write, a statement returning "id, name, email" and
+// possibly more columns, which the wrapped statement returns too, so that
+// the same statement records the change in the outbox and the audit log,
+// which are then committed together with it. For updates and deletes,
+// idParam is the placeholder holding the user's id, used to read the user as
+// it was. The actor and request id are bound to placeholders auditParam and
+// auditParam+1.
+func withChange(eventType string, operation string, write string, idParam string, auditParam int) string {
+	before, after := "NULL::jsonb", userJson("u")
+	with, from := "", "u"
+	if idParam != "" {
+		with = "b AS (SELECT id, name, email FROM users WHERE id=" + idParam + "), "
+		before = userJson("b")
+		from = "u LEFT JOIN b ON b.id = u.id"
+	}
+	if operation == AuditDelete {
+		after = "NULL::jsonb"
+	}
+	actor, requestId := "$"+strconv.Itoa(auditParam), "$"+strconv.Itoa(auditParam+1)
+	return `WITH ` + with + `u AS (` + write + `), o AS (
+		INSERT INTO outbox (event_type, payload)
+		SELECT '` + eventType + `', json_build_object('id', id, 'name', name, 'email', email)::text FROM u), a AS (
+		INSERT INTO user_audit (user_id, actor, request_id, operation, before, after)
+		SELECT u.id, ` + actor + `, ` + requestId + `, '` + operation + `', ` + before + `, ` + after + ` FROM ` + from + `)
+		SELECT * FROM u`
+}
+
+const auditColumns = "id, user_id, actor, request_id, operation, before, after, changed_at"
+
+func scanAuditEntry(scan func(...interface{}) error) (*AuditEntry, error) {
+	entry := &AuditEntry{}
+	var before, after []byte
+	err := scan(&entry.Id, &entry.UserId, &entry.Actor, &entry.RequestId, &entry.Operation, &before, &after, &entry.ChangedAt)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Before, err = auditUser(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = auditUser(after); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func auditUser(data []byte) (*User, error) {
+	if data == nil {
+		return nil, nil
+	}
+	user := &User{}
+	if err := json.Unmarshal(data, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func queryAudit(db Querier, query string, args ...interface{}) ([]*AuditEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// UserHistory returns every change to a user, oldest first.
+func UserHistory(db Querier, userId int) ([]*AuditEntry, error) {
+	return queryAudit(db, "SELECT "+auditColumns+" FROM user_audit WHERE user_id=$1 ORDER BY id", userId)
+}
+
+// ListAudit returns the changes matching filter, oldest first.
+func ListAudit(db Querier, filter AuditFilter) ([]*AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		where = append(where, "actor=$"+strconv.Itoa(len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		where = append(where, "changed_at>=$"+strconv.Itoa(len(args)))
+	}
+	query := "SELECT " + auditColumns + " FROM user_audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return queryAudit(db, query, args...)
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var auditRowColumns = []string{"id", "user_id", "actor", "request_id", "operation", "before", "after", "changed_at"}
+
+func TestUserHistoryDecodesUsers(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	changedAt := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := mock.NewRows(auditRowColumns).
+		AddRow(1, 1, "admin", "req-1", AuditCreate, nil, []byte(`{"id": 1, "name": "Kaladin", "email": "k@s.com"}`), changedAt).
+		AddRow(2, 1, "admin", "req-2", AuditDelete, []byte(`{"id": 1, "name": "Kaladin", "email": "k@s.com"}`), nil, changedAt)
+	mock.ExpectQuery("SELECT (.+) FROM user_audit WHERE user_id=\\$1 ORDER BY id").WithArgs(1).WillReturnRows(rows)
+
+	entries, err := UserHistory(db, 1)
+
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Nil(t, entries[0].Before)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, entries[0].After)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, entries[1].Before)
+	require.Nil(t, entries[1].After)
+}
+
+func TestListAuditFilters(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	since := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM user_audit WHERE actor=\\$1 AND changed_at>=\\$2 ORDER BY id LIMIT \\$3").
+		WithArgs("admin", since, 10).WillReturnRows(mock.NewRows(auditRowColumns))
+
+	entries, err := ListAudit(db, AuditFilter{Actor: "admin", Since: since, Limit: 10})
+
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListAuditUnfiltered(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM user_audit ORDER BY id$").WithArgs().WillReturnRows(mock.NewRows(auditRowColumns))
+
+	_, err := ListAudit(db, AuditFilter{})
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInvalidCredentials is returned by Authenticate whether the email is
+// unknown or the password is wrong, so that callers cannot tell which.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// Operations recorded in the audit log when an account is locked after too
+// many failed logins, and unlocked again.
+const (
+	AuditLock   = "lock"
+	AuditUnlock = "unlock"
+)
+
+// Authenticate returns the user with the given email and password, and
+// whether the user has verified their email. Unknown emails take as long to
+// check as wrong passwords.
+func Authenticate(db Querier, email string, password string) (*User, bool, error) {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		checkNoPassword(password)
+		return nil, false, ErrInvalidCredentials
+	}
+	user := &User{}
+	var stored string
+	var verified bool
+	stmt, err := db.Prepare("SELECT id, name, email, password, email_verified_at IS NOT NULL FROM users WHERE lower(email)=lower($1)")
+	if err != nil {
+		return nil, false, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(normalized).Scan(&user.Id, &user.Name, &user.Email, &stored, &verified)
+	if err == sql.ErrNoRows {
+		checkNoPassword(password)
+		return nil, false, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !CheckPassword(stored, password) {
+		return nil, false, ErrInvalidCredentials
+	}
+	return user, verified, nil
+}
+
+// AccountLoginKey is the login_failures key of the account with email. Any
+// email has one, so that unknown emails are counted and locked like real
+// accounts. Emails match regardless of case, as they do at login, so the key
+// is lower-cased.
+func AccountLoginKey(email string) string {
+	return "email:" + strings.ToLower(loginEmail(email))
+}
+
+// loginEmail normalizes email if it is valid, so that every spelling of an
+// address is counted together.
+func loginEmail(email string) string {
+	if normalized, err := NormalizeEmail(email); err == nil {
+		return normalized
+	}
+	return strings.TrimSpace(email)
+}
+
+// LoginFailures are the failed logins counted against a key.
+type LoginFailures struct {
+	Count int
+	// Age is how long ago the last failure was.
+	Age time.Duration
+}
+
+// GetLoginFailures returns the failures counted against each of keys within
+// window. Keys without any are left out.
+func GetLoginFailures(db Querier, window time.Duration, keys ...string) (map[string]LoginFailures, error) {
+	rows, err := db.Query(`SELECT key, failures, EXTRACT(EPOCH FROM now() - last_failed_at)
+		FROM login_failures WHERE key = ANY($1) AND last_failed_at > now() - $2 * interval '1 millisecond'`,
+		pq.Array(keys), window.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := map[string]LoginFailures{}
+	for rows.Next() {
+		var key string
+		var count int
+		var age float64
+		if err := rows.Scan(&key, &count, &age); err != nil {
+			return nil, err
+		}
+		failures[key] = LoginFailures{Count: count, Age: time.Duration(age * float64(time.Second))}
+	}
+	return failures, rows.Err()
+}
+
+// RecordLoginFailure counts a failed login against key and returns the
+// failures within window, this one included.
+func RecordLoginFailure(db Querier, window time.Duration, key string) (int, error) {
+	var count int
+	stmt, err := db.Prepare(`INSERT INTO login_failures (key, failures, last_failed_at) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at > now() - $2 * interval '1 millisecond' THEN login_failures.failures + 1 ELSE 1 END,
+			last_failed_at = now()
+		RETURNING failures`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(key, window.Milliseconds()).Scan(&count)
+	return count, err
+}
+
+// ForgiveLoginFailure takes back one failure counted against key, for an
+// attempt counted before it was checked that turned out to succeed.
+func ForgiveLoginFailure(db Querier, key string) error {
+	stmt, err := db.Prepare("UPDATE login_failures SET failures = failures - 1 WHERE key=$1 AND failures > 0")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(key)
+	return err
+}
+
+// ClearLoginFailures forgets the failures counted against key.
+func ClearLoginFailures(db Querier, key string) error {
+	stmt, err := db.Prepare("DELETE FROM login_failures WHERE key=$1")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(key)
+	return err
+}
+
+// AuditLockout records in the audit log that the account with email was
+// locked. It records nothing if no user has the email.
+func AuditLockout(db Querier, audit Audit, email string) error {
+	stmt, err := db.Prepare(`INSERT INTO user_audit (user_id, actor, request_id, operation)
+		SELECT id, $2, $3, '` + AuditLock + `' FROM users WHERE lower(email)=lower($1)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(loginEmail(email), audit.Actor, audit.RequestId)
+	return err
+}
+
+// UnlockUser forgets the failed logins against a user's account, lifting any
+// lock, and records that in the audit log.
+func UnlockUser(db Querier, audit Audit, id int) (*User, error) {
+	user := &User{}
+	stmt, err := db.Prepare(`WITH u AS (SELECT id, name, email FROM users WHERE id=$1), d AS (
+		DELETE FROM login_failures WHERE key IN (SELECT 'email:' || lower(email) FROM u)), a AS (
+		INSERT INTO user_audit (user_id, actor, request_id, operation)
+		SELECT id, $2, $3, '` + AuditUnlock + `' FROM u)
+		SELECT id, name, email FROM u`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(id, audit.Actor, audit.RequestId).Scan(&user.Id, &user.Name, &user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticate(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT id, name, email, password, email_verified_at IS NOT NULL FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)")
+	rows := mock.NewRows([]string{"id", "name", "email", "password", "verified"}).AddRow(1, "Kaladin", "k@s.com", storedPassword("password"), true)
+	mock.ExpectQuery("SELECT").WithArgs("k@s.com").WillReturnRows(rows)
+
+	user, verified, err := Authenticate(db, " k@S.com", "password")
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+	require.True(t, verified)
+}
+
+func TestAuthenticateWrongPasswordAndUnknownEmail(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "name", "email", "password", "verified"}).AddRow(1, "Kaladin", "k@s.com", storedPassword("password"), true)
+	mock.ExpectQuery("SELECT").WithArgs("k@s.com").WillReturnRows(rows)
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs("nobody@s.com").WillReturnError(sql.ErrNoRows)
+
+	_, _, err := Authenticate(db, "k@s.com", "wrong-password")
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, _, err = Authenticate(db, "nobody@s.com", "password")
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, _, err = Authenticate(db, "not an email", "password")
+	require.Equal(t, ErrInvalidCredentials, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginFailures(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"key", "failures", "age"}).AddRow("email:k@s.com", 3, 1.5)
+	mock.ExpectQuery("SELECT key, failures, .+ FROM login_failures WHERE key = ANY\\(\\$1\\)").
+		WithArgs(sqlmock.AnyArg(), int64(900000)).WillReturnRows(rows)
+	mock.ExpectPrepare("INSERT INTO login_failures .+ ON CONFLICT \\(key\\) DO UPDATE")
+	mock.ExpectQuery("INSERT").WithArgs("ip:192.0.2.1", int64(900000)).WillReturnRows(mock.NewRows([]string{"failures"}).AddRow(1))
+
+	failures, err := GetLoginFailures(db, 15*time.Minute, AccountLoginKey("k@S.com"), "ip:192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]LoginFailures{"email:k@s.com": {Count: 3, Age: 1500 * time.Millisecond}}, failures)
+
+	count, err := RecordLoginFailure(db, 15*time.Minute, "ip:192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, "email:k@s.com", AccountLoginKey(" K@s.com"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForgiveLoginFailure(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE login_failures SET failures = failures - 1 WHERE key=\\$1 AND failures > 0")
+	mock.ExpectExec("UPDATE").WithArgs("ip:192.0.2.1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, ForgiveLoginFailure(db, "ip:192.0.2.1"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditLockout(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO user_audit \\(user_id, actor, request_id, operation\\) SELECT id, \\$2, \\$3, 'lock' FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)")
+	mock.ExpectExec("INSERT").WithArgs("k@s.com", "admin", "req-1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, AuditLockout(db, testAudit, "k@S.com"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnlockUser(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("DELETE FROM login_failures .+ lower\\(email\\) .+ INSERT INTO user_audit .+ 'unlock'")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(1, "admin", "req-1").WillReturnRows(rows)
+
+	user, err := UnlockUser(db, testAudit, 1)
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -4,15 +4,60 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/mail"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/tammiec/go-rest-api/validation"
 )
 
+// ErrEmailTaken is returned when a write would give two users the same email.
+var ErrEmailTaken = errors.New("email already in use")
+
+// ErrVersionConflict is returned by conditional writes when the user has
+// changed since the version the caller expected.
+var ErrVersionConflict = errors.New("user has changed since the expected version")
+
+// ErrInvalidEmail is returned by NormalizeEmail for malformed addresses.
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// Event types that user writes record in the outbox.
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// StripPlusTags drops the "+tag" part of an email's local part during
+// normalization, so that "kal+work@s.com" and "kal@s.com" collide.
+var StripPlusTags = false
+
+const (
+	nameMaxLength     = 100
+	passwordMinLength = 8
+	passwordMaxBytes  = 72 // as much of a password as bcrypt uses
+	nameForbidden     = "<>\"{}"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so the user functions can
+// run on their own or as part of a larger transaction.
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Prepare(query string) (*sql.Stmt, error)
+}
+
 type User struct {
 	Id    int
 	Name  string
 	Email string
+	// Version is the user's users.version, where the query read it, and 0
+	// otherwise.
+	Version int
 }
 
 func GetDb(dbUrl string) *sql.DB {
@@ -24,82 +69,446 @@ func GetDb(dbUrl string) *sql.DB {
 	return db
 }
 
-func GetUsers(db *sql.DB) ([]*User, error) {
-	rows, err := db.Query("SELECT id, name, email FROM users")
+func GetUsers(db Querier) ([]*User, error) {
+	users := make([]*User, 0)
+	err := EachUser(db, func(user *User) error {
+		users = append(users, user)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	if len(users) < 1 {
+		return nil, errors.New("no users found")
+	}
+
+	return users, err
+}
+
+// EachUser calls fn for every user, in id order, as rows are read from the
+// cursor, so callers can stream the table without holding it in memory. It
+// stops at the first error from fn.
+func EachUser(db Querier, fn func(*User) error) error {
+	rows, err := db.Query("SELECT id, name, email FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	users := make([]*User, 0)
 	for rows.Next() {
 		user := &User{}
 		err := rows.Scan(&user.Id, &user.Name, &user.Email)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
 		}
-		users = append(users, user)
 	}
+	return rows.Err()
+}
 
-	if len(users) < 1 {
-		return nil, errors.New("no users found")
+// UserFilter selects users for ListUsers. Zero fields match everything.
+type UserFilter struct {
+	// After skips the users up to and including this id.
+	After int
+	// Email and NameContains match regardless of case.
+	Email        string
+	NameContains string
+	Limit        int
+}
+
+// ListUsers returns the users matching filter, in id order.
+func ListUsers(db Querier, filter UserFilter) ([]*User, error) {
+	var where []string
+	var args []interface{}
+	if filter.After > 0 {
+		args = append(args, filter.After)
+		where = append(where, "id>$"+strconv.Itoa(len(args)))
+	}
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		where = append(where, "lower(email)=lower($"+strconv.Itoa(len(args))+")")
+	}
+	if filter.NameContains != "" {
+		args = append(args, filter.NameContains)
+		where = append(where, "strpos(lower(name), lower($"+strconv.Itoa(len(args))+"))>0")
+	}
+	query := "SELECT id, name, email FROM users"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
 	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return users, err
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := &User{}
+		if err := rows.Scan(&user.Id, &user.Name, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
 }
 
-func GetUser(db *sql.DB, id int) (*User, error) {
+func GetUser(db Querier, id int) (*User, error) {
 	user := &User{}
-	stmt, err := db.Prepare("SELECT id, name, email FROM users WHERE id=$1")
+	stmt, err := db.Prepare("SELECT id, name, email, version FROM users WHERE id=$1")
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&user.Id, &user.Name, &user.Email)
+	err = stmt.QueryRow(id).Scan(&user.Id, &user.Name, &user.Email, &user.Version)
 	if err != nil {
 		return nil, err
 	}
 	return user, err
 }
 
-func DeleteUser(db *sql.DB, id int) (*User, error) {
+func DeleteUser(db Querier, audit Audit, id int) (*User, error) {
 	user := &User{}
-	stmt, err := db.Prepare("DELETE FROM users WHERE id=$1 RETURNING id, name, email")
+	stmt, err := db.Prepare(withChange(EventUserDeleted, AuditDelete, "DELETE FROM users WHERE id=$1 RETURNING id, name, email", "$1", 2))
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&user.Id, &user.Name, &user.Email)
+	err = stmt.QueryRow(id, audit.Actor, audit.RequestId).Scan(&user.Id, &user.Name, &user.Email)
 	if err != nil {
 		return nil, err
 	}
 	return user, err
 }
 
-func CreateUser(db *sql.DB, name string, email string, password string) (*User, error) {
+// NormalizeEmail checks that email is a bare RFC 5322 address and returns it
+// trimmed, with its domain lowercased.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if !strings.Contains(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+	if StripPlusTags {
+		if plus := strings.Index(local, "+"); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	return local + "@" + domain, nil
+}
+
+// ValidateUser checks the fields of a user write, reporting every failed rule
+// as validation.Errors, and returns the normalized email.
+func ValidateUser(name string, email string, password string) (string, error) {
+	v := validation.New()
+	normalized := validateProfile(v, name, email)
+	validatePassword(v, password)
+	return normalized, v.Err()
+}
+
+// validatePassword adds the rules for a user's password to v.
+func validatePassword(v *validation.Validator, password string) {
+	v.String("password", password).Required().MinLength(passwordMinLength).MaxBytes(passwordMaxBytes).NoControl()
+}
+
+// validateProfile adds the rules for a user's name and email to v and
+// returns the normalized email.
+func validateProfile(v *validation.Validator, name string, email string) string {
+	v.String("name", name).Required().MaxLength(nameMaxLength).NoControl().Forbid(nameForbidden)
+	normalized, emailErr := NormalizeEmail(email)
+	v.String("email", email).Required().Check(emailErr == nil, "email")
+	return normalized
+}
+
+// emailIndex is the unique index that keeps emails apart, added by
+// migrations/0001_users_email_unique.sql.
+const emailIndex = "users_email_lower_key"
+
+// translateError maps driver errors onto the errors this package exposes.
+// Only violations of emailIndex mean the email is taken; other unique
+// violations are left as they are.
+func translateError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == emailIndex {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func CreateUser(db Querier, audit Audit, name string, email string, password string) (*User, error) {
+	email, err := ValidateUser(name, email, password)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
 	user := &User{}
-	stmt, err := db.Prepare("INSERT INTO users (name, email, password) VALUES ($1, $2, $3) RETURNING id, name, email")
+	stmt, err := db.Prepare(withChange(EventUserCreated, AuditCreate, "INSERT INTO users (name, email, password) VALUES ($1, $2, $3) RETURNING id, name, email, version", "", 4))
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(name, email, password).Scan(&user.Id, &user.Name, &user.Email)
+	err = stmt.QueryRow(name, email, hash, audit.Actor, audit.RequestId).Scan(&user.Id, &user.Name, &user.Email, &user.Version)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return user, err
 }
 
-func UpdateUser(db *sql.DB, id int, name string, email string, password string) (*User, error) {
+func UpdateUser(db Querier, audit Audit, id int, name string, email string, password string) (*User, error) {
+	email, err := ValidateUser(name, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return updateUser(db, audit, id, 0, name, email, &password)
+}
+
+// UpdateUserIfVersion is UpdateUser for a client that last saw the given
+// version of the user. It fails with ErrVersionConflict if the user has
+// changed since.
+func UpdateUserIfVersion(db Querier, audit Audit, id int, version int, name string, email string, password string) (*User, error) {
+	email, err := ValidateUser(name, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return updateUser(db, audit, id, version, name, email, &password)
+}
+
+// updateUser writes validated fields, leaving the password alone when it is
+// nil. A non-zero version makes the write conditional on the user still
+// being at that version.
+func updateUser(db Querier, audit Audit, id int, version int, name string, email string, password *string) (*User, error) {
+	set := "name=$1, email=$2"
+	args := []interface{}{name, email}
+	if password != nil {
+		hash, err := HashPassword(*password)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, hash)
+		set += ", password=$3"
+	}
+	args = append(args, id)
+	idParam := "$" + strconv.Itoa(len(args))
+	where := "id=" + idParam
+	if version != 0 {
+		args = append(args, version)
+		where += " AND version=$" + strconv.Itoa(len(args))
+	}
+	write := "UPDATE users SET " + set + " WHERE " + where + " RETURNING id, name, email, version"
+
 	user := &User{}
-	stmt, err := db.Prepare("UPDATE users SET name=$1, email=$2, password=$3 WHERE id=$4 RETURNING id, name, email")
+	stmt, err := db.Prepare(withChange(EventUserUpdated, AuditUpdate, write, idParam, len(args)+1))
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(name, email, password, id).Scan(&user.Id, &user.Name, &user.Email)
+	err = stmt.QueryRow(append(args, audit.Actor, audit.RequestId)...).Scan(&user.Id, &user.Name, &user.Email, &user.Version)
+	if err == sql.ErrNoRows && version != 0 {
+		// Tell a user that was changed apart from one that is gone.
+		if _, getErr := GetUser(db, id); getErr == nil {
+			return nil, ErrVersionConflict
+		}
+	}
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return user, err
 }
+
+// UserCopier streams new users into the users table with Postgres COPY. Rows
+// are buffered by the driver and only written when Close is called, which
+// copies them into a temporary table and from there into users with a single
+// statement that, like CreateUser's, records each creation in the outbox and
+// the audit log. Rows whose email is already taken are left out.
+//
+// Passwords are hashed as they are added, by one worker per CPU, and rows
+// are copied in the order they were added. Add blocks while the workers are
+// behind, so at most a few rows wait in memory.
+type UserCopier struct {
+	tx    *sql.Tx
+	stmt  *sql.Stmt
+	audit Audit
+	// lines holds the line of each lower-cased email added.
+	lines   map[string]int
+	hashing chan *copyRow
+	writing chan *copyRow
+	done    chan struct{}
+	stop    sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+type copyRow struct {
+	line     int
+	name     string
+	email    string
+	password string
+	hashed   chan error
+}
+
+func NewUserCopier(tx *sql.Tx, audit Audit) (*UserCopier, error) {
+	_, err := tx.Exec("CREATE TEMPORARY TABLE user_import (line bigint, name text, email text, password text) ON COMMIT DROP")
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(pq.CopyIn("user_import", "line", "name", "email", "password"))
+	if err != nil {
+		return nil, err
+	}
+	workers := runtime.GOMAXPROCS(0)
+	c := &UserCopier{
+		tx:      tx,
+		stmt:    stmt,
+		audit:   audit,
+		lines:   make(map[string]int),
+		hashing: make(chan *copyRow, workers),
+		writing: make(chan *copyRow, 2*workers),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go c.hash()
+	}
+	go c.write()
+	return c, nil
+}
+
+func (c *UserCopier) hash() {
+	for row := range c.hashing {
+		if c.failed() != nil {
+			row.hashed <- nil
+			continue
+		}
+		hash, err := HashPassword(row.password)
+		row.password = hash
+		row.hashed <- err
+	}
+}
+
+func (c *UserCopier) write() {
+	defer close(c.done)
+	for row := range c.writing {
+		err := <-row.hashed
+		if c.failed() != nil {
+			continue
+		}
+		if err == nil {
+			_, err = c.stmt.Exec(row.line, row.name, row.email, row.password)
+		}
+		if err != nil {
+			c.fail(err)
+		}
+	}
+}
+
+func (c *UserCopier) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *UserCopier) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Add queues a user read from the given line of the input. It returns the
+// error of an earlier row that could not be copied, if any.
+func (c *UserCopier) Add(line int, name string, email string, password string) error {
+	if err := c.failed(); err != nil {
+		return err
+	}
+	c.lines[strings.ToLower(email)] = line
+	row := &copyRow{line: line, name: name, email: email, password: password, hashed: make(chan error, 1)}
+	c.hashing <- row
+	c.writing <- row
+	return nil
+}
+
+// Abort stops the workers without copying anything, for a caller that rolls
+// back instead of calling Close. It does nothing after Close.
+func (c *UserCopier) Abort() {
+	c.drain()
+}
+
+// drain waits for the rows added to be hashed and copied.
+func (c *UserCopier) drain() {
+	c.stop.Do(func() {
+		close(c.hashing)
+		close(c.writing)
+		<-c.done
+	})
+}
+
+// Close copies the users added into the table, and returns the lines of
+// those whose email was already taken, in order.
+func (c *UserCopier) Close() ([]int, error) {
+	c.drain()
+	if err := c.failed(); err != nil {
+		c.stmt.Close()
+		return nil, err
+	}
+	if _, err := c.stmt.Exec(); err != nil {
+		c.stmt.Close()
+		return nil, err
+	}
+	if err := c.stmt.Close(); err != nil {
+		return nil, err
+	}
+	insert := "INSERT INTO users (name, email, password) SELECT name, email, password FROM user_import ORDER BY line ON CONFLICT DO NOTHING RETURNING id, name, email"
+	rows, err := c.tx.Query(withChange(EventUserCreated, AuditCreate, insert, "", 1), c.audit.Actor, c.audit.RequestId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user := &User{}
+		if err := rows.Scan(&user.Id, &user.Name, &user.Email); err != nil {
+			return nil, err
+		}
+		delete(c.lines, strings.ToLower(user.Email))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	taken := make([]int, 0, len(c.lines))
+	for _, line := range c.lines {
+		taken = append(taken, line)
+	}
+	sort.Ints(taken)
+	return taken, nil
+}
+
+// TakenEmails returns which of emails, lower-cased, already belong to a user.
+func TakenEmails(db Querier, emails []string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT lower(email) FROM users WHERE lower(email) = ANY($1)", pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		taken[email] = true
+	}
+	return taken, rows.Err()
+}
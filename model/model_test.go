@@ -2,13 +2,18 @@ package model
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/validation"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
@@ -19,6 +24,29 @@ func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
 	return db, mock
 }
 
+var testAudit = Audit{Actor: "admin", RequestId: "req-1"}
+
+func init() {
+	PasswordHashCost = bcrypt.MinCost
+}
+
+// hashOf matches a query argument holding the stored hash of a password.
+type hashOf string
+
+func (h hashOf) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && CheckPassword(hash, string(h))
+}
+
+// storedPassword returns what the users table holds for password.
+func storedPassword(password string) string {
+	hash, err := HashPassword(password)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
 func TestGetDb(t *testing.T) {
 	url, _ := os.LookupEnv("DATABASE_URL")
 	db := GetDb(url)
@@ -75,8 +103,8 @@ func TestGetUserSuccessfully(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("SELECT")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow("1", "Kaladin", "k@s.com")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
 	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
 
 	result, err := GetUser(db, 1)
@@ -105,8 +133,8 @@ func TestGetUserQueryBadRow(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("SELECT")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow("1", "Kaladin", nil)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", nil, 1)
 	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
 
 	_, err := GetUser(db, 1)
@@ -122,9 +150,9 @@ func TestDeleteUserSuccessfully(t *testing.T) {
 	mock.ExpectPrepare("DELETE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
 	rows.AddRow("1", "Kaladin", "k@s.com")
-	mock.ExpectQuery("DELETE").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("DELETE").WithArgs(1, "admin", "req-1").WillReturnRows(rows)
 
-	result, err := DeleteUser(db, 1)
+	result, err := DeleteUser(db, testAudit, 1)
 
 	require.NoError(t, err)
 	require.Equal(t, 1, result.Id)
@@ -139,9 +167,9 @@ func TestDeleteUserQueryInvalidUser(t *testing.T) {
 	mock.ExpectPrepare("DELETE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
 	rows.AddRow("1", "Kaladin", "k@s.com")
-	mock.ExpectQuery("DELETE").WithArgs(2).WillReturnError(errors.New("sql: no rows in result set"))
+	mock.ExpectQuery("DELETE").WithArgs(2, "admin", "req-1").WillReturnError(errors.New("sql: no rows in result set"))
 
-	_, err := DeleteUser(db, 2)
+	_, err := DeleteUser(db, testAudit, 2)
 
 	require.Error(t, err)
 	require.Equal(t, "sql: no rows in result set", err.Error())
@@ -152,33 +180,65 @@ func TestCreateUserSuccessfully(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectPrepare("INSERT")
-	rows := mock.NewRows([]string{"name", "email", "password"})
-	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", "password").WillReturnRows(rows)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "admin", "req-1").WillReturnRows(rows)
 
-	result, err := CreateUser(db, "Kaladin", "k@s.com", "password")
+	result, err := CreateUser(db, testAudit, "Kaladin", "k@s.com", "password")
 
 	require.NoError(t, err)
 	require.Equal(t, "Kaladin", result.Name)
 	require.Equal(t, "k@s.com", result.Email)
 }
 
+func TestCreateUserRecordsOutboxEventAndAudit(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare(`WITH u AS \(INSERT INTO users .+\), o AS \(\s*INSERT INTO outbox \(event_type, payload\)\s*SELECT 'user.created'.+` +
+		`INSERT INTO user_audit .+ SELECT u.id, \$4, \$5, 'create', NULL::jsonb, json_build_object\('id', u.id`)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "admin", "req-1").WillReturnRows(rows)
+
+	_, err := CreateUser(db, testAudit, "Kaladin", "k@s.com", "password")
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateUserSuccessfully(t *testing.T) {
 	db, mock := getMockDB()
 	defer db.Close()
 
 	mock.ExpectPrepare("UPDATE")
-	rows := mock.NewRows([]string{"id", "name", "email"})
-	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", "password", 1).WillReturnRows(rows)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 2)
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "admin", "req-1").WillReturnRows(rows)
 
-	result, err := UpdateUser(db, 1, "Kaladin", "k@s.com", "password")
+	result, err := UpdateUser(db, testAudit, 1, "Kaladin", "k@s.com", "password")
 
 	require.NoError(t, err)
 	require.Equal(t, "Kaladin", result.Name)
 	require.Equal(t, "k@s.com", result.Email)
 }
 
+func TestUpdateUserAuditsBefore(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare(`WITH b AS \(SELECT id, name, email FROM users WHERE id=\$4\), u AS \(UPDATE users .+` +
+		`SELECT u.id, \$5, \$6, 'update', json_build_object\('id', b.id.+ FROM u LEFT JOIN b ON b.id = u.id`)
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 2)
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "admin", "req-1").WillReturnRows(rows)
+
+	_, err := UpdateUser(db, testAudit, 1, "Kaladin", "k@s.com", "password")
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateUserInvalidUser(t *testing.T) {
 	db, mock := getMockDB()
 	defer db.Close()
@@ -186,10 +246,138 @@ func TestUpdateUserInvalidUser(t *testing.T) {
 	mock.ExpectPrepare("UPDATE")
 	rows := mock.NewRows([]string{"id", "name", "email"})
 	rows.AddRow(1, "Kaladin", "k@s.com")
-	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", "password", 2).WillReturnError(errors.New("sql: no rows in result set"))
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 2, "admin", "req-1").WillReturnError(errors.New("sql: no rows in result set"))
 
-	_, err := UpdateUser(db, 2, "Kaladin", "k@s.com", "password")
+	_, err := UpdateUser(db, testAudit, 2, "Kaladin", "k@s.com", "password")
 
 	require.Error(t, err)
 	require.Equal(t, "sql: no rows in result set", err.Error())
 }
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := NormalizeEmail("  Kal+work@S.COM ")
+	require.NoError(t, err)
+	require.Equal(t, "Kal+work@s.com", email)
+}
+
+func TestNormalizeEmailStripPlusTags(t *testing.T) {
+	StripPlusTags = true
+	defer func() { StripPlusTags = false }()
+
+	email, err := NormalizeEmail("kal+work@s.com")
+	require.NoError(t, err)
+	require.Equal(t, "kal@s.com", email)
+}
+
+func TestNormalizeEmailInvalid(t *testing.T) {
+	for _, email := range []string{"", "1", "kal", "kal@", "kal@localhost", "Kal <k@s.com>"} {
+		_, err := NormalizeEmail(email)
+		require.Equal(t, ErrInvalidEmail, err, email)
+	}
+}
+
+func TestCreateUserInvalidEmail(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	_, err := CreateUser(db, testAudit, "Kaladin", "1", "password")
+
+	require.Error(t, err)
+	require.Equal(t, "email: must be a valid email address", err.Error())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserEmailTaken(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "admin", "req-1").WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_lower_key"})
+
+	_, err := CreateUser(db, testAudit, "Kaladin", "k@s.com", "password")
+
+	require.Equal(t, ErrEmailTaken, err)
+}
+
+func TestCreateUserOtherUniqueViolation(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	violation := &pq.Error{Code: "23505", Constraint: "users_pkey"}
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WillReturnError(violation)
+
+	_, err := CreateUser(db, testAudit, "Kaladin", "k@s.com", "password")
+
+	require.Equal(t, violation, err)
+}
+
+func TestUpdateUserInvalidEmail(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	_, err := UpdateUser(db, testAudit, 1, "Kaladin", "k@", "password")
+
+	require.Error(t, err)
+	require.Equal(t, "email: must be a valid email address", err.Error())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateUserReportsAllFields(t *testing.T) {
+	_, err := ValidateUser("", "kal", "short")
+
+	require.Error(t, err)
+	errs := err.(validation.Errors)
+	require.Len(t, errs, 3)
+	require.Equal(t, "name: is required; email: must be a valid email address; password: must be at least 8 characters", err.Error())
+}
+
+func TestValidateUserForbiddenCharacters(t *testing.T) {
+	_, err := ValidateUser("<Kaladin>", "k@s.com", "password")
+
+	require.Error(t, err)
+	require.Equal(t, "name: must not contain \"<\"", err.Error())
+}
+
+func TestValidateUserNameTooLong(t *testing.T) {
+	_, err := ValidateUser(strings.Repeat("k", 101), "k@s.com", "password")
+
+	require.Error(t, err)
+	require.Equal(t, "name: must be at most 100 characters", err.Error())
+}
+
+func TestValidateUserPasswordTooLong(t *testing.T) {
+	// 40 characters, but 80 bytes, of which bcrypt would ignore the last 8.
+	_, err := ValidateUser("Kaladin", "k@s.com", strings.Repeat("é", 40))
+
+	require.Error(t, err)
+	require.Equal(t, "password: must be at most 72 bytes long", err.Error())
+}
+
+func TestListUsersFilters(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(2, "Adolin", "a@k.com")
+	mock.ExpectQuery("SELECT id, name, email FROM users WHERE id>\\$1 AND lower\\(email\\)=lower\\(\\$2\\) AND strpos\\(lower\\(name\\), lower\\(\\$3\\)\\)>0 ORDER BY id LIMIT \\$4").
+		WithArgs(1, "A@k.com", "dol", 21).WillReturnRows(rows)
+
+	users, err := ListUsers(db, UserFilter{After: 1, Email: "A@k.com", NameContains: "dol", Limit: 21})
+
+	require.NoError(t, err)
+	require.Equal(t, []*User{{Id: 2, Name: "Adolin", Email: "a@k.com"}}, users)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsersUnfiltered(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, email FROM users ORDER BY id$").WithArgs().WillReturnRows(mock.NewRows([]string{"id", "name", "email"}))
+
+	users, err := ListUsers(db, UserFilter{})
+
+	require.NoError(t, err)
+	require.Empty(t, users)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHashCost is the bcrypt cost of stored passwords. Tests lower it to
+// keep the suite fast.
+var PasswordHashCost = bcrypt.DefaultCost
+
+// HashPassword returns what is stored of a password: its bcrypt hash, salted
+// so that equal passwords are stored differently.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+	return string(hash), err
+}
+
+// CheckPassword tells whether password is the one hash was made from.
+func CheckPassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkNoPassword takes as long as CheckPassword and fails, so that checking
+// the password of a user who does not exist is not quicker than checking a
+// wrong one.
+func checkNoPassword(password string) bool {
+	dummyHashOnce.Do(func() {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), PasswordHashCost)
+		dummyHash = string(hash)
+	})
+	CheckPassword(dummyHash, password)
+	return false
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("password")
+	require.NoError(t, err)
+	again, err := HashPassword("password")
+	require.NoError(t, err)
+
+	require.NotEqual(t, "password", hash)
+	require.NotEqual(t, hash, again)
+	require.True(t, CheckPassword(hash, "password"))
+	require.False(t, CheckPassword(hash, "Password"))
+	require.False(t, CheckPassword("password", "password"))
+	require.False(t, checkNoPassword("not a password"))
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+// ErrInvalidToken is returned for a token that is unknown, used or expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// AuditPasswordReset is the operation recorded in the audit log when a user
+// resets their password.
+const AuditPasswordReset = "password_reset"
+
+// HashToken returns what is stored of a token sent to a user.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePasswordReset stores token as a way for the user with email to reset
+// their password until ttl has passed, and returns the user. It fails with
+// sql.ErrNoRows if no user has the email.
+func CreatePasswordReset(db Querier, email string, token string, ttl time.Duration) (*User, error) {
+	user := &User{}
+	stmt, err := db.Prepare(`WITH u AS (SELECT id, name, email FROM users WHERE lower(email)=lower($1)), t AS (
+		INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+		SELECT $2, id, now() + $3 * interval '1 millisecond' FROM u)
+		SELECT id, name, email FROM u`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(loginEmail(email), HashToken(token), ttl.Milliseconds()).Scan(&user.Id, &user.Name, &user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
+
+// ConsumePasswordReset uses up token, along with every other token of its
+// user, and returns the user's id.
+func ConsumePasswordReset(db Querier, token string) (int, error) {
+	var id int
+	stmt, err := db.Prepare(`WITH t AS (
+		UPDATE password_reset_tokens SET used_at = now()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > now() RETURNING user_id), o AS (
+		UPDATE password_reset_tokens SET used_at = now()
+		WHERE user_id IN (SELECT user_id FROM t) AND used_at IS NULL AND token_hash <> $1)
+		SELECT user_id FROM t`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(HashToken(token)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	return id, err
+}
+
+// SetPassword gives a user a new password, recording a password reset in
+// the audit log.
+func SetPassword(db Querier, audit Audit, id int, password string) (*User, error) {
+	v := validation.New()
+	validatePassword(v, password)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{}
+	stmt, err := db.Prepare(withChange(EventUserUpdated, AuditPasswordReset, "UPDATE users SET password=$1 WHERE id=$2 RETURNING id, name, email", "$2", 3))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(hash, id, audit.Actor, audit.RequestId).Scan(&user.Id, &user.Name, &user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+func TestCreatePasswordResetStoresHash(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO password_reset_tokens \\(token_hash, user_id, expires_at\\)")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs("k@s.com", HashToken("secret"), int64(3600000)).WillReturnRows(rows)
+
+	user, err := CreatePasswordReset(db, "k@S.com", "secret", time.Hour)
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+	require.NotEqual(t, "secret", HashToken("secret"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConsumePasswordReset(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE password_reset_tokens SET used_at = now\\(\\) WHERE token_hash=\\$1 AND used_at IS NULL AND expires_at > now\\(\\)")
+	mock.ExpectQuery("WITH").WithArgs(HashToken("secret")).WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectPrepare("UPDATE password_reset_tokens")
+	mock.ExpectQuery("WITH").WithArgs(HashToken("used")).WillReturnError(sql.ErrNoRows)
+
+	id, err := ConsumePasswordReset(db, "secret")
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+	_, err = ConsumePasswordReset(db, "used")
+	require.Equal(t, ErrInvalidToken, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetPassword(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE users SET password=\\$1 WHERE id=\\$2 .+ 'password_reset'")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(hashOf("new-password"), 1, "admin", "req-1").WillReturnRows(rows)
+
+	user, err := SetPassword(db, testAudit, 1, "new-password")
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+
+	_, err = SetPassword(db, testAudit, 1, "short")
+	fieldErrs, ok := err.(validation.Errors)
+	require.True(t, ok)
+	require.Equal(t, "password", fieldErrs[0].Field)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
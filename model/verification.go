@@ -0,0 +1,108 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AuditVerifyEmail is the operation recorded in the audit log when a user
+// verifies their email.
+const AuditVerifyEmail = "verify_email"
+
+// CreateEmailVerification stores token as a way for a user to verify email
+// until ttl has passed, and returns the user. It fails with sql.ErrNoRows,
+// storing nothing, unless email is still the user's unverified email and no
+// token for it is outstanding, so that repeated calls send one token.
+func CreateEmailVerification(db Querier, id int, email string, token string, ttl time.Duration) (*User, error) {
+	user := &User{}
+	stmt, err := db.Prepare(`WITH u AS (
+		SELECT id, name, email FROM users
+		WHERE id=$1 AND email=$2 AND email_verified_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM email_verification_tokens v
+			WHERE v.user_id=$1 AND v.email=$2 AND v.used_at IS NULL AND v.expires_at > now())), t AS (
+		INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at)
+		SELECT $3, id, email, now() + $4 * interval '1 millisecond' FROM u)
+		SELECT id, name, email FROM u`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(id, email, HashToken(token), ttl.Milliseconds()).Scan(&user.Id, &user.Name, &user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
+
+// ResendEmailVerification stores token as another way for the user with
+// email to verify it until ttl has passed, whether or not earlier tokens are
+// outstanding, and returns the user. It fails with sql.ErrNoRows, storing
+// nothing, if no user has the email or it is already verified.
+func ResendEmailVerification(db Querier, email string, token string, ttl time.Duration) (*User, error) {
+	user := &User{}
+	stmt, err := db.Prepare(`WITH u AS (
+		SELECT id, name, email FROM users WHERE lower(email)=lower($1) AND email_verified_at IS NULL), t AS (
+		INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at)
+		SELECT $2, id, email, now() + $3 * interval '1 millisecond' FROM u)
+		SELECT id, name, email FROM u`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(loginEmail(email), HashToken(token), ttl.Milliseconds()).Scan(&user.Id, &user.Name, &user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
+
+// VerifyEmail uses up token and marks the email it was sent to as verified,
+// recording that in the audit log. It fails with ErrInvalidToken if the
+// token is unknown, used or expired, or if the user's email has changed
+// since it was sent. The caller should run it in a transaction, so that a
+// token is only used up if the email is verified.
+func VerifyEmail(db Querier, audit Audit, token string) (*User, error) {
+	var id int
+	var email string
+	stmt, err := db.Prepare(`UPDATE email_verification_tokens SET used_at = now()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > now() RETURNING user_id, email`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(HashToken(token)).Scan(&id, &email)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{}
+	verify, err := db.Prepare(withChange(EventUserUpdated, AuditVerifyEmail, "UPDATE users SET email_verified_at = now() WHERE id=$1 AND email=$2 RETURNING id, name, email", "$1", 3))
+	if err != nil {
+		return nil, err
+	}
+	defer verify.Close()
+	err = verify.QueryRow(id, email, audit.Actor, audit.RequestId).Scan(&user.Id, &user.Name, &user.Email)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, err
+}
+
+// EmailVerified tells whether the user with email has verified it. It fails
+// with sql.ErrNoRows if no user has the email.
+func EmailVerified(db Querier, email string) (bool, error) {
+	var verified bool
+	stmt, err := db.Prepare("SELECT email_verified_at IS NOT NULL FROM users WHERE lower(email)=lower($1)")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(email).Scan(&verified)
+	return verified, err
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateEmailVerification(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("WHERE id=\\$1 AND email=\\$2 AND email_verified_at IS NULL AND NOT EXISTS .+ INSERT INTO email_verification_tokens")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(1, "k@s.com", HashToken("secret"), int64(172800000)).WillReturnRows(rows)
+	mock.ExpectPrepare("WITH")
+	mock.ExpectQuery("WITH").WithArgs(1, "k@s.com", HashToken("again"), int64(172800000)).WillReturnError(sql.ErrNoRows)
+
+	user, err := CreateEmailVerification(db, 1, "k@s.com", "secret", 48*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+	_, err = CreateEmailVerification(db, 1, "k@s.com", "again", 48*time.Hour)
+	require.Equal(t, sql.ErrNoRows, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE email_verification_tokens SET used_at = now\\(\\)")
+	mock.ExpectQuery("UPDATE").WithArgs(HashToken("secret")).WillReturnRows(mock.NewRows([]string{"user_id", "email"}).AddRow(1, "k@s.com"))
+	mock.ExpectPrepare("UPDATE users SET email_verified_at = now\\(\\) WHERE id=\\$1 AND email=\\$2 .+ 'verify_email'")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(1, "k@s.com", "admin", "req-1").WillReturnRows(rows)
+
+	user, err := VerifyEmail(db, testAudit, "secret")
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kaladin", Email: "k@s.com"}, user)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmailAfterEmailChanged(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE email_verification_tokens")
+	mock.ExpectQuery("UPDATE").WillReturnRows(mock.NewRows([]string{"user_id", "email"}).AddRow(1, "old@s.com"))
+	mock.ExpectPrepare("UPDATE users SET email_verified_at")
+	mock.ExpectQuery("WITH").WithArgs(1, "old@s.com", "admin", "req-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("UPDATE email_verification_tokens")
+	mock.ExpectQuery("UPDATE").WillReturnError(sql.ErrNoRows)
+
+	_, err := VerifyEmail(db, testAudit, "secret")
+	require.Equal(t, ErrInvalidToken, err)
+	_, err = VerifyEmail(db, testAudit, "used")
+	require.Equal(t, ErrInvalidToken, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
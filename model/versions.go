@@ -0,0 +1,101 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+// UserVersion is a user as it was between two changes. ValidTo is zero for
+// the current version.
+type UserVersion struct {
+	User
+	Version   int
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+func scanUserVersion(scan func(...interface{}) error) (*UserVersion, error) {
+	v := &UserVersion{}
+	var validTo sql.NullTime
+	err := scan(&v.Id, &v.Version, &v.Name, &v.Email, &v.ValidFrom, &validTo)
+	if err != nil {
+		return nil, err
+	}
+	v.ValidTo = validTo.Time
+	return v, nil
+}
+
+const userVersionColumns = "user_id, version, name, email, valid_from, valid_to"
+
+// UserVersions returns every version of a user, oldest first, including
+// those from before it was deleted.
+func UserVersions(db Querier, id int) ([]*UserVersion, error) {
+	rows, err := db.Query("SELECT "+userVersionColumns+" FROM user_versions WHERE user_id=$1 ORDER BY version", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*UserVersion, 0)
+	for rows.Next() {
+		v, err := scanUserVersion(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetUserVersion returns one version of a user, or sql.ErrNoRows.
+func GetUserVersion(db Querier, id int, version int) (*UserVersion, error) {
+	stmt, err := db.Prepare("SELECT " + userVersionColumns + " FROM user_versions WHERE user_id=$1 AND version=$2")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanUserVersion(stmt.QueryRow(id, version).Scan)
+}
+
+// GetUserAsOf returns the version of a user that was current at t, or
+// sql.ErrNoRows if the user did not exist then.
+func GetUserAsOf(db Querier, id int, t time.Time) (*UserVersion, error) {
+	stmt, err := db.Prepare("SELECT " + userVersionColumns + " FROM user_versions " +
+		"WHERE user_id=$1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanUserVersion(stmt.QueryRow(id, t).Scan)
+}
+
+// RevertUser restores the name and email a user had at an earlier version,
+// keeping its password. The write is an ordinary conditional update, so it
+// is audited and published like any other and fails with
+// ErrVersionConflict if the user is no longer at ifVersion. With an
+// ifVersion of 0, the user's version when the earlier one is read is
+// expected instead.
+func RevertUser(db Querier, audit Audit, id int, version int, ifVersion int) (*User, error) {
+	target, err := GetUserVersion(db, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if ifVersion == 0 {
+		stmt, err := db.Prepare("SELECT version FROM users WHERE id=$1")
+		if err != nil {
+			return nil, err
+		}
+		defer stmt.Close()
+		if err := stmt.QueryRow(id).Scan(&ifVersion); err != nil {
+			return nil, err
+		}
+	}
+	v := validation.New()
+	email := validateProfile(v, target.Name, target.Email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return updateUser(db, audit, id, ifVersion, target.Name, email, nil)
+}
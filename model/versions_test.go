@@ -0,0 +1,75 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var userVersionRowColumns = []string{"user_id", "version", "name", "email", "valid_from", "valid_to"}
+
+func TestGetUserAsOf(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	at := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	validFrom := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectPrepare("FROM user_versions WHERE user_id=\\$1 AND valid_from <= \\$2")
+	rows := mock.NewRows(userVersionRowColumns).AddRow(1, 2, "Kal", "k@s.com", validFrom, nil)
+	mock.ExpectQuery("FROM user_versions").WithArgs(1, at).WillReturnRows(rows)
+
+	v, err := GetUserAsOf(db, 1, at)
+
+	require.NoError(t, err)
+	require.Equal(t, &UserVersion{User: User{Id: 1, Name: "Kal", Email: "k@s.com"}, Version: 2, ValidFrom: validFrom}, v)
+}
+
+func TestUpdateUserIfVersionConflict(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE users SET name=\\$1, email=\\$2, password=\\$3 WHERE id=\\$4 AND version=\\$5 RETURNING")
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, 3, "admin", "req-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("SELECT id, name, email, version FROM users")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 5))
+
+	_, err := UpdateUserIfVersion(db, testAudit, 1, 3, "Kaladin", "k@s.com", "password")
+
+	require.Equal(t, ErrVersionConflict, err)
+}
+
+func TestUpdateUserIfVersionMissingUser(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE")
+	mock.ExpectQuery("UPDATE").WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("SELECT id, name, email, version FROM users")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+	_, err := UpdateUserIfVersion(db, testAudit, 1, 3, "Kaladin", "k@s.com", "password")
+
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestRevertUserKeepsPassword(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("FROM user_versions WHERE user_id=\\$1 AND version=\\$2")
+	mock.ExpectQuery("FROM user_versions").WithArgs(1, 1).
+		WillReturnRows(mock.NewRows(userVersionRowColumns).AddRow(1, 1, "Kal", "k@s.com", time.Now(), time.Now()))
+	mock.ExpectPrepare("SELECT version FROM users")
+	mock.ExpectQuery("SELECT version").WithArgs(1).WillReturnRows(mock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectPrepare("UPDATE users SET name=\\$1, email=\\$2 WHERE id=\\$3 AND version=\\$4 RETURNING .+ SELECT u.id, \\$5, \\$6, 'update'")
+	mock.ExpectQuery("UPDATE").WithArgs("Kal", "k@s.com", 1, 4, "admin", "req-1").
+		WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 5))
+
+	user, err := RevertUser(db, testAudit, 1, 1, 0)
+
+	require.NoError(t, err)
+	require.Equal(t, &User{Id: 1, Name: "Kal", Email: "k@s.com", Version: 5}, user)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
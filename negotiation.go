@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/codec"
+)
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+type codecKey struct{}
+
+// negotiated picks the response codec from the Accept header before next
+// runs, so that a request nobody can answer is refused with 406 before it
+// has any effect.
+func negotiated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := codec.Negotiate(r.Header.Get("Accept"))
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), codecKey{}, c)))
+	}
+}
+
+func responseCodec(r *http.Request) *codec.Codec {
+	if c, ok := r.Context().Value(codecKey{}).(*codec.Codec); ok {
+		return c
+	}
+	return codec.Default()
+}
+
+// isForm reports whether a request carries its fields as a form or query
+// string rather than an encoded body.
+func isForm(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// decodeBody decodes the request body into v with the codec matching its
+// Content-Type, JSON if none is given.
+func decodeBody(r *http.Request, v interface{}) error {
+	c := codec.Default()
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		c = codec.ForContentType(contentType)
+		if c == nil {
+			return errUnsupportedMediaType
+		}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil && c.MediaType == "application/json" {
+		body, err = api.UnrenameJson(body)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(body, v)
+}
+
+func marshalAndWrite(data interface{}, w http.ResponseWriter, r *http.Request) {
+	marshalAndWriteStatus(data, http.StatusOK, w, r)
+}
+
+// marshalAndWriteStatus encodes data with the negotiated codec.
+func marshalAndWriteStatus(data interface{}, status int, w http.ResponseWriter, r *http.Request) {
+	c := responseCodec(r)
+	body, err := c.Marshal(data)
+	if err == nil && c.MediaType == "application/json" {
+		body, err = api.RenameJson(body)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	contentType := c.MediaType
+	if selected := requestVersion(r); selected.viaAccept && c == codec.Default() {
+		contentType = selected.version.mediaType()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestHandleGetUserXml(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1", map[string]string{"Accept": "application/xml"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "application/xml", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(body), "<user><id>1</id><name>Kaladin</name><email>k@s.com</email></user>")
+}
+
+func TestHandleGetUsersYaml(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users", map[string]string{"Accept": "application/json;q=0.5, application/yaml"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "application/yaml", resp.Header.Get("Content-Type"))
+	require.Equal(t, "- id: 1\n  name: Kaladin\n  email: k@s.com\n", string(body))
+}
+
+func TestHandleNotAcceptable(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodDelete, "http://localhost:1234/users/1", map[string]string{"Accept": "text/html"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotAcceptable, resp.StatusCode, string(body))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleCreateUserJsonBody(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users", bytes.NewBufferString(`{"name":"Kaladin","email":"k@s.com","password":"password"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+}
+
+func TestHandleUpdateUserMsgpackBody(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 2)
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", hashOf("password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+
+	payload, err := msgpack.Marshal(map[string]string{"name": "Kaladin", "email": "k@s.com", "password": "password"})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPut, "http://localhost:1234/users/1", bytes.NewBuffer(payload))
+	request.Header.Set("Content-Type", "application/msgpack")
+	request.Header.Set("Accept", "application/msgpack")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Equal(t, "application/msgpack", recorder.Header().Get("Content-Type"))
+	result := map[string]interface{}{}
+	require.NoError(t, msgpack.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Equal(t, "Kaladin", result["name"])
+}
+
+func TestHandleCreateUserUnsupportedMediaType(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users", bytes.NewBufferString("name=Kaladin"))
+	request.Header.Set("Content-Type", "text/plain")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusUnsupportedMediaType, recorder.Code, recorder.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleCreateUserMalformedBody(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users", bytes.NewBufferString("{"))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+}
+
+func TestHandleGetUsersXmlList(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users", map[string]string{"Accept": "application/xml"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Contains(t, string(body), "<list><user><id>1</id><name>Kaladin</name><email>k@s.com</email></user></list>")
+}
@@ -0,0 +1,847 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/webhooks"
+)
+
+type object = map[string]interface{}
+
+var muxVariablePattern = regexp.MustCompile(`\{([^:}]+):[^}]+\}`)
+
+// specPath turns a mux path template such as /users/{id:[0-9]+} into its
+// OpenAPI form, /users/{id}.
+func specPath(template string) string {
+	return muxVariablePattern.ReplaceAllString(template, "{$1}")
+}
+
+// specOperation documents one route of addUserRoutes. Paths are relative to
+// the version prefix and use OpenAPI templates rather than mux patterns.
+type specOperation struct {
+	path        string
+	method      string
+	id          string
+	summary     string
+	parameters  []object
+	requestBody object
+	responses   object
+}
+
+// operation builds the OpenAPI operation for op with the given responses,
+// adding those that every negotiated route can return.
+func (op specOperation) operation(responses object) object {
+	if _, ok := responses["400"]; !ok {
+		responses["400"] = problemResponse
+	}
+	responses["406"] = response("No acceptable media type", nil)
+	responses["429"] = tooManyRequestsResponse
+	if op.requestBody != nil {
+		responses["415"] = response("Unsupported request media type", nil)
+	}
+	operation := object{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"responses":   responses,
+	}
+	if op.parameters != nil {
+		operation["parameters"] = op.parameters
+	}
+	if op.requestBody != nil {
+		operation["requestBody"] = op.requestBody
+	}
+	return operation
+}
+
+func addOperation(paths object, path string, method string, operation object) {
+	item, ok := paths[path].(object)
+	if !ok {
+		item = object{}
+		paths[path] = item
+	}
+	item[strings.ToLower(method)] = operation
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// userResponse is a response with a single user and its version as the ETag.
+func userResponse(description string) object {
+	r := response(description, ref("{user}"))
+	r["headers"] = object{"ETag": etagHeader}
+	return r
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+func response(description string, schema object) object {
+	r := object{"description": description}
+	if schema != nil {
+		r["content"] = jsonContent(schema)
+	}
+	return r
+}
+
+var (
+	idParameter = object{
+		"name": "id", "in": "path", "required": true,
+		"schema": object{"type": "integer", "minimum": 0},
+	}
+	ifMatchParameter = object{
+		"name": "If-Match", "in": "header",
+		"description": "The version the client last saw, as an entity tag such as \"3\"",
+		"schema":      object{"type": "string"},
+	}
+	etagHeader = object{
+		"description": "The user's version as an entity tag such as \"3\", for If-Match; absent from users read as_of a point in time",
+		"schema":      object{"type": "string"},
+	}
+	problemResponse = object{
+		"description": "Invalid input",
+		"content":     object{"application/problem+json": object{"schema": ref("Problem")}},
+	}
+	tooManyRequestsResponse = object{
+		"description": "The client is over its rate limit for this route",
+		"headers": object{
+			"Retry-After": object{
+				"description": "Seconds until the client may try again",
+				"schema":      object{"type": "integer"},
+			},
+		},
+		"content": object{"application/problem+json": object{"schema": ref("Problem")}},
+	}
+	userInputBody = object{
+		"required": true,
+		"content": object{
+			"application/json":                  object{"schema": ref("UserInput")},
+			"application/x-www-form-urlencoded": object{"schema": ref("UserInput")},
+		},
+	}
+)
+
+// userOperations lists every route that addUserRoutes registers. The
+// "{user}" schema is replaced with the user schema of each API version.
+var userOperations = []specOperation{
+	{
+		path: "/users", method: http.MethodGet, id: "listUsers", summary: "List users",
+		parameters: []object{
+			{"name": "envelope", "in": "query", "schema": object{"type": "boolean"}},
+			{
+				"name": "limit", "in": "query",
+				"description": "Returns a page of at most this many users, linking to the next page when there is one",
+				"schema":      object{"type": "integer", "minimum": 1, "maximum": maxUsersPageSize},
+			},
+			{
+				"name": "after", "in": "query", "description": "With limit, starts the page after the user with this id",
+				"schema": object{"type": "integer", "minimum": 0},
+			},
+		},
+		responses: object{
+			"200": response("Users, optionally wrapped in an envelope", object{"oneOf": []object{
+				{"type": "array", "items": ref("{user}")},
+				ref("Envelope"),
+			}}),
+			"400": problemResponse,
+			"404": response("No users exist, when listing without limit", nil),
+		},
+	},
+	{
+		path: "/users", method: http.MethodPost, id: "createUser", summary: "Create a user",
+		parameters: []object{{
+			"name": "Idempotency-Key", "in": "header",
+			"description": "Makes the request safe to retry: a retry with the same key gets the first response again",
+			"schema":      object{"type": "string", "maxLength": idempotencyKeyMaxLength},
+		}},
+		requestBody: userInputBody,
+		responses: object{
+			"200": userResponse("The created user"),
+			"400": problemResponse,
+			"409": problemResponse,
+			"422": problemResponse,
+		},
+	},
+	{
+		path: "/users/{id}", method: http.MethodGet, id: "getUser", summary: "Get a user, optionally as it was at a point in time",
+		parameters: []object{idParameter, {"name": "as_of", "in": "query", "schema": object{"type": "string", "format": "date-time"}}},
+		responses: object{
+			"200": userResponse("The user"),
+			"404": response("No such user, or none at as_of", nil),
+		},
+	},
+	{
+		path: "/users/{id}", method: http.MethodPut, id: "updateUser", summary: "Replace a user",
+		parameters:  []object{idParameter, ifMatchParameter},
+		requestBody: userInputBody,
+		responses: object{
+			"200": userResponse("The updated user"),
+			"400": problemResponse,
+			"404": response("No such user", nil),
+			"409": problemResponse,
+			"412": response("The user is no longer at the If-Match version", nil),
+		},
+	},
+	{
+		path: "/users/{id}", method: http.MethodDelete, id: "deleteUser", summary: "Delete a user",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("The deleted user", ref("{user}")),
+			"404": response("No such user", nil),
+		},
+	},
+	{
+		path: "/users/{id}/versions", method: http.MethodGet, id: "userVersions", summary: "List every version of a user",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("Every version of the user, oldest first", object{"type": "array", "items": object{
+				"type":     "object",
+				"required": []string{"version", "user", "valid_from"},
+				"properties": object{
+					"version":    object{"type": "integer"},
+					"user":       ref("{user}"),
+					"valid_from": object{"type": "string"},
+					"valid_to":   object{"type": "string"},
+				},
+			}}),
+			"404": response("No such user", nil),
+		},
+	},
+	{
+		path: "/users/{id}/revert", method: http.MethodPost, id: "revertUser", summary: "Restore a user's name and email from an earlier version",
+		parameters: []object{idParameter, ifMatchParameter, {
+			"name": "version", "in": "query", "required": true,
+			"schema": object{"type": "integer", "minimum": 1},
+		}},
+		responses: object{
+			"200": userResponse("The reverted user"),
+			"400": problemResponse,
+			"404": response("No such user or version", nil),
+			"409": problemResponse,
+			"412": response("The user is no longer at the If-Match version", nil),
+		},
+	},
+	{
+		path: "/users/{id}/unlock", method: http.MethodPost, id: "unlockUser", summary: "Lift the lock on a user's account after too many failed logins",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("The unlocked user", ref("{user}")),
+			"403": problemResponse,
+			"404": response("No such user", nil),
+		},
+	},
+	{
+		path: "/users/{id}/history", method: http.MethodGet, id: "userHistory", summary: "List the changes made to a user",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("Every change to the user, oldest first", object{"type": "array", "items": auditEntrySchema(ref("{user}"))}),
+			"404": response("The user was never changed", nil),
+		},
+	},
+	{
+		path: "/users:batch", method: http.MethodPost, id: "batchUsers", summary: "Apply several writes",
+		parameters: []object{{"name": "atomic", "in": "query", "schema": object{"type": "boolean", "default": true}}},
+		requestBody: object{
+			"required": true,
+			"content":  jsonContent(object{"type": "array", "items": ref("BatchOperation"), "minItems": 1, "maxItems": maxBatchOperations}),
+		},
+		responses: object{
+			"200":     response("Per-operation results", object{"type": "array", "items": ref("BatchResult")}),
+			"400":     response("An atomic batch failed validation", object{"type": "array", "items": ref("BatchResult")}),
+			"default": response("An atomic batch failed with the status of its failing operation", object{"type": "array", "items": ref("BatchResult")}),
+		},
+	},
+	{
+		path: "/users/import", method: http.MethodPost, id: "importUsers", summary: "Import users from CSV or NDJSON",
+		parameters: []object{
+			{"name": "format", "in": "query", "schema": object{"type": "string", "enum": []string{"csv", "ndjson"}}},
+			{"name": "dry_run", "in": "query", "schema": object{"type": "boolean"}},
+		},
+		requestBody: object{
+			"required": true,
+			"content": object{
+				"text/csv":             object{"schema": object{"type": "string"}},
+				"application/x-ndjson": object{"schema": object{"type": "string"}},
+			},
+		},
+		responses: object{
+			"200": response("What was imported and what was rejected", ref("ImportReport")),
+			"400": response("Unknown format, or a CSV header without the name, email and password columns", nil),
+		},
+	},
+	{
+		path: "/users/export", method: http.MethodGet, id: "exportUsers", summary: "Export every user",
+		parameters: []object{{"name": "format", "in": "query", "schema": object{"type": "string", "enum": []string{"csv", "ndjson", "json"}, "default": "json"}}},
+		responses: object{
+			"200": object{
+				"description": "Every user",
+				"content": object{
+					"application/json":        object{"schema": object{"type": "array", "items": ref("{user}")}},
+					"application/x-ndjson":    object{"schema": object{"type": "string"}},
+					"text/csv; charset=utf-8": object{"schema": object{"type": "string"}},
+				},
+			},
+			"400": response("Unknown format", nil),
+		},
+	},
+	{
+		path: "/users/events", method: http.MethodGet, id: "userEvents", summary: "Stream user changes",
+		parameters: []object{{"name": "Last-Event-ID", "in": "header", "description": "The id of the last event received, to resume after it", "schema": object{"type": "string", "format": "uuid"}}},
+		responses: object{
+			"200": object{
+				"description": "user.created, user.updated and user.deleted server-sent events",
+				"content":     object{"text/event-stream": object{"schema": object{"type": "string"}}},
+			},
+			"400": response("Malformed Last-Event-ID", nil),
+		},
+	},
+}
+
+// auditEntrySchema describes a change to a user, with user as the schema of
+// the user before and after it.
+func auditEntrySchema(user object) object {
+	return object{
+		"type":     "object",
+		"required": []string{"id", "user_id", "actor", "operation", "changed_at"},
+		"properties": object{
+			"id":         object{"type": "integer"},
+			"user_id":    object{"type": "integer"},
+			"actor":      object{"type": "string"},
+			"request_id": object{"type": "string"},
+			"operation":  object{"type": "string", "enum": []string{model.AuditCreate, model.AuditUpdate, model.AuditDelete, model.AuditLock, model.AuditUnlock, model.AuditPasswordReset, model.AuditVerifyEmail}},
+			"before":     user,
+			"after":      user,
+			"changed_at": object{"type": "string"},
+		},
+	}
+}
+
+// auditOperations lists every route that addAuditRoutes registers.
+var auditOperations = []specOperation{
+	{
+		path: "/audit", method: http.MethodGet, id: "listAudit", summary: "List changes to every user",
+		parameters: []object{
+			{"name": "actor", "in": "query", "schema": object{"type": "string"}},
+			{"name": "since", "in": "query", "schema": object{"type": "string", "format": "date-time"}},
+			{"name": "limit", "in": "query", "schema": object{"type": "integer", "minimum": 1, "maximum": auditMaxLimit, "default": auditDefaultLimit}},
+		},
+		responses: object{"200": response("The matching changes, oldest first", object{"type": "array", "items": auditEntrySchema(ref("User"))})},
+	},
+}
+
+// formOrJSONBody is a required request body of the named schema, sent as
+// JSON or as a form.
+func formOrJSONBody(schema string) object {
+	return object{
+		"required": true,
+		"content": object{
+			"application/json":                  object{"schema": ref(schema)},
+			"application/x-www-form-urlencoded": object{"schema": ref(schema)},
+		},
+	}
+}
+
+// authOperations lists every route that addAuthRoutes registers.
+var authOperations = []specOperation{
+	{
+		path: "/auth/login", method: http.MethodPost, id: "login", summary: "Check a user's email and password",
+		requestBody: formOrJSONBody("LoginInput"),
+		responses: object{
+			"200": response("The user with these credentials", ref("User")),
+			"401": problemResponse,
+			"403": problemResponse,
+			"429": tooManyRequestsResponse,
+		},
+	},
+	{
+		path: "/auth/password-reset", method: http.MethodPost, id: "requestPasswordReset", summary: "Email a password reset token to a user",
+		requestBody: formOrJSONBody("PasswordResetRequest"),
+		responses:   object{"202": response("A token was sent if a user has the email", nil)},
+	},
+	{
+		path: "/auth/password-reset/confirm", method: http.MethodPost, id: "confirmPasswordReset", summary: "Choose a new password with a reset token",
+		requestBody: formOrJSONBody("PasswordResetConfirm"),
+		responses: object{
+			"200": response("The user whose password was reset", ref("User")),
+			"400": problemResponse,
+		},
+	},
+	{
+		path: "/auth/verify", method: http.MethodGet, id: "verifyEmail", summary: "Verify a user's email with the token sent to it",
+		parameters: []object{{"name": "token", "in": "query", "required": true, "schema": object{"type": "string"}}},
+		responses: object{
+			"200": response("The user whose email was verified", ref("User")),
+			"400": problemResponse,
+		},
+	},
+	{
+		path: "/auth/verify/resend", method: http.MethodPost, id: "resendVerification", summary: "Email another verification token to a user",
+		requestBody: formOrJSONBody("PasswordResetRequest"),
+		responses:   object{"202": response("A token was sent if an unverified user has the email", nil)},
+	},
+}
+
+var webhookInputBody = object{
+	"required": true,
+	"content":  jsonContent(ref("WebhookInput")),
+}
+
+// webhookOperations lists every route that addWebhookRoutes registers.
+var webhookOperations = []specOperation{
+	{
+		path: "/webhooks", method: http.MethodGet, id: "listWebhooks", summary: "List webhooks",
+		responses: object{"200": response("Every webhook", object{"type": "array", "items": ref("Webhook")})},
+	},
+	{
+		path: "/webhooks", method: http.MethodPost, id: "createWebhook", summary: "Create a webhook",
+		requestBody: webhookInputBody,
+		responses:   object{"200": response("The created webhook, with its signing secret", ref("Webhook"))},
+	},
+	{
+		path: "/webhooks/{id}", method: http.MethodGet, id: "getWebhook", summary: "Get a webhook",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("The webhook", ref("Webhook")),
+			"404": response("No such webhook", nil),
+		},
+	},
+	{
+		path: "/webhooks/{id}", method: http.MethodPut, id: "updateWebhook", summary: "Replace a webhook's URL and events",
+		parameters:  []object{idParameter},
+		requestBody: webhookInputBody,
+		responses: object{
+			"200": response("The updated webhook", ref("Webhook")),
+			"404": response("No such webhook", nil),
+		},
+	},
+	{
+		path: "/webhooks/{id}", method: http.MethodDelete, id: "deleteWebhook", summary: "Delete a webhook and its deliveries",
+		parameters: []object{idParameter},
+		responses: object{
+			"200": response("The deleted webhook", ref("Webhook")),
+			"404": response("No such webhook", nil),
+		},
+	},
+	{
+		path: "/webhooks/{id}/deliveries", method: http.MethodGet, id: "listWebhookDeliveries", summary: "List a webhook's recent deliveries",
+		parameters: []object{idParameter},
+		responses:  object{"200": response("The most recent deliveries, newest first", object{"type": "array", "items": ref("WebhookDelivery")})},
+	},
+	{
+		path: "/webhooks/{id}/deliveries/{delivery}/redeliver", method: http.MethodPost, id: "redeliverWebhook", summary: "Queue a delivery again",
+		parameters: []object{idParameter, {
+			"name": "delivery", "in": "path", "required": true,
+			"schema": object{"type": "integer", "minimum": 0},
+		}},
+		responses: object{
+			"200": response("The requeued delivery", ref("WebhookDelivery")),
+			"404": response("No such delivery", nil),
+		},
+	},
+}
+
+var specSchemas = object{
+	"User": object{
+		"type":     "object",
+		"required": []string{"id", "name", "email"},
+		"properties": object{
+			"id":    object{"type": "integer"},
+			"name":  object{"type": "string"},
+			"email": object{"type": "string", "format": "email"},
+		},
+	},
+	"UserV2": object{
+		"type":     "object",
+		"required": []string{"id", "name", "email", "links"},
+		"properties": object{
+			"id":    object{"type": "string"},
+			"name":  object{"type": "string"},
+			"email": object{"type": "string", "format": "email"},
+			"links": ref("Links"),
+		},
+	},
+	"UserInput": object{
+		"type":     "object",
+		"required": []string{"name", "email", "password"},
+		"properties": object{
+			"name":     object{"type": "string", "minLength": 1, "maxLength": 100},
+			"email":    object{"type": "string", "format": "email"},
+			"password": object{"type": "string", "minLength": 8, "maxLength": 72, "description": "At most 72 bytes of UTF-8, which may be fewer than 72 characters"},
+		},
+	},
+	"LoginInput": object{
+		"type":     "object",
+		"required": []string{"email", "password"},
+		"properties": object{
+			"email":    object{"type": "string"},
+			"password": object{"type": "string"},
+		},
+	},
+	"PasswordResetRequest": object{
+		"type":       "object",
+		"required":   []string{"email"},
+		"properties": object{"email": object{"type": "string"}},
+	},
+	"PasswordResetConfirm": object{
+		"type":     "object",
+		"required": []string{"token", "password"},
+		"properties": object{
+			"token":    object{"type": "string"},
+			"password": object{"type": "string", "minLength": 8, "maxLength": 72, "description": "At most 72 bytes of UTF-8, which may be fewer than 72 characters"},
+		},
+	},
+	"Links": object{
+		"type":       "object",
+		"properties": object{"self": object{"type": "string"}, "next": object{"type": "string"}},
+	},
+	"Envelope": object{
+		"type":     "object",
+		"required": []string{"data", "meta", "links"},
+		"properties": object{
+			"data": object{"type": "array", "items": object{}},
+			"meta": object{
+				"type":       "object",
+				"properties": object{"count": object{"type": "integer"}},
+			},
+			"links": ref("Links"),
+		},
+	},
+	"Problem": object{
+		"type":     "object",
+		"required": []string{"type", "title", "status"},
+		"properties": object{
+			"type":   object{"type": "string"},
+			"title":  object{"type": "string"},
+			"status": object{"type": "integer"},
+			"detail": object{"type": "string"},
+			"errors": object{"type": "array", "items": object{
+				"type":     "object",
+				"required": []string{"field", "code", "message"},
+				"properties": object{
+					"field":   object{"type": "string"},
+					"code":    object{"type": "string"},
+					"message": object{"type": "string"},
+				},
+			}},
+		},
+	},
+	"BatchOperation": object{
+		"type":     "object",
+		"required": []string{"op"},
+		"properties": object{
+			"op":       object{"type": "string", "enum": []string{"create", "update", "delete"}},
+			"id":       object{"type": "integer"},
+			"name":     object{"type": "string"},
+			"email":    object{"type": "string"},
+			"password": object{"type": "string"},
+		},
+	},
+	"BatchResult": object{
+		"type":     "object",
+		"required": []string{"status"},
+		"properties": object{
+			"status": object{"type": "integer"},
+			"user":   object{"type": "object"},
+			"error":  object{"type": "string"},
+			"errors": object{"type": "array", "items": object{"type": "object"}},
+		},
+	},
+	"ImportReport": object{
+		"type":     "object",
+		"required": []string{"dry_run", "accepted", "rejected"},
+		"properties": object{
+			"dry_run":  object{"type": "boolean"},
+			"accepted": object{"type": "integer"},
+			"rejected": object{"type": "array", "items": object{
+				"type": "object",
+				"properties": object{
+					"line":   object{"type": "integer"},
+					"reason": object{"type": "string"},
+				},
+			}},
+		},
+	},
+	"Webhook": object{
+		"type":     "object",
+		"required": []string{"id", "url", "events"},
+		"properties": object{
+			"id":     object{"type": "integer"},
+			"url":    object{"type": "string"},
+			"events": object{"type": "array", "items": object{"type": "string"}},
+			"secret": object{"type": "string", "description": "Signs deliveries; only returned on creation"},
+		},
+	},
+	"WebhookInput": object{
+		"type":     "object",
+		"required": []string{"url", "events"},
+		"properties": object{
+			"url": object{"type": "string"},
+			"events": object{"type": "array", "minItems": 1, "items": object{
+				"type": "string", "enum": webhooks.EventTypes,
+			}},
+		},
+	},
+	"WebhookDelivery": object{
+		"type":     "object",
+		"required": []string{"id", "event", "status", "attempts", "next_attempt_at", "created_at"},
+		"properties": object{
+			"id":              object{"type": "integer"},
+			"event":           object{"type": "string"},
+			"status":          object{"type": "string", "enum": []string{webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead}},
+			"attempts":        object{"type": "integer"},
+			"next_attempt_at": object{"type": "string"},
+			"last_error":      object{"type": "string"},
+			"created_at":      object{"type": "string"},
+		},
+	},
+	"GraphQLRequest": object{
+		"type":     "object",
+		"required": []string{"query"},
+		"properties": object{
+			"query":         object{"type": "string"},
+			"variables":     object{"type": "object"},
+			"operationName": object{"type": "string"},
+		},
+	},
+	"GraphQLResponse": object{
+		"type": "object",
+		"properties": object{
+			"data":   object{},
+			"errors": object{"type": "array", "items": object{"type": "object"}},
+		},
+	},
+}
+
+// withUserSchema copies v, replacing references to the "{user}" placeholder
+// schema with the named one.
+func withUserSchema(v interface{}, user string) interface{} {
+	switch v := v.(type) {
+	case object:
+		copied := object{}
+		for key, value := range v {
+			copied[key] = withUserSchema(value, user)
+		}
+		if copied["$ref"] == "#/components/schemas/{user}" {
+			copied["$ref"] = "#/components/schemas/" + user
+		}
+		return copied
+	case []object:
+		copied := make([]object, len(v))
+		for i, value := range v {
+			copied[i] = withUserSchema(value, user).(object)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// withNaming copies v, putting the property names of its schemas in the
+// configured JSON naming.
+func withNaming(v interface{}) interface{} {
+	switch v := v.(type) {
+	case object:
+		copied := object{}
+		for key, value := range v {
+			copied[key] = withNaming(value)
+		}
+		if properties, ok := v["properties"].(object); ok {
+			renamed := object{}
+			for name, schema := range properties {
+				renamed[api.CamelCase(name)] = withNaming(schema)
+			}
+			copied["properties"] = renamed
+		}
+		if required, ok := v["required"].([]string); ok {
+			renamed := make([]string, len(required))
+			for i, name := range required {
+				renamed[i] = api.CamelCase(name)
+			}
+			copied["required"] = renamed
+		}
+		return copied
+	case []object:
+		copied := make([]object, len(v))
+		for i, value := range v {
+			copied[i] = withNaming(value).(object)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// unversionedResponses documents the default version's user under
+// application/json and every version under its vendor media type.
+func unversionedResponses(responses object) object {
+	result := withUserSchema(responses, defaultApiVersion.schema).(object)
+	for status, r := range responses {
+		content, ok := r.(object)["content"].(object)
+		if !ok {
+			continue
+		}
+		media, ok := content["application/json"]
+		if !ok {
+			continue
+		}
+		resultContent := result[status].(object)["content"].(object)
+		for _, v := range apiVersions {
+			resultContent[v.mediaType()] = withUserSchema(media, v.schema)
+		}
+	}
+	return result
+}
+
+// graphQLResponses documents /graphql, whose results and errors are both
+// GraphQL response objects.
+func graphQLResponses() object {
+	return object{
+		"200": object{
+			"description": "The GraphQL result, which may carry errors from resolvers",
+			"content": object{
+				"application/json": object{"schema": ref("GraphQLResponse")},
+				"text/html":        object{"schema": object{"type": "string"}},
+			},
+		},
+		"400": response("The request could not be run", ref("GraphQLResponse")),
+		"405": response("Mutations must use POST", ref("GraphQLResponse")),
+	}
+}
+
+// openAPISpec builds the OpenAPI 3.1 document for every route in getRouter.
+func openAPISpec() object {
+	paths := object{
+		"/readiness": object{"get": object{
+			"operationId": "readiness", "summary": "Readiness probe",
+			"responses": object{"200": object{
+				"description": "The service is ready",
+				"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+			}},
+		}},
+		"/openapi.json": object{"get": object{
+			"operationId": "openapi", "summary": "This document",
+			"responses": object{"200": response("The OpenAPI document", object{"type": "object"})},
+		}},
+		"/docs": object{"get": object{
+			"operationId": "docs", "summary": "API reference page",
+			"responses": object{"200": object{
+				"description": "HTML page rendering this document",
+				"content":     object{"text/html": object{"schema": object{"type": "string"}}},
+			}},
+		}},
+		"/ws": object{"get": object{
+			"operationId": "websocket", "summary": "Subscribe to user changes over a WebSocket",
+			"description": "Like every request, the handshake is authenticated by the proxy in front of the API, which passes the caller on in X-Forwarded-User.",
+			"responses": object{
+				"101": response("Switching to the WebSocket subscription protocol", nil),
+				"400": response("Not a WebSocket handshake", nil),
+				"401": object{
+					"description": "The proxy passed on no X-Forwarded-User",
+					"content":     object{"application/problem+json": object{"schema": ref("Problem")}},
+				},
+				"403": response("Cross-origin handshake", nil),
+			},
+		}},
+		"/graphql": object{
+			"get": object{
+				"operationId": "graphqlQuery", "summary": "Run a GraphQL query, or open GraphiQL when enabled",
+				"parameters": []object{
+					{"name": "query", "in": "query", "schema": object{"type": "string"}},
+					{"name": "variables", "in": "query", "schema": object{"type": "string"}},
+					{"name": "operationName", "in": "query", "schema": object{"type": "string"}},
+				},
+				"responses": graphQLResponses(),
+			},
+			"post": object{
+				"operationId": "graphql", "summary": "Run a GraphQL query or mutation",
+				"requestBody": object{
+					"required": true,
+					"content":  jsonContent(ref("GraphQLRequest")),
+				},
+				"responses": graphQLResponses(),
+			},
+		},
+	}
+	for _, version := range append([]*apiVersion{nil}, apiVersions...) {
+		prefix := ""
+		if version != nil {
+			prefix = "/" + version.name
+		}
+		for _, op := range userOperations {
+			var responses object
+			if version != nil {
+				responses = withUserSchema(op.responses, version.schema).(object)
+			} else {
+				responses = unversionedResponses(op.responses)
+			}
+			operation := op.operation(responses)
+			if version != nil {
+				operation["operationId"] = version.name + strings.ToUpper(op.id[:1]) + op.id[1:]
+				operation["tags"] = []string{version.name}
+			}
+			addOperation(paths, prefix+op.path, op.method, operation)
+		}
+	}
+	for tag, ops := range map[string][]specOperation{"webhooks": webhookOperations, "audit": auditOperations, "auth": authOperations} {
+		for _, op := range ops {
+			responses := object{}
+			for status, r := range op.responses {
+				responses[status] = r
+			}
+			operation := op.operation(responses)
+			operation["tags"] = []string{tag}
+			addOperation(paths, op.path, op.method, operation)
+		}
+	}
+
+	spec := object{
+		"openapi": "3.1.0",
+		"info": object{
+			"title":   "Users API",
+			"version": "2.0.0",
+		},
+		"paths":      paths,
+		"components": object{"schemas": specSchemas},
+	}
+	if api.Naming != api.NamingSnake {
+		spec = withNaming(spec).(object)
+	}
+	return spec
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(openAPISpec())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Users API</title>
+    <meta charset="utf-8"/>
+  </head>
+  <body>
+    <redoc spec-url="/openapi.json"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+  </body>
+</html>
+`
+
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, docsPage)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpecCoversEveryRoute(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	spec := openAPISpec()
+	paths := spec["paths"].(object)
+	count := 0
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		item, ok := paths[specPath(template)].(object)
+		require.True(t, ok, "route %s is missing from the OpenAPI spec", template)
+		for _, method := range methods {
+			_, ok := item[strings.ToLower(method)]
+			require.True(t, ok, "%s %s is missing from the OpenAPI spec", method, template)
+			count++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Greater(t, count, 20)
+}
+
+func TestOpenAPISpecReferencesResolve(t *testing.T) {
+	body, err := json.Marshal(openAPISpec())
+	require.NoError(t, err)
+
+	schemas := openAPISpec()["components"].(object)["schemas"].(object)
+	for _, match := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(string(body), -1) {
+		_, ok := schemas[match[1]]
+		require.True(t, ok, "schema %s is not defined", match[1])
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/openapi.json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	spec := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(body, &spec))
+	require.Equal(t, "3.1.0", spec["openapi"])
+	v2User := spec["paths"].(map[string]interface{})["/v2/users/{id}"].(map[string]interface{})["get"].(map[string]interface{})
+	require.Equal(t, "v2GetUser", v2User["operationId"])
+	require.Contains(t, string(body), `"$ref":"#/components/schemas/UserV2"`)
+}
+
+func TestHandleDocs(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/docs", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), `spec-url="/openapi.json"`)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+const (
+	validationOff     = "off"
+	validationAudit   = "audit"
+	validationEnforce = "enforce"
+)
+
+// maxValidatedResponse bounds how much of a response body is kept for
+// validation; larger responses, such as exports, are not checked.
+const maxValidatedResponse = 1 << 20
+
+var (
+	// openAPIRequestMode decides what happens to requests that do not match
+	// the OpenAPI document: nothing, a log line, or a 400.
+	openAPIRequestMode = validationOff
+	// openAPIValidateResponses checks documented responses against the
+	// document and passes mismatches to openAPIResponseViolation.
+	openAPIValidateResponses = false
+	openAPIResponseViolation = func(r *http.Request, status int, err error) {
+		log.Printf("openapi: %s %s response %d: %v", r.Method, r.URL.Path, status, err)
+	}
+)
+
+// specValidator checks requests and responses against an OpenAPI document.
+type specValidator struct {
+	paths   object
+	schemas object
+}
+
+func newSpecValidator(spec object) *specValidator {
+	return &specValidator{
+		paths:   spec["paths"].(object),
+		schemas: spec["components"].(object)["schemas"].(object),
+	}
+}
+
+func (v *specValidator) operation(r *http.Request) object {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	item, _ := v.paths[specPath(template)].(object)
+	operation, _ := item[strings.ToLower(r.Method)].(object)
+	return operation
+}
+
+// middleware validates requests and responses of the routes it wraps.
+func (v *specValidator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := v.operation(r)
+		if operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if openAPIRequestMode != validationOff {
+			if err := v.validateRequest(operation, r); err != nil {
+				if openAPIRequestMode == validationEnforce {
+					writeRequestViolation(w, r, err)
+					return
+				}
+				log.Printf("openapi: %s %s request: %v", r.Method, r.URL.Path, err)
+			}
+		}
+
+		if !openAPIValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &responseTee{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.overflow || recorder.hijacked {
+			return
+		}
+		if err := v.validateResponse(operation, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+			openAPIResponseViolation(r, recorder.status, err)
+		}
+	})
+}
+
+func writeRequestViolation(w http.ResponseWriter, r *http.Request, err error) {
+	if fields, ok := err.(validation.Errors); ok {
+		writeProblem(w, r, 400, "request does not match the API description", fields)
+		return
+	}
+	writeProblem(w, r, 400, err.Error(), nil)
+}
+
+// responseTee passes a response through while keeping a copy of its body.
+type responseTee struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+	hijacked bool
+}
+
+func (t *responseTee) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *responseTee) Write(p []byte) (int, error) {
+	if !t.overflow {
+		if t.body.Len()+len(p) > maxValidatedResponse {
+			t.overflow = true
+			t.body.Reset()
+		} else {
+			t.body.Write(p)
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *responseTee) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *responseTee) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through; the connection is then out of the
+// validator's hands.
+func (t *responseTee) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	t.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (v *specValidator) validateRequest(operation object, r *http.Request) error {
+	errs := validation.Errors{}
+	parameters, _ := operation["parameters"].([]object)
+	query := r.URL.Query()
+	for _, parameter := range parameters {
+		name := parameter["name"].(string)
+		schema := parameter["schema"].(object)
+		var raw string
+		var present bool
+		switch parameter["in"] {
+		case "path":
+			raw, present = mux.Vars(r)[name]
+		case "query":
+			_, present = query[name]
+			raw = query.Get(name)
+		}
+		if !present {
+			if parameter["required"] == true {
+				errs = append(errs, &validation.FieldError{Field: name, Code: "required"})
+			}
+			continue
+		}
+		errs = append(errs, v.validate(schema, parameterValue(schema, raw), name)...)
+	}
+
+	if body, ok := operation["requestBody"].(object); ok {
+		bodyErrs, err := v.validateRequestBody(body, r)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, bodyErrs...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parameterValue converts a raw parameter to the JSON type its schema
+// expects, leaving it a string when it cannot be converted.
+func parameterValue(schema object, raw string) interface{} {
+	switch schema["type"] {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+// validateRequestBody checks JSON and form bodies. Other media types, such
+// as XML or CSV, are left to the handlers.
+func (v *specValidator) validateRequestBody(body object, r *http.Request) (validation.Errors, error) {
+	content := body["content"].(object)
+	if isForm(r) {
+		media, ok := content["application/x-www-form-urlencoded"].(object)
+		if !ok {
+			return nil, nil
+		}
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		form := map[string]interface{}{}
+		for key := range r.Form {
+			form[key] = r.Form.Get(key)
+		}
+		return v.validate(media["schema"].(object), form, ""), nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	media, ok := content[mediaType].(object)
+	if !ok || mediaType != "application/json" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("body is not valid JSON: %v", err)
+	}
+	return v.validate(media["schema"].(object), value, ""), nil
+}
+
+func (v *specValidator) validateResponse(operation object, status int, contentType string, body []byte) error {
+	responses := operation["responses"].(object)
+	response, ok := responses[strconv.Itoa(status)].(object)
+	if !ok {
+		response, ok = responses["default"].(object)
+	}
+	if !ok {
+		if status >= 500 {
+			return nil
+		}
+		return fmt.Errorf("status %d is not documented", status)
+	}
+	content, ok := response["content"].(object)
+	if !ok {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	media, ok := content[mediaType].(object)
+	if !ok {
+		media, ok = content["application/json"].(object)
+	}
+	if !ok {
+		return fmt.Errorf("media type %s is not documented", mediaType)
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("body is not valid JSON: %v", err)
+	}
+	if errs := v.validate(media["schema"].(object), value, ""); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (v *specValidator) resolve(schema object) object {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		schema = v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(object)
+	}
+}
+
+func childField(parent string, child string) string {
+	if parent == "" {
+		return child
+	}
+	if strings.HasPrefix(child, "[") {
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+// validate checks value against the subset of JSON Schema the document
+// uses: $ref, oneOf, type, enum, required, properties, items, length and
+// item-count limits and minimum. Failures use the validation package's rule
+// codes so that they read the same as handler errors.
+func (v *specValidator) validate(schema object, value interface{}, field string) validation.Errors {
+	schema = v.resolve(schema)
+	errs := validation.Errors{}
+	add := func(code string, args ...interface{}) validation.Errors {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		return append(errs, &validation.FieldError{Field: name, Code: code, Args: args})
+	}
+
+	if options, ok := schema["oneOf"].([]object); ok {
+		matches := 0
+		for _, option := range options {
+			if len(v.validate(option, value, field)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return add("schema")
+		}
+		return errs
+	}
+
+	if t, ok := schema["type"].(string); ok && !hasType(value, t) {
+		return add("type", t)
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		s, _ := value.(string)
+		found := false
+		for _, e := range enum {
+			found = found || e == s
+		}
+		if !found {
+			return add("enum", strings.Join(enum, ", "))
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		if min, ok := schema["minLength"].(int); ok && len([]rune(value)) < min {
+			return add("min_length", min)
+		}
+		if max, ok := schema["maxLength"].(int); ok && len([]rune(value)) > max {
+			return add("max_length", max)
+		}
+	case float64:
+		if min, ok := schema["minimum"].(int); ok && value < float64(min) {
+			return add("minimum", min)
+		}
+	case []interface{}:
+		if min, ok := schema["minItems"].(int); ok && len(value) < min {
+			return add("min_items", min)
+		}
+		if max, ok := schema["maxItems"].(int); ok && len(value) > max {
+			return add("max_items", max)
+		}
+		if items, ok := schema["items"].(object); ok {
+			for i, item := range value {
+				errs = append(errs, v.validate(items, item, childField(field, fmt.Sprintf("[%d]", i)))...)
+			}
+		}
+	case map[string]interface{}:
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if s, present := value[name]; !present || s == "" {
+				errs = append(errs, &validation.FieldError{Field: childField(field, name), Code: "required"})
+			}
+		}
+		properties, _ := schema["properties"].(object)
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, present := value[name]; present && !(property == "" && contains(required, name)) {
+				errs = append(errs, v.validate(properties[name].(object), property, childField(field, name))...)
+			}
+		}
+	}
+	return errs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	}
+	return true
+}
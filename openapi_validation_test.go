@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func withRequestMode(mode string) func() {
+	openAPIRequestMode = mode
+	return func() { openAPIRequestMode = validationOff }
+}
+
+func TestOpenAPIEnforceRejectsInvalidForm(t *testing.T) {
+	defer withRequestMode(validationEnforce)()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&password=short", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	result := &problem{}
+	require.NoError(t, json.Unmarshal(body, result))
+	require.Equal(t, "request does not match the API description", result.Detail)
+	require.Equal(t, []problemField{
+		{Field: "email", Code: "required", Message: "is required"},
+		{Field: "password", Code: "min_length", Message: "must be at least 8 characters"},
+	}, result.Errors)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOpenAPIEnforceRejectsInvalidQuery(t *testing.T) {
+	defer withRequestMode(validationEnforce)()
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v2/users?envelope=maybe", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.Contains(t, string(body), "\"field\":\"envelope\",\"code\":\"type\"")
+}
+
+func TestOpenAPIEnforceRejectsInvalidJsonBody(t *testing.T) {
+	defer withRequestMode(validationEnforce)()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users:batch", strings.NewReader(`[{"op": "merge", "id": "1"}]`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+	result := &problem{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), result))
+	require.Equal(t, "[0].id", result.Errors[0].Field)
+	require.Equal(t, "[0].op", result.Errors[1].Field)
+	require.Equal(t, "must be one of create, update, delete", result.Errors[1].Message)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOpenAPIEnforcePassesValidRequest(t *testing.T) {
+	defer withRequestMode(validationEnforce)()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow(1, "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("INSERT").WithArgs("Kaladin", "k@s.com", hashOf("password"), "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/users", strings.NewReader(`{"name":"Kaladin","email":"k@s.com","password":"password"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOpenAPIAuditLetsRequestThrough(t *testing.T) {
+	defer withRequestMode(validationAudit)()
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/users?name=Kaladin&email=k@s.com&password=short", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, string(body))
+	require.NotContains(t, string(body), "request does not match the API description")
+}
+
+func TestOpenAPIResponseValidation(t *testing.T) {
+	validator := newSpecValidator(openAPISpec())
+	operation := validator.paths["/v2/users/{id}"].(object)["get"].(object)
+
+	require.NoError(t, validator.validateResponse(operation, 200, "application/json", []byte(`{"id":"1","name":"Kaladin","email":"k@s.com","links":{"self":"/v2/users/1"}}`)))
+	require.EqualError(t, validator.validateResponse(operation, 200, "application/json", []byte(`{"id":1,"name":"Kaladin"}`)), "email: is required; links: is required; id: must be of type string")
+	require.EqualError(t, validator.validateResponse(operation, 418, "text/plain", nil), "status 418 is not documented")
+	require.NoError(t, validator.validateResponse(operation, 500, "text/plain", nil))
+}
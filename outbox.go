@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tammiec/go-rest-api/outbox"
+)
+
+const outboxPollInterval = time.Second
+
+// listenForUserEvents feeds userEvents with the events that the "broker" sink
+// of any instance's relay announces, until ctx is done.
+func listenForUserEvents(ctx context.Context, dbUrl string) error {
+	listener := pq.NewListener(dbUrl, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("outbox: %v", err)
+		}
+	})
+	if err := listener.Listen(outbox.NotifyChannel); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		defer listener.Close()
+		outbox.Listen(ctx, listener.Notify, &outbox.BrokerSink{Broker: userEvents})
+	}()
+	return nil
+}
+
+// outboxSinks builds the relay's sinks from a comma-separated list:
+// "broker" announces events to every instance's /users/events and /ws,
+// "webhooks" queues webhook
+// deliveries, "verification" emails new addresses a verification token,
+// "stdout" writes events as JSON lines and "file:PATH" appends them to a
+// file.
+func outboxSinks(spec string, db *sql.DB) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "broker":
+			sinks = append(sinks, &outbox.NotifySink{DB: db})
+		case name == "webhooks":
+			sinks = append(sinks, &outbox.WebhookSink{DB: db})
+		case name == "verification":
+			sinks = append(sinks, &verificationSink{DB: db})
+		case name == "stdout":
+			sinks = append(sinks, outbox.NewWriterSink(os.Stdout))
+		case strings.HasPrefix(name, "file:"):
+			f, err := os.OpenFile(strings.TrimPrefix(name, "file:"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, outbox.NewWriterSink(f))
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,57 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// NotifyChannel is the Postgres channel NotifySink announces events on.
+const NotifyChannel = "outbox_events"
+
+// NotifySink announces each event on NotifyChannel, so that every instance
+// sharing the database hears of it, not only the one whose relay holds the
+// lock. Announcements are limited to 8000 bytes, far more than a user needs.
+type NotifySink struct {
+	DB *sql.DB
+}
+
+func (s *NotifySink) Publish(ctx context.Context, event Event) error {
+	payload, err := marshalEvent(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotifyChannel, string(payload))
+	return err
+}
+
+// Listen hands the events announced on NotifyChannel to sink until ctx is
+// done or notifications is closed. notifications is the Notify channel of a
+// pq.Listener listening on NotifyChannel; events announced while it is
+// reconnecting are missed, so sink must cope with gaps as well as
+// duplicates.
+func Listen(ctx context.Context, notifications <-chan *pq.Notification, sink Sink) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			// A pq.Listener sends nil after reconnecting.
+			if n == nil {
+				continue
+			}
+			event, err := unmarshalEvent([]byte(n.Extra))
+			if err == nil {
+				err = sink.Publish(ctx, event)
+			}
+			if err != nil {
+				log.Printf("outbox: %v", err)
+			}
+		}
+	}
+}
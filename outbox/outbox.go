@@ -0,0 +1,182 @@
+// Package outbox relays the events that user writes record in the outbox
+// table to sinks such as the events broker and the webhook queue.
+//
+// Events are written in the same statement as the change they describe, so
+// an event exists exactly when its change was committed. The relay hands
+// events to every sink in order and marks them published afterwards; a crash
+// in between means they are handed over again, so sinks see each event at
+// least once and should use its Id to drop duplicates.
+//
+// Events are ordered by the transaction that wrote them, then by Sequence.
+// The relay waits until every transaction older than an event's has ended
+// before publishing it, so no event can commit ahead of one already
+// published. A transaction left open holds back every event written after
+// it started.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnknownEvent is returned by PublishedAfter for an id that is not of a
+// published event.
+var ErrUnknownEvent = errors.New("unknown event")
+
+// lockKey is the advisory lock that keeps relays sharing a database from
+// publishing the same events concurrently and out of order.
+const lockKey = 0x6f7574626f78
+
+type Event struct {
+	// Transaction is the id of the transaction that wrote the event.
+	Transaction uint64
+	// Sequence orders the events of a transaction by the time they were
+	// written.
+	Sequence int64
+	// Id is unique to the event and stays the same if it is published again.
+	Id         string
+	Type       string
+	Payload    []byte
+	OccurredAt time.Time
+}
+
+// Sink receives published events. An error stops the relay, which hands the
+// same event over again on its next run.
+type Sink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Relay publishes unpublished events to its sinks in order. Only one relay
+// at a time publishes from a database; the others wait their turn.
+type Relay struct {
+	DB        *sql.DB
+	Sinks     []Sink
+	BatchSize int
+}
+
+func NewRelay(db *sql.DB, sinks ...Sink) *Relay {
+	return &Relay{DB: db, Sinks: sinks, BatchSize: 100}
+}
+
+// eventColumns are read by scanEvents.
+const eventColumns = "xid::text, id, event_id, event_type, payload, created_at"
+
+func (r *Relay) pending(tx *sql.Tx) ([]Event, error) {
+	// Transactions older than the snapshot's xmin have all ended, so their
+	// events are final.
+	rows, err := tx.Query(`SELECT `+eventColumns+` FROM outbox
+		WHERE published_at IS NULL AND xid < pg_snapshot_xmin(pg_current_snapshot())
+		ORDER BY xid, id LIMIT $1`, r.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// PublishedAfter returns up to limit of the events published after the one
+// with the given id, in the order they were published. It returns
+// ErrUnknownEvent if no published event has that id.
+func PublishedAfter(db *sql.DB, id string, limit int) ([]Event, error) {
+	var xid string
+	var sequence int64
+	err := db.QueryRow("SELECT xid::text, id FROM outbox WHERE event_id=$1 AND published_at IS NOT NULL", id).Scan(&xid, &sequence)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownEvent
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT `+eventColumns+` FROM outbox
+		WHERE published_at IS NOT NULL AND (xid, id) > ($1::xid8, $2)
+		ORDER BY xid, id LIMIT $3`, xid, sequence, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var payload string
+		if err := rows.Scan(&e.Transaction, &e.Sequence, &e.Id, &e.Type, &payload, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		e.Payload = []byte(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RunOnce publishes the next batch of events and returns how many it
+// published. When a sink fails, the events before the failing one are still
+// marked published.
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", lockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	events, err := r.pending(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var published []int64
+	var publishErr error
+	for _, event := range events {
+		for _, sink := range r.Sinks {
+			if publishErr = sink.Publish(ctx, event); publishErr != nil {
+				break
+			}
+		}
+		if publishErr != nil {
+			break
+		}
+		published = append(published, event.Sequence)
+	}
+	if len(published) > 0 {
+		if _, err := tx.Exec("UPDATE outbox SET published_at=now() WHERE id = ANY($1)", pq.Array(published)); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+// Run publishes events every interval until ctx is done. A full batch is
+// followed straight away by the next one.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := r.RunOnce(ctx)
+		if err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		if n == r.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
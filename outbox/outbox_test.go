@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/events"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+var occurredAt = time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+
+func expectPending(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("pg_try_advisory_xact_lock").WillReturnRows(mock.NewRows([]string{"locked"}).AddRow(true))
+	rows := mock.NewRows([]string{"xid", "id", "event_id", "event_type", "payload", "created_at"}).
+		AddRow("740", 1, "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", "user.created", `{"id" : 1, "name" : "Kaladin", "email" : "k@s.com"}`, occurredAt).
+		AddRow("741", 2, "9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b", "user.deleted", `{"id" : 1, "name" : "Kaladin", "email" : "k@s.com"}`, occurredAt)
+	mock.ExpectQuery("SELECT (.+) FROM outbox WHERE published_at IS NULL AND xid < pg_snapshot_xmin\\(pg_current_snapshot\\(\\)\\) ORDER BY xid, id").
+		WithArgs(100).WillReturnRows(rows)
+}
+
+type failingSink struct {
+	failOn int64
+}
+
+func (s failingSink) Publish(ctx context.Context, event Event) error {
+	if event.Sequence == s.failOn {
+		return errors.New("sink unavailable")
+	}
+	return nil
+}
+
+func TestRunOncePublishesInOrder(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	var out bytes.Buffer
+
+	expectPending(mock)
+	mock.ExpectExec("UPDATE outbox SET published_at").WithArgs("{1,2}").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	n, err := NewRelay(db, NewWriterSink(&out)).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t,
+		`{"id":"4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10","transaction":740,"sequence":1,"type":"user.created","occurred_at":"2020-09-01T12:00:00Z","user":{"id":1,"name":"Kaladin","email":"k@s.com"}}`+"\n"+
+			`{"id":"9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b","transaction":741,"sequence":2,"type":"user.deleted","occurred_at":"2020-09-01T12:00:00Z","user":{"id":1,"name":"Kaladin","email":"k@s.com"}}`+"\n",
+		out.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceStopsAtFailingSink(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	expectPending(mock)
+	mock.ExpectExec("UPDATE outbox SET published_at").WithArgs("{1}").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := NewRelay(db, failingSink{failOn: 2}).RunOnce(context.Background())
+
+	require.EqualError(t, err, "sink unavailable")
+	require.Equal(t, 1, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceWaitsForLock(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("pg_try_advisory_xact_lock").WillReturnRows(mock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectRollback()
+
+	n, err := NewRelay(db, failingSink{}).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBrokerSinkSkipsRepublishedEvents(t *testing.T) {
+	broker := events.NewBroker()
+	received, cancel := broker.Subscribe()
+	defer cancel()
+	sink := &BrokerSink{Broker: broker}
+	event := Event{Transaction: 740, Sequence: 2, Id: "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", Type: events.UserCreated, Payload: []byte(`{"id" : 1, "name" : "Kaladin", "email" : "k@s.com"}`)}
+	later := Event{Transaction: 741, Sequence: 1, Id: "9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b", Type: events.UserCreated, Payload: event.Payload}
+
+	require.NoError(t, sink.Publish(context.Background(), event))
+	require.NoError(t, sink.Publish(context.Background(), event))
+	// Events are published in the order of the transactions that wrote
+	// them, so one with a lower sequence may still be new.
+	require.NoError(t, sink.Publish(context.Background(), later))
+
+	first := <-received
+	require.Equal(t, event.Id, first.Id)
+	require.Equal(t, uint64(740), first.Transaction)
+	require.Equal(t, `{"id":1,"name":"Kaladin","email":"k@s.com"}`, string(first.Data))
+	require.Equal(t, "Kaladin", first.User.Name)
+	require.Equal(t, later.Id, (<-received).Id)
+	require.Len(t, received, 0)
+}
+
+func TestBrokerSinkForgetsOldIds(t *testing.T) {
+	sink := &BrokerSink{Broker: events.NewBroker()}
+	for i := 0; i < brokerSinkRecentIds+1; i++ {
+		require.NoError(t, sink.Publish(context.Background(), Event{Id: fmt.Sprint(i), Type: events.UserCreated, Payload: []byte(`{}`)}))
+	}
+
+	require.Len(t, sink.seen, brokerSinkRecentIds)
+	require.False(t, sink.seen["0"])
+	require.True(t, sink.seen[fmt.Sprint(brokerSinkRecentIds)])
+}
+
+func TestNotifySinkAnnouncesEvent(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	event := Event{Transaction: 740, Sequence: 1, Id: "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", Type: events.UserCreated, Payload: []byte(`{"id":1,"name":"Kaladin","email":"k@s.com"}`), OccurredAt: occurredAt}
+
+	mock.ExpectExec("SELECT pg_notify").WithArgs(NotifyChannel,
+		`{"id":"4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10","transaction":740,"sequence":1,"type":"user.created","occurred_at":"2020-09-01T12:00:00Z","user":{"id":1,"name":"Kaladin","email":"k@s.com"}}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, (&NotifySink{DB: db}).Publish(context.Background(), event))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListenFeedsSink(t *testing.T) {
+	broker := events.NewBroker()
+	received, cancel := broker.Subscribe()
+	defer cancel()
+	notifications := make(chan *pq.Notification, 4)
+	announcement := `{"id":"4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10","transaction":740,"sequence":1,"type":"user.created","occurred_at":"2020-09-01T12:00:00Z","user":{"id":1,"name":"Kaladin","email":"k@s.com"}}`
+	notifications <- &pq.Notification{Channel: NotifyChannel, Extra: announcement}
+	notifications <- nil
+	notifications <- &pq.Notification{Channel: NotifyChannel, Extra: "not json"}
+	notifications <- &pq.Notification{Channel: NotifyChannel, Extra: announcement}
+	close(notifications)
+
+	Listen(context.Background(), notifications, &BrokerSink{Broker: broker})
+
+	require.Len(t, received, 1)
+	event := <-received
+	require.Equal(t, events.UserCreated, event.Type)
+	require.Equal(t, uint64(740), event.Transaction)
+	require.Equal(t, "Kaladin", event.User.Name)
+}
+
+func TestPublishedAfter(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT xid::text, id FROM outbox WHERE event_id=\\$1 AND published_at IS NOT NULL").
+		WithArgs("4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10").WillReturnRows(mock.NewRows([]string{"xid", "id"}).AddRow("740", 1))
+	mock.ExpectQuery("SELECT (.+) FROM outbox WHERE published_at IS NOT NULL AND \\(xid, id\\) > \\(\\$1::xid8, \\$2\\) ORDER BY xid, id LIMIT \\$3").
+		WithArgs("740", 1, 10).
+		WillReturnRows(mock.NewRows([]string{"xid", "id", "event_id", "event_type", "payload", "created_at"}).
+			AddRow("741", 2, "9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b", "user.deleted", `{}`, occurredAt))
+
+	published, err := PublishedAfter(db, "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", 10)
+
+	require.NoError(t, err)
+	require.Len(t, published, 1)
+	require.Equal(t, "9b2e7f3a-1c4d-4e5f-8a6b-7c8d9e0f1a2b", published[0].Id)
+	require.Equal(t, uint64(741), published[0].Transaction)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublishedAfterUnknownEvent(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT xid::text, id FROM outbox").WillReturnRows(mock.NewRows([]string{"xid", "id"}))
+
+	_, err := PublishedAfter(db, "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", 10)
+
+	require.Equal(t, ErrUnknownEvent, err)
+}
@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tammiec/go-rest-api/events"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/webhooks"
+)
+
+// brokerSinkRecentIds is how many event ids a BrokerSink remembers to drop
+// duplicates. Events are handed over again at most a batch later, so any
+// number well above Relay.BatchSize will do.
+const brokerSinkRecentIds = 1000
+
+// BrokerSink feeds an in-process events broker. Only one instance's relay
+// publishes each event, so instances sharing a database feed their brokers
+// from a NotifySink's announcements with Listen rather than from the relay.
+type BrokerSink struct {
+	Broker *events.Broker
+
+	mu sync.Mutex
+	// seen holds the ids of the recent events, which recent lists oldest
+	// first.
+	seen   map[string]bool
+	recent []string
+}
+
+func (s *BrokerSink) Publish(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Events handed over again after a failed run have already been sent to
+	// the broker's subscribers.
+	if s.seen[event.Id] {
+		return nil
+	}
+	userEvent, err := UserEvent(event)
+	if err != nil {
+		return err
+	}
+	s.Broker.Publish(userEvent)
+	s.remember(event.Id)
+	return nil
+}
+
+// UserEvent is the broker's event for an event about a user.
+func UserEvent(event Event) (events.Event, error) {
+	user := &model.User{}
+	if err := json.Unmarshal(event.Payload, user); err != nil {
+		return events.Event{}, err
+	}
+	return events.NewUserEvent(event.Id, event.Transaction, event.Sequence, event.Type, user)
+}
+
+func (s *BrokerSink) remember(id string) {
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	s.seen[id] = true
+	s.recent = append(s.recent, id)
+	if len(s.recent) > brokerSinkRecentIds {
+		delete(s.seen, s.recent[0])
+		s.recent = s.recent[1:]
+	}
+}
+
+// WebhookSink queues deliveries to the webhooks subscribed to each event.
+type WebhookSink struct {
+	DB *sql.DB
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, event Event) error {
+	return webhooks.Enqueue(s.DB, event.Id, event.Type, event.OccurredAt, event.Payload)
+}
+
+// WriterSink writes each event as a line of JSON, for shipping to a log
+// pipeline or keeping in a file.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// eventJSON is how WriterSink writes events and NotifySink announces them.
+type eventJSON struct {
+	Id          string          `json:"id"`
+	Transaction uint64          `json:"transaction"`
+	Sequence    int64           `json:"sequence"`
+	Type        string          `json:"type"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	User        json.RawMessage `json:"user"`
+}
+
+func marshalEvent(event Event) ([]byte, error) {
+	return json.Marshal(eventJSON{
+		Id:          event.Id,
+		Transaction: event.Transaction,
+		Sequence:    event.Sequence,
+		Type:        event.Type,
+		OccurredAt:  event.OccurredAt.UTC(),
+		User:        event.Payload,
+	})
+}
+
+func unmarshalEvent(data []byte) (Event, error) {
+	var e eventJSON
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Event{}, err
+	}
+	return Event{Transaction: e.Transaction, Sequence: e.Sequence, Id: e.Id, Type: e.Type, Payload: e.User, OccurredAt: e.OccurredAt}, nil
+}
+
+func (s *WriterSink) Publish(ctx context.Context, event Event) error {
+	line, err := marshalEvent(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
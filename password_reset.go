@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tammiec/go-rest-api/mailer"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+var (
+	passwordResetTTL = time.Hour
+	// passwordResetURL, when set, is the page where users choose their new
+	// password; the token is appended to it in the email.
+	passwordResetURL = ""
+)
+
+// newToken returns a random token to send to a user.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type passwordResetInput struct {
+	Email    string `json:"email" xml:"email" yaml:"email"`
+	Token    string `json:"token" xml:"token" yaml:"token"`
+	Password string `json:"password" xml:"password" yaml:"password"`
+}
+
+// parsePasswordReset reads the body only, like parseLogin.
+func parsePasswordReset(r *http.Request) (passwordResetInput, error) {
+	if isForm(r) {
+		return passwordResetInput{Email: r.PostFormValue("email"), Token: r.PostFormValue("token"), Password: r.PostFormValue("password")}, nil
+	}
+	input := passwordResetInput{}
+	err := decodeBody(r, &input)
+	return input, err
+}
+
+func passwordResetMessage(user *model.User, token string) mailer.Message {
+	if passwordResetURL != "" {
+		token = passwordResetURL + token
+	}
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, use this within %s to choose a new one:\n\n%s\n\nIf it was not, you can ignore this email.\n",
+			user.Name, passwordResetTTL, token),
+	}
+}
+
+// passwordResetHandler emails a reset token to the user with the given
+// email. It answers 202 whether or not there is such a user, so that it
+// cannot be used to find out.
+func passwordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	input, err := parsePasswordReset(r)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	user, err := model.CreatePasswordReset(db, input.Email, token, passwordResetTTL)
+	switch err {
+	case nil:
+		sendMail(passwordResetMessage(user, token))
+	case sql.ErrNoRows:
+	default:
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmPasswordResetHandler sets a new password for the user a reset token
+// was sent to, and lifts any lock on their account.
+func confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	input, err := parsePasswordReset(r)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var user *model.User
+	id, err := model.ConsumePasswordReset(tx, input.Token)
+	if err == nil {
+		user, err = model.SetPassword(tx, requestAudit(r.Context()), id, input.Password)
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		log.Println(rollbackErr)
+	}
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	if err := model.ClearLoginFailures(db, model.AccountLoginKey(user.Email)); err != nil {
+		log.Println(err)
+	}
+	marshalAndWrite(renderUser(r, user), w, r)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/mailer"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func withMemoryMailer(t *testing.T) *mailer.MemoryMailer {
+	m := userMailer
+	memory := mailer.NewMemoryMailer()
+	userMailer = memory
+	t.Cleanup(func() { userMailer = m })
+	return memory
+}
+
+func TestPasswordResetEmailsToken(t *testing.T) {
+	mail := withMemoryMailer(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO password_reset_tokens")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs("k@s.com", sqlmock.AnyArg(), int64(3600000)).WillReturnRows(rows)
+
+	_, resp, err := postForm(router, "http://localhost:1234/auth/password-reset", "email=k@s.com")
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Eventually(t, func() bool { return len(mail.Messages()) == 1 }, time.Second, time.Millisecond)
+	msg := mail.Messages()[0]
+	require.Equal(t, "k@s.com", msg.To)
+	require.Equal(t, "Reset your password", msg.Subject)
+	require.Regexp(t, "\n\n[A-Za-z0-9_-]{43}\n\n", msg.Body)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPasswordResetForUnknownEmailLooksTheSame(t *testing.T) {
+	mail := withMemoryMailer(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO password_reset_tokens")
+	mock.ExpectQuery("WITH").WillReturnError(sql.ErrNoRows)
+
+	body, resp, err := postForm(router, "http://localhost:1234/auth/password-reset", "email=nobody@s.com")
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Empty(t, body)
+	require.Empty(t, mail.Messages())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE password_reset_tokens")
+	mock.ExpectQuery("WITH").WithArgs(model.HashToken("secret")).WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectPrepare("UPDATE users SET password")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(hashOf("new-password"), 1, "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+	mock.ExpectCommit()
+	mock.ExpectPrepare("DELETE FROM login_failures")
+	mock.ExpectExec("DELETE").WithArgs("email:k@s.com").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body, resp, err := postForm(router, "http://localhost:1234/auth/password-reset/confirm", "token=secret&password=new-password")
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Contains(t, string(body), `"email":"k@s.com"`)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmPasswordResetRejectsBadTokenAndPassword(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE password_reset_tokens")
+	mock.ExpectQuery("WITH").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE password_reset_tokens")
+	mock.ExpectQuery("WITH").WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	body, resp, err := postForm(router, "http://localhost:1234/auth/password-reset/confirm", "token=used&password=new-password")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.True(t, strings.Contains(string(body), `"field":"token"`), string(body))
+
+	body, resp, err = postForm(router, "http://localhost:1234/auth/password-reset/confirm", "token=secret&password=short")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), `"field":"password"`)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/ratelimit"
+	"github.com/tammiec/go-rest-api/rpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var (
+	// rateLimits are the limits of each route. The zero value limits nothing.
+	rateLimits     ratelimit.Rules
+	rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	// rateLimitKeyHeader names a header carrying the caller's API key, and
+	// rateLimitIPHeader one carrying the client address when the API is
+	// behind a proxy. Like the actor header, both must be set by the proxy
+	// and not taken from the client as is.
+	rateLimitKeyHeader = ""
+	rateLimitIPHeader  = ""
+)
+
+// defaultRateLimits is the RATE_LIMITS used when the variable is not set.
+const defaultRateLimits = "default=600/1m,POST /users=60/1m,/users/import=10/1h,/auth/password-reset=10/1h,/auth/verify/resend=10/1h"
+
+// rateLimitClient identifies the caller of r by API key, then by actor, then
+// by address. API keys are hashed so that they are not kept in the store.
+func rateLimitClient(r *http.Request) string {
+	var key string
+	if rateLimitKeyHeader != "" {
+		key = r.Header.Get(rateLimitKeyHeader)
+	}
+	return rateLimitClientOf(key, requestAudit(r.Context()).Actor, clientIP(r))
+}
+
+// grpcRateLimitClient identifies the caller of a gRPC call like
+// rateLimitClient, reading the headers from the call's metadata.
+func grpcRateLimitClient(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		if values := md.Get(name); len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+		return ""
+	}
+	ip := header(rateLimitIPHeader)
+	if ip == "" {
+		if p, ok := peer.FromContext(ctx); ok {
+			ip = p.Addr.String()
+			if host, _, err := net.SplitHostPort(ip); err == nil {
+				ip = host
+			}
+		}
+	}
+	return rateLimitClientOf(header(rateLimitKeyHeader), rpc.Actor(ctx), ip)
+}
+
+func rateLimitClientOf(key string, actor string, ip string) string {
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	if actor != anonymousActor {
+		return "user:" + actor
+	}
+	return "ip:" + ip
+}
+
+// clientIP returns the address of the client making r.
+func clientIP(r *http.Request) string {
+	if rateLimitIPHeader != "" {
+		if ip := strings.TrimSpace(r.Header.Get(rateLimitIPHeader)); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitRoute returns the path template of the route r matched, without
+// its version prefix, so that every version of a route shares its limit.
+func rateLimitRoute(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	path := specPath(template)
+	for _, v := range apiVersions {
+		if strings.HasPrefix(path, "/"+v.name+"/") {
+			return strings.TrimPrefix(path, "/"+v.name)
+		}
+	}
+	return path
+}
+
+// ceilSeconds rounds d up to whole seconds, as the RateLimit headers need.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimited meters each client's requests to each route against
+// rateLimits, reporting the client's quota in RateLimit headers and turning
+// away requests over it with 429. The readiness probe is never limited, and a
+// store that fails lets requests through rather than take the API down.
+func rateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := rateLimitRoute(r)
+		if route == "/readiness" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rule, limit, ok := rateLimits.For(r.Method, route)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result, err := rateLimitStore.Take(r.Context(), rateLimitClient(r)+"\x00"+rule, limit)
+		if err != nil {
+			log.Println(err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Requests, ceilSeconds(limit.Period)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+			writeProblem(w, r, http.StatusTooManyRequests, "rate limit exceeded", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcRateLimited meters gRPC calls like rateLimited, against the limits of
+// the routes they mirror, failing calls over the limit with ResourceExhausted
+// and a RetryInfo detail.
+func grpcRateLimited(ctx context.Context, fullMethod string) error {
+	route, ok := grpcRoutes[fullMethod]
+	if !ok {
+		return nil
+	}
+	rule, limit, ok := rateLimits.For(route.method, route.path)
+	if !ok {
+		return nil
+	}
+	result, err := rateLimitStore.Take(ctx, grpcRateLimitClient(ctx)+"\x00"+rule, limit)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if !result.Allowed {
+		st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(result.RetryAfter)}); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	return nil
+}
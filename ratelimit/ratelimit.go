@@ -0,0 +1,220 @@
+// Package ratelimit meters requests with token buckets: each client and
+// route has a bucket holding up to Limit.Requests tokens, refilled evenly
+// over Limit.Period, and a request is allowed when it can take a token.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// perSecond is the rate at which the bucket refills.
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is how many more requests the bucket allows right now.
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// newResult describes a bucket left with tokens after a take.
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.perSecond()
+	r := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return r
+}
+
+// Store holds the buckets.
+type Store interface {
+	// Take takes a token from the bucket for key, if it has one.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// expires is when the bucket is full again, a period after it was last
+	// updated; a full bucket can be dropped and made afresh.
+	expires time.Time
+}
+
+// MemoryStore keeps buckets in this process, so each instance meters the
+// requests it serves on its own.
+type MemoryStore struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lastPruned time.Time
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPruned) < time.Minute {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.After(b.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastPruned = now
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*limit.perSecond())
+	b.updated = now
+	b.expires = now.Add(limit.Period)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(limit, b.tokens, allowed), nil
+}
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so that
+// instances sharing the database share their clients' quotas.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// refilled is the bucket's tokens after refilling, with $2 the capacity and
+// $3 the refill rate per second.
+const refilled = "LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM now() - rate_limit_buckets.updated_at) * $3)"
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	var tokens float64
+	var allowed bool
+	err := s.DB.QueryRowContext(ctx, `INSERT INTO rate_limit_buckets AS rate_limit_buckets (key, tokens, allowed, updated_at, expires_at)
+		VALUES ($1, $2 - 1, true, now(), now() + $4 * interval '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN `+refilled+` >= 1 THEN `+refilled+` - 1 ELSE `+refilled+` END,
+			allowed = `+refilled+` >= 1,
+			updated_at = now(),
+			expires_at = EXCLUDED.expires_at
+		RETURNING tokens, allowed`, key, limit.Requests, limit.perSecond(), limit.Period.Milliseconds()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(limit, tokens, allowed), nil
+}
+
+// Run deletes buckets that are full again, having been idle for their
+// period, every interval until ctx is done.
+func (s *PostgresStore) Run(ctx context.Context, interval time.Duration) {
+	for {
+		_, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE expires_at < now()")
+		if err != nil {
+			log.Printf("ratelimit: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Rules are the limits for each route, with a default for the rest.
+type Rules struct {
+	Default *Limit
+	// Routes is keyed by "METHOD /path" or, for every method, "/path".
+	Routes map[string]Limit
+}
+
+// For returns the rule that limits a route and its limit, or false if the
+// route is not limited. Routes without a rule of their own share the default
+// rule, named "default".
+func (rules Rules) For(method string, path string) (string, Limit, bool) {
+	for _, name := range []string{method + " " + path, path} {
+		if limit, ok := rules.Routes[name]; ok {
+			return name, limit, true
+		}
+	}
+	if rules.Default != nil {
+		return "default", *rules.Default, true
+	}
+	return "", Limit{}, false
+}
+
+// ParseRules reads comma-separated rules such as
+//
+//	default=300/1m,GET /users/{id}=60/1m,/users/import=5/1h
+//
+// where each limit is a number of requests per duration.
+func ParseRules(spec string) (Rules, error) {
+	rules := Rules{Routes: map[string]Limit{}}
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		eq := strings.LastIndex(rule, "=")
+		if eq < 0 {
+			return Rules{}, fmt.Errorf("rule %q: want ROUTE=REQUESTS/PERIOD", rule)
+		}
+		route, value := strings.TrimSpace(rule[:eq]), rule[eq+1:]
+		slash := strings.Index(value, "/")
+		if slash < 0 {
+			return Rules{}, fmt.Errorf("rule %q: want ROUTE=REQUESTS/PERIOD", rule)
+		}
+		requests, err := strconv.Atoi(value[:slash])
+		if err != nil || requests < 1 {
+			return Rules{}, fmt.Errorf("rule %q: requests must be a positive integer", rule)
+		}
+		period, err := time.ParseDuration(value[slash+1:])
+		if err != nil || period <= 0 {
+			return Rules{}, fmt.Errorf("rule %q: period must be a positive duration", rule)
+		}
+		limit := Limit{Requests: requests, Period: period}
+		if route == "default" {
+			rules.Default = &limit
+		} else {
+			rules.Routes[route] = limit
+		}
+	}
+	return rules, nil
+}
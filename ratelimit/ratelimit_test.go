@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+func TestMemoryStoreEmptiesAndRefillsBucket(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	result, err := s.Take(ctx, "k", limit)
+	require.NoError(t, err)
+	require.Equal(t, Result{Allowed: true, Remaining: 1, Reset: 30 * time.Second}, result)
+	s.Take(ctx, "k", limit)
+
+	result, err = s.Take(ctx, "k", limit)
+	require.NoError(t, err)
+	require.Equal(t, Result{Allowed: false, Remaining: 0, RetryAfter: 30 * time.Second, Reset: time.Minute}, result)
+
+	result, err = s.Take(ctx, "other", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	now = now.Add(30 * time.Second)
+	result, err = s.Take(ctx, "k", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryStoreKeepsBucketsForTheirPeriod(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	daily := Limit{Requests: 1, Period: 24 * time.Hour}
+
+	s.Take(ctx, "daily", daily)
+	s.Take(ctx, "minutely", Limit{Requests: 1, Period: time.Minute})
+
+	now = now.Add(2 * time.Hour)
+	result, err := s.Take(ctx, "daily", daily)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.NotContains(t, s.buckets, "minutely")
+
+	// A day after its last request, the bucket is full and dropped.
+	now = now.Add(24*time.Hour + time.Minute)
+	s.Take(ctx, "other", daily)
+	require.NotContains(t, s.buckets, "daily")
+}
+
+func TestPostgresStoreTakesToken(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO rate_limit_buckets .+ ON CONFLICT \\(key\\) DO UPDATE .+ RETURNING tokens, allowed").
+		WithArgs("k", 2, 2.0/60, int64(60000)).WillReturnRows(mock.NewRows([]string{"tokens", "allowed"}).AddRow(1.0, true))
+
+	result, err := NewPostgresStore(db).Take(context.Background(), "k", Limit{Requests: 2, Period: time.Minute})
+
+	require.NoError(t, err)
+	require.Equal(t, Result{Allowed: true, Remaining: 1, Reset: 30 * time.Second}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStoreRefusesEmptyBucket(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO rate_limit_buckets").
+		WillReturnRows(mock.NewRows([]string{"tokens", "allowed"}).AddRow(0.5, false))
+
+	result, err := NewPostgresStore(db).Take(context.Background(), "k", Limit{Requests: 2, Period: time.Minute})
+
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 15*time.Second, result.RetryAfter)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("default=300/1m, GET /users/{id}=60/1m,/users/import=5/1h")
+
+	require.NoError(t, err)
+	require.Equal(t, &Limit{Requests: 300, Period: time.Minute}, rules.Default)
+	name, limit, ok := rules.For("GET", "/users/{id}")
+	require.True(t, ok)
+	require.Equal(t, "GET /users/{id}", name)
+	require.Equal(t, Limit{Requests: 60, Period: time.Minute}, limit)
+	name, limit, ok = rules.For("POST", "/users/import")
+	require.True(t, ok)
+	require.Equal(t, "/users/import", name)
+	require.Equal(t, Limit{Requests: 5, Period: time.Hour}, limit)
+	name, _, ok = rules.For("DELETE", "/users/{id}")
+	require.True(t, ok)
+	require.Equal(t, "default", name)
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, spec := range []string{"default", "default=10", "default=0/1m", "default=x/1m", "default=10/soon", "default=10/-1s"} {
+		_, err := ParseRules(spec)
+		require.Error(t, err, spec)
+	}
+	rules, err := ParseRules("")
+	require.NoError(t, err)
+	_, _, ok := rules.For("GET", "/users")
+	require.False(t, ok)
+}
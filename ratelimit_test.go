@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/ratelimit"
+	"github.com/tammiec/go-rest-api/userspb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withRateLimits(t *testing.T, spec string) {
+	rules, store, keyHeader := rateLimits, rateLimitStore, rateLimitKeyHeader
+	var err error
+	rateLimits, err = ratelimit.ParseRules(spec)
+	require.NoError(t, err)
+	rateLimitStore = ratelimit.NewMemoryStore()
+	t.Cleanup(func() { rateLimits, rateLimitStore, rateLimitKeyHeader = rules, store, keyHeader })
+}
+
+const specURL = "http://localhost:1234/openapi.json"
+
+func TestRateLimitRejectsRequestsOverQuota(t *testing.T) {
+	withRateLimits(t, "default=2/1m")
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	_, resp, err := httpRequest(router, http.MethodGet, specURL, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	require.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, "30", resp.Header.Get("RateLimit-Reset"))
+	require.Equal(t, "2;w=60", resp.Header.Get("RateLimit-Policy"))
+	httpRequest(router, http.MethodGet, specURL, nil)
+
+	body, resp, err := httpRequest(router, http.MethodGet, specURL, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "30", resp.Header.Get("Retry-After"))
+	require.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(body), "rate limit exceeded")
+
+	_, resp, err = httpRequest(router, http.MethodGet, "http://localhost:1234/readiness", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestRateLimitKeepsClientsApart(t *testing.T) {
+	withRateLimits(t, "default=1/1m")
+	rateLimitKeyHeader = "X-API-Key"
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	for _, headers := range []map[string]string{
+		nil,
+		{"X-Forwarded-User": "kaladin"},
+		{"X-Forwarded-User": "kaladin", "X-API-Key": "bridge-four"},
+		{"X-API-Key": "cobalt-guard"},
+	} {
+		_, resp, err := httpRequest(router, http.MethodGet, specURL, headers)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, headers)
+		_, resp, err = httpRequest(router, http.MethodGet, specURL, headers)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode, headers)
+	}
+}
+
+func TestRateLimitIsPerRouteAndSharedAcrossVersions(t *testing.T) {
+	withRateLimits(t, "GET /users/{id}=1/1m")
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v1/users/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, resp, err = httpRequest(router, http.MethodGet, "http://localhost:1234/users/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	_, resp, err = httpRequest(router, http.MethodGet, specURL, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRateLimitSharesQuotaWithGRPC(t *testing.T) {
+	withRateLimits(t, "GET /users/{id}=1/1m")
+	db, mock, client := getMockDBAndGRPC(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kaladin", "k@s.com", 1))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-forwarded-user", "dalinar")
+	_, err := client.GetUser(ctx, &userspb.GetUserRequest{Id: 1})
+	require.NoError(t, err)
+
+	_, err = client.GetUser(ctx, &userspb.GetUserRequest{Id: 1})
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	delay := st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration()
+	require.True(t, delay > 59*time.Second && delay <= time.Minute, delay)
+
+	// Other methods have quotas of their own.
+	_, err = client.DeleteUser(ctx, &userspb.DeleteUserRequest{Id: 1})
+	require.NotEqual(t, codes.ResourceExhausted, status.Code(err))
+}
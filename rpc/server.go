@@ -0,0 +1,166 @@
+// Package rpc serves the user operations over gRPC, alongside the HTTP API
+// and backed by the same store.
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/userspb"
+	"github.com/tammiec/go-rest-api/validation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements userspb.UserServiceServer.
+type Server struct {
+	userspb.UnimplementedUserServiceServer
+	db *sql.DB
+}
+
+func NewServer(db *sql.DB) *Server {
+	return &Server{db: db}
+}
+
+// Register adds the user service to s, together with the standard health
+// and reflection services.
+func Register(s *grpc.Server, db *sql.DB) {
+	userspb.RegisterUserServiceServer(s, NewServer(db))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(userspb.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	reflection.Register(s)
+}
+
+// ActorMetadataKey names the metadata that carries the caller's identity for
+// the audit log, like the actor header of the HTTP API.
+var ActorMetadataKey = "x-forwarded-user"
+
+// Actor returns the caller named by the request metadata, or "anonymous".
+func Actor(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(ActorMetadataKey); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return "anonymous"
+}
+
+// audit identifies the caller from the request metadata, generating a
+// request id when the caller did not send one.
+func audit(ctx context.Context) model.Audit {
+	a := model.Audit{Actor: Actor(ctx)}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("x-request-id"); len(values) > 0 && values[0] != "" {
+		a.RequestId = values[0]
+	} else {
+		b := make([]byte, 16)
+		rand.Read(b)
+		a.RequestId = hex.EncodeToString(b)
+	}
+	return a
+}
+
+func toProto(user *model.User) *userspb.User {
+	return &userspb.User{Id: int64(user.Id), Name: user.Name, Email: user.Email, Version: int64(user.Version)}
+}
+
+// statusError maps model errors to gRPC status codes, mirroring the HTTP
+// handlers: missing users are NotFound, validation failures are
+// InvalidArgument with a BadRequest detail per field, duplicate emails are
+// AlreadyExists and users no longer at the expected version are
+// FailedPrecondition.
+func statusError(err error) error {
+	var fields validation.Errors
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.As(err, &fields):
+		return fieldStatus(codes.InvalidArgument, "invalid user", fields)
+	case errors.Is(err, model.ErrEmailTaken):
+		return fieldStatus(codes.AlreadyExists, err.Error(), validation.Errors{{Field: "email", Code: "taken"}})
+	case errors.Is(err, model.ErrVersionConflict):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		log.Println(err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func fieldStatus(code codes.Code, message string, fields validation.Errors) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, f := range fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       f.Field,
+			Description: f.Message(validation.DefaultLocale),
+		})
+	}
+	st, err := status.New(code, message).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
+
+func (s *Server) GetUser(ctx context.Context, req *userspb.GetUserRequest) (*userspb.User, error) {
+	user, err := model.GetUser(s.db, int(req.Id))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return toProto(user), nil
+}
+
+func (s *Server) ListUsers(req *userspb.ListUsersRequest, stream userspb.UserService_ListUsersServer) error {
+	err := model.EachUser(s.db, func(user *model.User) error {
+		return stream.Send(toProto(user))
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return statusError(err)
+	}
+	return nil
+}
+
+func (s *Server) CreateUser(ctx context.Context, req *userspb.CreateUserRequest) (*userspb.User, error) {
+	user, err := model.CreateUser(s.db, audit(ctx), req.Name, req.Email, req.Password)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return toProto(user), nil
+}
+
+func (s *Server) UpdateUser(ctx context.Context, req *userspb.UpdateUserRequest) (*userspb.User, error) {
+	var user *model.User
+	var err error
+	if req.ExpectedVersion != 0 {
+		user, err = model.UpdateUserIfVersion(s.db, audit(ctx), int(req.Id), int(req.ExpectedVersion), req.Name, req.Email, req.Password)
+	} else {
+		user, err = model.UpdateUser(s.db, audit(ctx), int(req.Id), req.Name, req.Email, req.Password)
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return toProto(user), nil
+}
+
+func (s *Server) DeleteUser(ctx context.Context, req *userspb.DeleteUserRequest) (*userspb.User, error) {
+	user, err := model.DeleteUser(s.db, audit(ctx), int(req.Id))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return toProto(user), nil
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/userspb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func getMockDBAndConn(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *grpc.ClientConn) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	Register(srv, db)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return db, mock, conn
+}
+
+func TestGetUser(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
+
+	user, err := userspb.NewUserServiceClient(conn).GetUser(context.Background(), &userspb.GetUserRequest{Id: 1})
+
+	require.NoError(t, err)
+	require.Equal(t, int64(1), user.Id)
+	require.Equal(t, "Kaladin", user.Name)
+	require.Equal(t, "k@s.com", user.Email)
+	require.Equal(t, int64(1), user.Version)
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(7).WillReturnError(sql.ErrNoRows)
+
+	_, err := userspb.NewUserServiceClient(conn).GetUser(context.Background(), &userspb.GetUserRequest{Id: 7})
+
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestListUsersStreams(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	rows.AddRow("2", "Adolin", "a@k.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	stream, err := userspb.NewUserServiceClient(conn).ListUsers(context.Background(), &userspb.ListUsersRequest{})
+	require.NoError(t, err)
+	var names []string
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, user.Name)
+	}
+
+	require.Equal(t, []string{"Kaladin", "Adolin"}, names)
+}
+
+func TestCreateUserInvalid(t *testing.T) {
+	db, _, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	_, err := userspb.NewUserServiceClient(conn).CreateUser(context.Background(),
+		&userspb.CreateUserRequest{Name: "Kaladin", Email: "not an email", Password: "password1"})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	violations := st.Details()[0].(*errdetails.BadRequest).FieldViolations
+	require.Equal(t, "email", violations[0].Field)
+}
+
+func TestCreateUserEmailTaken(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT")
+	mock.ExpectQuery("INSERT").WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_lower_key"})
+
+	_, err := userspb.NewUserServiceClient(conn).CreateUser(context.Background(),
+		&userspb.CreateUserRequest{Name: "Kaladin", Email: "k@s.com", Password: "password1"})
+
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestUpdateUserVersionConflict(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("AND version=\\$5")
+	mock.ExpectQuery("UPDATE").WithArgs("Kaladin", "k@s.com", sqlmock.AnyArg(), 1, 2, "anonymous", sqlmock.AnyArg()).WillReturnError(sql.ErrNoRows)
+	mock.ExpectPrepare("SELECT")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(mock.NewRows([]string{"id", "name", "email", "version"}).AddRow(1, "Kal", "k@s.com", 3))
+
+	_, err := userspb.NewUserServiceClient(conn).UpdateUser(context.Background(),
+		&userspb.UpdateUserRequest{Id: 1, Name: "Kaladin", Email: "k@s.com", Password: "password1", ExpectedVersion: 2})
+
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteUserInternalError(t *testing.T) {
+	db, mock, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("DELETE")
+	mock.ExpectQuery("DELETE").WithArgs(1, "anonymous", sqlmock.AnyArg()).WillReturnError(errors.New("connection reset"))
+
+	_, err := userspb.NewUserServiceClient(conn).DeleteUser(context.Background(), &userspb.DeleteUserRequest{Id: 1})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.Internal, st.Code())
+	require.Equal(t, "internal error", st.Message())
+}
+
+func TestHealth(t *testing.T) {
+	db, _, conn := getMockDBAndConn(t)
+	defer db.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(),
+		&healthpb.HealthCheckRequest{Service: "users.v1.UserService"})
+
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -0,0 +1,177 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultLocale is used when a message is missing from the requested locale.
+const DefaultLocale = "en"
+
+// Messages holds the message template for each rule code, per locale.
+// Templates are passed to fmt.Sprintf with the rule's arguments.
+var Messages = map[string]map[string]string{
+	"en": {
+		"required":   "is required",
+		"min_length": "must be at least %d characters",
+		"max_length": "must be at most %d characters",
+		"max_bytes":  "must be at most %d bytes long",
+		"forbidden":  "must not contain %q",
+		"control":    "must not contain control characters",
+		"email":      "must be a valid email address",
+		"url":        "must be an absolute http or https URL",
+		"public_url": "must not point to a local or private address",
+		"taken":      "is already in use",
+		"type":       "must be of type %s",
+		"enum":       "must be one of %s",
+		"minimum":    "must be at least %d",
+		"maximum":    "must be at most %d",
+		"date_time":  "must be an RFC 3339 date-time",
+		"min_items":  "must have at least %d items",
+		"max_items":  "must have at most %d items",
+		"schema":     "does not match the expected schema",
+		"token":      "is invalid or has expired",
+	},
+	"es": {
+		"required":   "es obligatorio",
+		"min_length": "debe tener al menos %d caracteres",
+		"max_length": "debe tener como máximo %d caracteres",
+		"max_bytes":  "debe ocupar como máximo %d bytes",
+		"forbidden":  "no debe contener %q",
+		"control":    "no debe contener caracteres de control",
+		"email":      "debe ser una dirección de correo válida",
+		"url":        "debe ser una URL http o https absoluta",
+		"public_url": "no debe apuntar a una dirección local o privada",
+		"taken":      "ya está en uso",
+		"type":       "debe ser de tipo %s",
+		"enum":       "debe ser uno de %s",
+		"minimum":    "debe ser al menos %d",
+		"maximum":    "debe ser como máximo %d",
+		"date_time":  "debe ser una fecha y hora RFC 3339",
+		"min_items":  "debe tener al menos %d elementos",
+		"max_items":  "debe tener como máximo %d elementos",
+		"schema":     "no coincide con el esquema esperado",
+		"token":      "no es válido o ha caducado",
+	},
+}
+
+// FieldError is a single failed rule on a single field.
+type FieldError struct {
+	Field string
+	Code  string
+	Args  []interface{}
+}
+
+// Message renders the error in the given locale, falling back to
+// DefaultLocale and then to the bare rule code.
+func (e *FieldError) Message(locale string) string {
+	tmpl, ok := Messages[locale][e.Code]
+	if !ok {
+		tmpl, ok = Messages[DefaultLocale][e.Code]
+	}
+	if !ok {
+		return e.Code
+	}
+	if len(e.Args) == 0 {
+		return tmpl
+	}
+	return fmt.Sprintf(tmpl, e.Args...)
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message(DefaultLocale))
+}
+
+// Errors collects every failed rule of a validation run.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator accumulates field errors so that they can be reported together.
+type Validator struct {
+	errs Errors
+}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+// Add records a failed rule for field.
+func (v *Validator) Add(field string, code string, args ...interface{}) {
+	v.errs = append(v.errs, &FieldError{Field: field, Code: code, Args: args})
+}
+
+// Err returns the collected errors, or nil if every rule passed.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// String starts a chain of rules for a string field. Once a rule fails the
+// remaining rules in the chain are skipped, so each field reports at most one
+// error.
+func (v *Validator) String(field string, value string) *StringRules {
+	return &StringRules{v: v, field: field, value: value}
+}
+
+type StringRules struct {
+	v      *Validator
+	field  string
+	value  string
+	failed bool
+}
+
+func (r *StringRules) check(ok bool, code string, args ...interface{}) *StringRules {
+	if !r.failed && !ok {
+		r.v.Add(r.field, code, args...)
+		r.failed = true
+	}
+	return r
+}
+
+func (r *StringRules) Required() *StringRules {
+	return r.check(strings.TrimSpace(r.value) != "", "required")
+}
+
+func (r *StringRules) MinLength(n int) *StringRules {
+	return r.check(utf8.RuneCountInString(r.value) >= n, "min_length", n)
+}
+
+func (r *StringRules) MaxLength(n int) *StringRules {
+	return r.check(utf8.RuneCountInString(r.value) <= n, "max_length", n)
+}
+
+// MaxBytes limits the length of the value in bytes of UTF-8 rather than in
+// characters, for values stored or hashed by their bytes.
+func (r *StringRules) MaxBytes(n int) *StringRules {
+	return r.check(len(r.value) <= n, "max_bytes", n)
+}
+
+// Forbid fails if the value contains any of the runes in chars.
+func (r *StringRules) Forbid(chars string) *StringRules {
+	i := strings.IndexAny(r.value, chars)
+	if i < 0 {
+		return r
+	}
+	c, _ := utf8.DecodeRuneInString(r.value[i:])
+	return r.check(false, "forbidden", string(c))
+}
+
+func (r *StringRules) NoControl() *StringRules {
+	return r.check(strings.IndexFunc(r.value, unicode.IsControl) < 0, "control")
+}
+
+// Check fails with code when ok is false, for rules that have no builder.
+func (r *StringRules) Check(ok bool, code string, args ...interface{}) *StringRules {
+	return r.check(ok, code, args...)
+}
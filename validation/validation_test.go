@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorOk(t *testing.T) {
+	v := New()
+	v.String("name", "Kaladin").Required().MaxLength(10).Forbid("<>").NoControl()
+
+	require.NoError(t, v.Err())
+}
+
+func TestValidatorAggregatesFields(t *testing.T) {
+	v := New()
+	v.String("name", "").Required().MaxLength(10)
+	v.String("password", "short").Required().MinLength(8)
+
+	err := v.Err()
+	require.Error(t, err)
+	errs := err.(Errors)
+	require.Len(t, errs, 2)
+	require.Equal(t, "name", errs[0].Field)
+	require.Equal(t, "required", errs[0].Code)
+	require.Equal(t, "password", errs[1].Field)
+	require.Equal(t, "name: is required; password: must be at least 8 characters", err.Error())
+}
+
+func TestValidatorStopsAtFirstFailurePerField(t *testing.T) {
+	v := New()
+	v.String("name", "<Kaladin Stormblessed>").MaxLength(10).Forbid("<>")
+
+	require.Len(t, v.Err().(Errors), 1)
+}
+
+func TestValidatorForbid(t *testing.T) {
+	v := New()
+	v.String("name", "Kal<adin").Forbid("<>")
+
+	require.Equal(t, "name: must not contain \"<\"", v.Err().Error())
+}
+
+func TestValidatorMaxBytes(t *testing.T) {
+	v := New()
+	v.String("password", strings.Repeat("é", 4)).MaxLength(4).MaxBytes(7)
+
+	require.Equal(t, "password: must be at most 7 bytes long", v.Err().Error())
+}
+
+func TestValidatorNoControl(t *testing.T) {
+	v := New()
+	v.String("name", "Kal\x00adin").NoControl()
+
+	require.Equal(t, "control", v.Err().(Errors)[0].Code)
+}
+
+func TestFieldErrorMessageLocalized(t *testing.T) {
+	e := &FieldError{Field: "password", Code: "min_length", Args: []interface{}{8}}
+
+	require.Equal(t, "debe tener al menos 8 caracteres", e.Message("es"))
+	require.Equal(t, "must be at least 8 characters", e.Message("de"))
+}
+
+func TestFieldErrorMessageUnknownCode(t *testing.T) {
+	e := &FieldError{Field: "name", Code: "unknown"}
+
+	require.Equal(t, "unknown", e.Message("en"))
+}
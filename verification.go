@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tammiec/go-rest-api/mailer"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/outbox"
+	"github.com/tammiec/go-rest-api/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// What unverifiedAccounts allows users who have not verified their email.
+const (
+	unverifiedAllow      = "allow"
+	unverifiedBlockLogin = "block_login"
+	// unverifiedReadOnly refuses every request but GETs whose actor is an
+	// unverified user's email.
+	unverifiedReadOnly = "read_only"
+)
+
+var errEmailNotVerified = errors.New("email address is not verified")
+
+var (
+	unverifiedAccounts   = unverifiedAllow
+	emailVerificationTTL = 48 * time.Hour
+	// emailVerificationURL, when set, is the page that verifies an email;
+	// the token is appended to it in the email.
+	emailVerificationURL = ""
+)
+
+func verificationMessage(user *model.User, token string) mailer.Message {
+	if emailVerificationURL != "" {
+		token = emailVerificationURL + token
+	}
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that this is your email address by using this within %s:\n\n%s\n\nIf you did not sign up, you can ignore this email.\n",
+			user.Name, emailVerificationTTL, token),
+	}
+}
+
+// verificationSink emails a verification token to users who are created or
+// change their email, as the outbox relays their changes. The token is
+// stored before the mail is sent in the background, so that the relay does
+// not wait on the mail server; mail that cannot be sent is logged, and the
+// user can ask for another token with POST /auth/verify/resend.
+type verificationSink struct {
+	DB *sql.DB
+}
+
+func (s *verificationSink) Publish(ctx context.Context, event outbox.Event) error {
+	if event.Type != model.EventUserCreated && event.Type != model.EventUserUpdated {
+		return nil
+	}
+	user := &model.User{}
+	if err := json.Unmarshal(event.Payload, user); err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	user, err = model.CreateEmailVerification(s.DB, user.Id, user.Email, token, emailVerificationTTL)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sendMail(verificationMessage(user, token))
+	return nil
+}
+
+// resendVerificationHandler emails another verification token to the user
+// with the given email, such as one whose mail was lost or who was imported
+// rather than created. Like passwordResetHandler, it answers 202 whether or
+// not there is such an unverified user.
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	input, err := parsePasswordReset(r)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	user, err := model.ResendEmailVerification(db, input.Email, token, emailVerificationTTL)
+	switch err {
+	case nil:
+		sendMail(verificationMessage(user, token))
+	case sql.ErrNoRows:
+	default:
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	user, err := model.VerifyEmail(tx, requestAudit(r.Context()), r.URL.Query().Get("token"))
+	if err == nil {
+		err = tx.Commit()
+	} else if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		log.Println(rollbackErr)
+	}
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	marshalAndWrite(renderUser(r, user), w, r)
+}
+
+// restrictUnverified enforces unverifiedReadOnly. The /auth routes stay open,
+// so that unverified users can still verify their email.
+func restrictUnverified(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor := requestAudit(r.Context()).Actor
+			if unverifiedAccounts != unverifiedReadOnly || r.Method == http.MethodGet || r.Method == http.MethodHead ||
+				actor == anonymousActor || strings.HasPrefix(r.URL.Path, "/auth/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			unverified, err := actorUnverified(db, actor)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			if unverified {
+				writeProblem(w, r, http.StatusForbidden, errEmailNotVerified.Error(), nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// grpcRestrictUnverified enforces unverifiedReadOnly on gRPC calls that
+// mirror writes, failing them with PermissionDenied.
+func grpcRestrictUnverified(db *sql.DB) grpcCheck {
+	return func(ctx context.Context, fullMethod string) error {
+		route, ok := grpcRoutes[fullMethod]
+		actor := rpc.Actor(ctx)
+		if unverifiedAccounts != unverifiedReadOnly || !ok || route.method == http.MethodGet || actor == anonymousActor {
+			return nil
+		}
+		unverified, err := actorUnverified(db, actor)
+		if err != nil {
+			log.Println(err)
+			return status.Error(codes.Internal, "internal error")
+		}
+		if unverified {
+			return status.Error(codes.PermissionDenied, errEmailNotVerified.Error())
+		}
+		return nil
+	}
+}
+
+// actorUnverified reports whether actor is a user who has not verified their
+// email. Actors that are not users, such as services, count as verified.
+func actorUnverified(db *sql.DB, actor string) (bool, error) {
+	verified, err := model.EmailVerified(db, actor)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && !verified, err
+}
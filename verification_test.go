@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/mailer"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/outbox"
+	"github.com/tammiec/go-rest-api/userspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withUnverifiedAccounts(t *testing.T, policy string) {
+	p := unverifiedAccounts
+	unverifiedAccounts = policy
+	t.Cleanup(func() { unverifiedAccounts = p })
+}
+
+// blockingMailer sends nothing until it is closed.
+type blockingMailer chan struct{}
+
+func (m blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	<-m
+	return nil
+}
+
+var createdEvent = outbox.Event{Type: model.EventUserCreated, Payload: []byte(`{"id":1,"name":"Kaladin","email":"k@s.com"}`)}
+
+func TestVerificationSinkEmailsToken(t *testing.T) {
+	mail := withMemoryMailer(t)
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO email_verification_tokens")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(1, "k@s.com", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(rows)
+
+	require.NoError(t, (&verificationSink{DB: db}).Publish(context.Background(), createdEvent))
+
+	require.Eventually(t, func() bool { return len(mail.Messages()) == 1 }, time.Second, time.Millisecond)
+	messages := mail.Messages()
+	require.Equal(t, "k@s.com", messages[0].To)
+	require.Equal(t, "Verify your email address", messages[0].Subject)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerificationSinkSkipsVerifiedAndOtherEvents(t *testing.T) {
+	mail := withMemoryMailer(t)
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO email_verification_tokens")
+	mock.ExpectQuery("WITH").WillReturnError(sql.ErrNoRows)
+	sink := &verificationSink{DB: db}
+
+	require.NoError(t, sink.Publish(context.Background(), outbox.Event{Type: model.EventUserUpdated, Payload: createdEvent.Payload}))
+	require.NoError(t, sink.Publish(context.Background(), outbox.Event{Type: model.EventUserDeleted, Payload: createdEvent.Payload}))
+
+	require.Empty(t, mail.Messages())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerificationSinkDoesNotWaitForMail(t *testing.T) {
+	m := userMailer
+	release := make(chan struct{})
+	userMailer = blockingMailer(release)
+	t.Cleanup(func() {
+		close(release)
+		userMailer = m
+	})
+	db, mock, _ := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO email_verification_tokens")
+	mock.ExpectQuery("WITH").WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com"))
+
+	require.NoError(t, (&verificationSink{DB: db}).Publish(context.Background(), createdEvent))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResendVerification(t *testing.T) {
+	mail := withMemoryMailer(t)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO email_verification_tokens")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs("k@s.com", sqlmock.AnyArg(), int64(emailVerificationTTL/time.Millisecond)).WillReturnRows(rows)
+	mock.ExpectPrepare("INSERT INTO email_verification_tokens")
+	mock.ExpectQuery("WITH").WillReturnError(sql.ErrNoRows)
+
+	_, resp, err := postForm(router, "http://localhost:1234/auth/verify/resend", "email=k@s.com")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Eventually(t, func() bool { return len(mail.Messages()) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, "Verify your email address", mail.Messages()[0].Subject)
+
+	// Unknown and verified emails look the same.
+	body, resp, err := postForm(router, "http://localhost:1234/auth/verify/resend", "email=nobody@s.com")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Empty(t, body)
+	require.Len(t, mail.Messages(), 1)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmailEndpoint(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE email_verification_tokens")
+	mock.ExpectQuery("UPDATE").WithArgs(model.HashToken("secret")).WillReturnRows(mock.NewRows([]string{"user_id", "email"}).AddRow(1, "k@s.com"))
+	mock.ExpectPrepare("UPDATE users SET email_verified_at")
+	rows := mock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Kaladin", "k@s.com")
+	mock.ExpectQuery("WITH").WithArgs(1, "k@s.com", "anonymous", sqlmock.AnyArg()).WillReturnRows(rows)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE email_verification_tokens")
+	mock.ExpectQuery("UPDATE").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/auth/verify?token=secret", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Contains(t, string(body), `"email":"k@s.com"`)
+
+	body, resp, err = httpRequest(router, http.MethodGet, "http://localhost:1234/auth/verify?token=secret", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), `"field":"token"`)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginBlockedForUnverifiedEmail(t *testing.T) {
+	withUnverifiedAccounts(t, unverifiedBlockLogin)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectQuery("FROM login_failures").WillReturnRows(mock.NewRows(loginFailureColumns))
+	expectLoginAttempt(mock, 1, 1)
+	mock.ExpectPrepare("SELECT id, name, email, password, .+ FROM users")
+	mock.ExpectQuery("SELECT").WillReturnRows(mock.NewRows(loginUserColumns).AddRow(1, "Kaladin", "k@s.com", storedPassword("password"), false))
+	expectLoginSuccess(mock)
+
+	body, resp, err := postForm(router, loginURL, loginForm)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, string(body), "not verified")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnverifiedActorIsReadOnly(t *testing.T) {
+	withUnverifiedAccounts(t, unverifiedReadOnly)
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT email_verified_at IS NOT NULL FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)")
+	mock.ExpectQuery("SELECT").WithArgs("k@s.com").WillReturnRows(mock.NewRows([]string{"verified"}).AddRow(false))
+	headers := map[string]string{"X-Forwarded-User": "k@s.com"}
+
+	body, resp, err := httpRequest(router, http.MethodDelete, "http://localhost:1234/users/1", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, string(body), "not verified")
+
+	_, resp, err = httpRequest(router, http.MethodGet, "http://localhost:1234/openapi.json", headers)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnverifiedActorIsReadOnlyOverGRPC(t *testing.T) {
+	withUnverifiedAccounts(t, unverifiedReadOnly)
+	db, mock, client := getMockDBAndGRPC(t)
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT email_verified_at IS NOT NULL FROM users WHERE lower\\(email\\)=lower\\(\\$1\\)")
+	mock.ExpectQuery("SELECT").WithArgs("k@s.com").WillReturnRows(mock.NewRows([]string{"verified"}).AddRow(false))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-forwarded-user", "k@s.com")
+
+	_, err := client.DeleteUser(ctx, &userspb.DeleteUserRequest{Id: 1})
+
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
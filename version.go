@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+// apiVersion is one version of the users API. Every version shares the same
+// handlers and differs only in how users are serialized.
+type apiVersion struct {
+	name string
+	user func(*model.User) interface{}
+	// schema names the OpenAPI schema of the serialized user.
+	schema     string
+	deprecated bool
+	// sunset, when set, is the date after which the version may be removed.
+	sunset time.Time
+}
+
+var (
+	apiV1 = &apiVersion{
+		name:       "v1",
+		user:       func(user *model.User) interface{} { return api.NewUser(user) },
+		schema:     "User",
+		deprecated: true,
+	}
+	apiV2 = &apiVersion{
+		name:   "v2",
+		user:   func(user *model.User) interface{} { return api.NewUserV2(user) },
+		schema: "UserV2",
+	}
+
+	apiVersions = []*apiVersion{apiV1, apiV2}
+
+	// defaultApiVersion serves unversioned paths that do not ask for a
+	// version in their Accept header.
+	defaultApiVersion = apiV1
+)
+
+const vendorMediaTypePrefix = "application/vnd.users."
+
+// mediaType is the vendor media type that selects this version through the
+// Accept header.
+func (v *apiVersion) mediaType() string {
+	return vendorMediaTypePrefix + v.name + "+json"
+}
+
+func (v *apiVersion) users(users []*model.User) []interface{} {
+	result := make([]interface{}, len(users))
+	for i, user := range users {
+		result[i] = v.user(user)
+	}
+	return result
+}
+
+type versionKey struct{}
+
+type requestedVersion struct {
+	version   *apiVersion
+	viaAccept bool
+}
+
+// versioned serves the routes it wraps as version v, or, when v is nil, as
+// the version named by an Accept media type such as
+// application/vnd.users.v2+json.
+func versioned(v *apiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			selected := requestedVersion{version: v}
+			if v == nil {
+				selected.version = defaultApiVersion
+				if accepted := acceptedVersion(r.Header.Get("Accept")); accepted != nil {
+					selected = requestedVersion{version: accepted, viaAccept: true}
+				}
+			}
+			if selected.version.deprecated {
+				w.Header().Set("Deprecation", "true")
+				if !selected.version.sunset.IsZero() {
+					w.Header().Set("Sunset", selected.version.sunset.UTC().Format(http.TimeFormat))
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, selected)))
+		})
+	}
+}
+
+func acceptedVersion(accept string) *apiVersion {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for _, v := range apiVersions {
+			if mediaType == v.mediaType() {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func requestVersion(r *http.Request) requestedVersion {
+	if selected, ok := r.Context().Value(versionKey{}).(requestedVersion); ok {
+		return selected
+	}
+	return requestedVersion{version: defaultApiVersion}
+}
+
+// renderUser serializes a user for the version the request was routed to.
+func renderUser(r *http.Request, user *model.User) interface{} {
+	return requestVersion(r).version.user(user)
+}
+
+func renderUsers(r *http.Request, users []*model.User) []interface{} {
+	return requestVersion(r).version.users(users)
+}
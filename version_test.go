@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func expectGetUser(mock sqlmock.Sqlmock) {
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "name", "email", "version"})
+	rows.AddRow("1", "Kaladin", "k@s.com", 1)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
+}
+
+func TestHandleGetUserV1(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	expectGetUser(mock)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v1/users/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}", string(body))
+	require.Equal(t, "true", resp.Header.Get("Deprecation"))
+	require.Empty(t, resp.Header.Get("Sunset"))
+}
+
+func TestHandleGetUserV1Sunset(t *testing.T) {
+	apiV1.sunset = time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { apiV1.sunset = time.Time{} }()
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	expectGetUser(mock)
+
+	_, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v1/users/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, "Thu, 01 Apr 2027 00:00:00 GMT", resp.Header.Get("Sunset"))
+}
+
+func TestHandleGetUserV2(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	expectGetUser(mock)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/v2/users/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "{\"id\":\"1\",\"name\":\"Kaladin\",\"email\":\"k@s.com\",\"links\":{\"self\":\"/v2/users/1\"}}", string(body))
+	require.Empty(t, resp.Header.Get("Deprecation"))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+}
+
+func TestHandleGetUserV2ByAcceptHeader(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+	expectGetUser(mock)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users/1", map[string]string{"Accept": "application/vnd.users.v2+json"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "{\"id\":\"1\",\"name\":\"Kaladin\",\"email\":\"k@s.com\",\"links\":{\"self\":\"/v2/users/1\"}}", string(body))
+	require.Equal(t, "application/vnd.users.v2+json", resp.Header.Get("Content-Type"))
+	require.Empty(t, resp.Header.Get("Deprecation"))
+}
+
+func TestHandleGetUsersUnversionedDefaultsToV1(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	rows := mock.NewRows([]string{"id", "name", "email"})
+	rows.AddRow("1", "Kaladin", "k@s.com")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/users", nil)
+	require.NoError(t, err)
+	require.Equal(t, "[{\"id\":1,\"name\":\"Kaladin\",\"email\":\"k@s.com\"}]", string(body))
+	require.Equal(t, "true", resp.Header.Get("Deprecation"))
+}
+
+func TestAcceptedVersion(t *testing.T) {
+	require.Equal(t, apiV2, acceptedVersion("text/html, application/vnd.users.v2+json;q=0.9"))
+	require.Nil(t, acceptedVersion("application/json"))
+	require.Nil(t, acceptedVersion("application/vnd.users.v9+json"))
+}
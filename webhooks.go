@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tammiec/go-rest-api/webhooks"
+)
+
+const (
+	webhookDeliveriesLimit = 100
+	webhookPollInterval    = 5 * time.Second
+)
+
+// webhooksEnabled sends webhook deliveries from this process. Deliveries are
+// queued by the outbox relay's webhooks sink, and subscriptions can be
+// managed either way.
+var webhooksEnabled = false
+
+type webhookInput struct {
+	URL    string   `json:"url" xml:"url" yaml:"url"`
+	Events []string `json:"events" xml:"events>event" yaml:"events"`
+}
+
+type webhookResponse struct {
+	Id     int      `json:"id" xml:"id" yaml:"id"`
+	URL    string   `json:"url" xml:"url" yaml:"url"`
+	Events []string `json:"events" xml:"events>event" yaml:"events"`
+	// Secret is only set in the response to creating a webhook.
+	Secret string `json:"secret,omitempty" xml:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+func renderWebhook(webhook *webhooks.Webhook) *webhookResponse {
+	return &webhookResponse{Id: webhook.Id, URL: webhook.URL, Events: webhook.Events, Secret: webhook.Secret}
+}
+
+type deliveryResponse struct {
+	Id            int64     `json:"id" xml:"id" yaml:"id"`
+	Event         string    `json:"event" xml:"event" yaml:"event"`
+	Status        string    `json:"status" xml:"status" yaml:"status"`
+	Attempts      int       `json:"attempts" xml:"attempts" yaml:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" xml:"next_attempt_at" yaml:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty" xml:"last_error,omitempty" yaml:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+}
+
+func renderDelivery(d *webhooks.Delivery) *deliveryResponse {
+	return &deliveryResponse{
+		Id:            d.Id,
+		Event:         d.EventType,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		NextAttemptAt: d.NextAttemptAt,
+		LastError:     d.LastError,
+		CreatedAt:     d.CreatedAt,
+	}
+}
+
+// writeWebhookResult writes the webhook from a webhooks call, or the error
+// it failed with.
+func writeWebhookResult(w http.ResponseWriter, r *http.Request, webhook *webhooks.Webhook, err error) {
+	if err != nil {
+		log.Println(err)
+		writeMutationError(w, r, err)
+		return
+	}
+	marshalAndWrite(renderWebhook(webhook), w, r)
+}
+
+// addWebhookRoutes registers webhook subscriptions and their deliveries.
+func addWebhookRoutes(router *mux.Router, db *sql.DB) {
+	router.HandleFunc("/webhooks", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			list, err := webhooks.List(db)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			result := make([]*webhookResponse, len(list))
+			for i, webhook := range list {
+				result[i] = renderWebhook(webhook)
+			}
+			marshalAndWrite(result, w, r)
+			return
+		}
+		input := webhookInput{}
+		if err := decodeBody(r, &input); err != nil {
+			writeParseError(w, err)
+			return
+		}
+		webhook, err := webhooks.Create(db, input.URL, input.Events)
+		writeWebhookResult(w, r, webhook, err)
+	})).Methods(http.MethodGet, http.MethodPost)
+
+	router.HandleFunc("/webhooks/{id:[0-9]+}", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		id := validateId(mux.Vars(r)["id"], w)
+		switch r.Method {
+		case http.MethodGet:
+			webhook, err := webhooks.Get(db, id)
+			writeWebhookResult(w, r, webhook, err)
+		case http.MethodDelete:
+			webhook, err := webhooks.Delete(db, id)
+			writeWebhookResult(w, r, webhook, err)
+		case http.MethodPut:
+			input := webhookInput{}
+			if err := decodeBody(r, &input); err != nil {
+				writeParseError(w, err)
+				return
+			}
+			webhook, err := webhooks.Update(db, id, input.URL, input.Events)
+			writeWebhookResult(w, r, webhook, err)
+		}
+	})).Methods(http.MethodGet, http.MethodDelete, http.MethodPut)
+
+	router.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		id := validateId(mux.Vars(r)["id"], w)
+		list, err := webhooks.ListDeliveries(db, id, webhookDeliveriesLimit)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		result := make([]*deliveryResponse, len(list))
+		for i, d := range list {
+			result[i] = renderDelivery(d)
+		}
+		marshalAndWrite(result, w, r)
+	})).Methods(http.MethodGet)
+
+	router.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/{delivery:[0-9]+}/redeliver", negotiated(func(w http.ResponseWriter, r *http.Request) {
+		id := validateId(mux.Vars(r)["id"], w)
+		deliveryId, err := strconv.ParseInt(mux.Vars(r)["delivery"], 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		d, err := webhooks.Redeliver(db, id, deliveryId)
+		if err != nil {
+			log.Println(err)
+			writeMutationError(w, r, err)
+			return
+		}
+		marshalAndWrite(renderDelivery(d), w, r)
+	})).Methods(http.MethodPost)
+}
@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errForbiddenAddress is returned by the dispatcher's client for a receiver
+// that resolves to an address that webhooks may not reach.
+var errForbiddenAddress = errors.New("receiver address is not public")
+
+// forbiddenIP tells whether ip is loopback, link-local, private (RFC 1918 or
+// unique local) or unspecified. Webhooks must not reach those, so that they
+// cannot be pointed at the API's own network.
+func forbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// publicHost tells whether a URL's host may be a webhook receiver, as far as
+// can be told without resolving it: IP literals are checked and local names
+// refused. Names are checked again when the dispatcher resolves them.
+func publicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !forbiddenIP(ip)
+	}
+	return true
+}
+
+// newClient returns the client that sends deliveries. It refuses to connect
+// to addresses for which forbidden is true, whatever name they were resolved
+// from, and does not follow redirects, which could lead anywhere.
+func newClient(timeout time.Duration, forbidden func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || forbidden(ip) {
+				return fmt.Errorf("%w: %s", errForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// No proxy, since the proxy's address is all the dialer would see.
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxLastError = 500
+
+// Dispatcher sends due deliveries, retrying failures with exponential backoff
+// until MaxAttempts is reached, after which a delivery is dead until it is
+// redelivered. Several dispatchers may share a database; each delivery is
+// leased to one of them while it is being sent.
+//
+// A batch is sent concurrently, so that it is done within Client.Timeout
+// however big it is; Lease must be longer than that.
+type Dispatcher struct {
+	DB          *sql.DB
+	Client      *http.Client
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles with each
+	// further failure, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	BatchSize  int
+	Lease      time.Duration
+}
+
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      newClient(10*time.Second, forbiddenIP),
+		MaxAttempts: 8,
+		Backoff:     30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+		BatchSize:   50,
+		Lease:       time.Minute,
+	}
+}
+
+// Sign returns the signature sent in the X-Webhook-Signature header: the
+// hex HMAC-SHA256, keyed with the webhook's secret, of the
+// X-Webhook-Timestamp value, a dot and the body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type claimedDelivery struct {
+	id        int64
+	eventType string
+	payload   string
+	attempts  int
+	url       string
+	secret    string
+}
+
+// claim leases up to BatchSize due deliveries by pushing their next attempt
+// past the lease, so that no other dispatcher picks them up meanwhile.
+func (d *Dispatcher) claim() ([]claimedDelivery, error) {
+	rows, err := d.DB.Query(`UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 second'
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.event_type, d.payload, d.attempts, w.url, w.secret`,
+		d.BatchSize, int(d.Lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []claimedDelivery
+	for rows.Next() {
+		var c claimedDelivery
+		if err := rows.Scan(&c.id, &c.eventType, &c.payload, &c.attempts, &c.url, &c.secret); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, c)
+	}
+	return claimed, rows.Err()
+}
+
+func (d *Dispatcher) send(ctx context.Context, c claimedDelivery) error {
+	body := []byte(c.payload)
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(c.id, 10))
+	req.Header.Set("X-Webhook-Event", c.eventType)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(c.secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+// record stores the outcome of an attempt.
+func (d *Dispatcher) record(c claimedDelivery, sendErr error) error {
+	attempts := c.attempts + 1
+	if sendErr == nil {
+		_, err := d.DB.Exec("UPDATE webhook_deliveries SET status=$1, attempts=$2, last_error='' WHERE id=$3",
+			StatusDelivered, attempts, c.id)
+		return err
+	}
+
+	status := StatusPending
+	if attempts >= d.MaxAttempts {
+		status = StatusDead
+	}
+	lastError := sendErr.Error()
+	if len(lastError) > maxLastError {
+		lastError = lastError[:maxLastError]
+	}
+	_, err := d.DB.Exec("UPDATE webhook_deliveries SET status=$1, attempts=$2, next_attempt_at=$3, last_error=$4 WHERE id=$5",
+		status, attempts, time.Now().Add(d.backoff(attempts)), lastError, c.id)
+	return err
+}
+
+// RunOnce sends the deliveries that are due and returns how many it tried.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	claimed, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+	errs := make([]error, len(claimed))
+	var wg sync.WaitGroup
+	for i, c := range claimed {
+		wg.Add(1)
+		go func(i int, c claimedDelivery) {
+			defer wg.Done()
+			errs[i] = d.record(c, d.send(ctx, c))
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(claimed), nil
+}
+
+// Run sends due deliveries every interval until ctx is done. A full batch is
+// followed straight away by the next one.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := d.RunOnce(ctx)
+		if err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		if n == d.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
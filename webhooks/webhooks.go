@@ -0,0 +1,239 @@
+// Package webhooks stores partner webhook subscriptions and delivers user
+// lifecycle events to them through a queue kept in Postgres.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tammiec/go-rest-api/model"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+// EventTypes are the events a webhook may subscribe to.
+var EventTypes = []string{model.EventUserCreated, model.EventUserUpdated, model.EventUserDeleted}
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+type Webhook struct {
+	Id     int
+	URL    string
+	Events []string
+	// Secret signs deliveries. It is only shown when the webhook is created.
+	Secret string
+}
+
+type Delivery struct {
+	Id            int64
+	WebhookId     int
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// Validate checks a webhook's URL and event types. The URL must not point at
+// a loopback, link-local or private address.
+func Validate(rawURL string, eventTypes []string) error {
+	v := validation.New()
+	u, err := url.Parse(rawURL)
+	absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	v.String("url", rawURL).Required().NoControl().
+		Check(absolute, "url").
+		Check(!absolute || publicHost(u.Hostname()), "public_url")
+	if len(eventTypes) == 0 {
+		v.Add("events", "min_items", 1)
+	}
+	for _, eventType := range eventTypes {
+		if !isEventType(eventType) {
+			v.Add("events", "enum", strings.Join(EventTypes, ", "))
+			break
+		}
+	}
+	return v.Err()
+}
+
+func isEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func scanWebhook(scan func(...interface{}) error) (*Webhook, error) {
+	webhook := &Webhook{}
+	err := scan(&webhook.Id, &webhook.URL, pq.Array(&webhook.Events))
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func List(db model.Querier) ([]*Webhook, error) {
+	rows, err := db.Query("SELECT id, url, events FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func Get(db model.Querier, id int) (*Webhook, error) {
+	stmt, err := db.Prepare("SELECT id, url, events FROM webhooks WHERE id=$1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanWebhook(stmt.QueryRow(id).Scan)
+}
+
+// Create adds a webhook with a new signing secret.
+func Create(db model.Querier, rawURL string, eventTypes []string) (*Webhook, error) {
+	if err := Validate(rawURL, eventTypes); err != nil {
+		return nil, err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare("INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3) RETURNING id, url, events")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	webhook, err := scanWebhook(stmt.QueryRow(rawURL, secret, pq.Array(eventTypes)).Scan)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+// Update replaces a webhook's URL and events, keeping its secret.
+func Update(db model.Querier, id int, rawURL string, eventTypes []string) (*Webhook, error) {
+	if err := Validate(rawURL, eventTypes); err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare("UPDATE webhooks SET url=$1, events=$2 WHERE id=$3 RETURNING id, url, events")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanWebhook(stmt.QueryRow(rawURL, pq.Array(eventTypes), id).Scan)
+}
+
+// Delete removes a webhook along with its deliveries.
+func Delete(db model.Querier, id int) (*Webhook, error) {
+	stmt, err := db.Prepare("DELETE FROM webhooks WHERE id=$1 RETURNING id, url, events")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanWebhook(stmt.QueryRow(id).Scan)
+}
+
+// payload is the body of every delivery. Id is the event's id, which stays
+// the same across redeliveries so that receivers can drop duplicates.
+type payload struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	User       json.RawMessage `json:"user"`
+}
+
+// Enqueue queues a delivery of an event to every webhook subscribed to its
+// type. Queueing the same event again has no effect.
+func Enqueue(db model.Querier, eventId string, eventType string, occurredAt time.Time, user []byte) error {
+	body, err := json.Marshal(payload{Id: eventId, Type: eventType, OccurredAt: occurredAt.UTC(), User: user})
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhooks WHERE $2 = ANY(events)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(eventId, eventType, string(body))
+	return err
+}
+
+const deliveryColumns = "id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at"
+
+func scanDelivery(scan func(...interface{}) error) (*Delivery, error) {
+	d := &Delivery{}
+	err := scan(&d.Id, &d.WebhookId, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, newest first.
+func ListDeliveries(db model.Querier, webhookId int, limit int) ([]*Delivery, error) {
+	stmt, err := db.Prepare("SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(webhookId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*Delivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver puts a delivery of the given webhook back in the queue with a
+// fresh set of attempts, whatever its status.
+func Redeliver(db model.Querier, webhookId int, id int64) (*Delivery, error) {
+	stmt, err := db.Prepare(`UPDATE webhook_deliveries
+		SET status='pending', attempts=0, next_attempt_at=now(), last_error=''
+		WHERE id=$1 AND webhook_id=$2 RETURNING ` + deliveryColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return scanDelivery(stmt.QueryRow(id, webhookId).Scan)
+}
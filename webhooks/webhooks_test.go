@@ -0,0 +1,269 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/validation"
+)
+
+func getMockDB() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(fmt.Sprintf("an error '%s' was not expected when opening a stub database connection", err))
+	}
+	return db, mock
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate("https://partner.example/hooks", []string{"user.created"}))
+
+	err := Validate("ftp://partner.example", []string{"user.renamed"})
+	require.Equal(t, validation.Errors{
+		{Field: "url", Code: "url"},
+		{Field: "events", Code: "enum", Args: []interface{}{"user.created, user.updated, user.deleted"}},
+	}, err)
+
+	err = Validate("/hooks", nil)
+	require.Equal(t, validation.Errors{
+		{Field: "url", Code: "url"},
+		{Field: "events", Code: "min_items", Args: []interface{}{1}},
+	}, err)
+
+	for _, private := range []string{"http://127.0.0.1:8080", "http://localhost/hooks", "https://api.localhost.", "http://10.1.2.3",
+		"http://192.168.0.1", "http://172.16.0.1", "http://169.254.169.254/latest/meta-data", "http://[::1]", "http://[fd00::1]", "http://0.0.0.0"} {
+		err = Validate(private, []string{"user.created"})
+		require.Equal(t, validation.Errors{{Field: "url", Code: "public_url"}}, err, private)
+	}
+	require.NoError(t, Validate("http://203.0.113.7/hooks", []string{"user.created"}))
+}
+
+func TestCreateReturnsSecret(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO webhooks")
+	rows := mock.NewRows([]string{"id", "url", "events"}).AddRow(1, "https://partner.example", "{user.created}")
+	mock.ExpectQuery("INSERT INTO webhooks").WillReturnRows(rows)
+
+	webhook, err := Create(db, "https://partner.example", []string{"user.created"})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"user.created"}, webhook.Events)
+	require.Len(t, webhook.Secret, 64)
+}
+
+func TestSign(t *testing.T) {
+	require.Equal(t,
+		"sha256=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28",
+		Sign("secret", 1600000000, []byte(`{}`)))
+	require.NotEqual(t, Sign("secret", 1, []byte("a")), Sign("secret", 2, []byte("a")))
+}
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	require.Equal(t, time.Second, d.backoff(1))
+	require.Equal(t, 2*time.Second, d.backoff(2))
+	require.Equal(t, 4*time.Second, d.backoff(3))
+	require.Equal(t, 5*time.Second, d.backoff(4))
+	require.Equal(t, 5*time.Second, d.backoff(40))
+}
+
+// errorContaining matches a query argument holding an error message that
+// contains its text.
+type errorContaining string
+
+func (e errorContaining) Match(v driver.Value) bool {
+	message, ok := v.(string)
+	return ok && strings.Contains(message, string(e))
+}
+
+// testDispatcher is a dispatcher allowed to reach the loopback receivers of
+// the tests.
+func testDispatcher(db *sql.DB) *Dispatcher {
+	d := NewDispatcher(db)
+	d.Client = newClient(2*time.Second, func(net.IP) bool { return false })
+	return d
+}
+
+func expectClaim(mock sqlmock.Sqlmock, url string, attempts int) {
+	rows := mock.NewRows([]string{"id", "event_type", "payload", "attempts", "url", "secret"}).
+		AddRow(7, "user.created", `{"type":"user.created"}`, attempts, url, "secret")
+	mock.ExpectQuery("UPDATE webhook_deliveries d").WillReturnRows(rows)
+}
+
+func TestRunOnceDeliversSignedPayload(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	expectClaim(mock, receiver.URL, 0)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusDelivered, 1, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := testDispatcher(db).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, `{"type":"user.created"}`, string(body))
+	require.Equal(t, "user.created", received.Header.Get("X-Webhook-Event"))
+	require.Equal(t, "7", received.Header.Get("X-Webhook-Id"))
+	timestamp, err := strconv.ParseInt(received.Header.Get("X-Webhook-Timestamp"), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, Sign("secret", timestamp, body), received.Header.Get("X-Webhook-Signature"))
+}
+
+func TestRunOnceSchedulesRetry(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	expectClaim(mock, receiver.URL, 2)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusPending, 3, sqlmock.AnyArg(), "receiver responded 503 Service Unavailable", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := testDispatcher(db).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceDeadLetters(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+	d := testDispatcher(db)
+	d.MaxAttempts = 3
+
+	expectClaim(mock, receiver.URL, 2)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusDead, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := d.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceSendsBatchConcurrently(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	// Each request waits for the other, so sending them one at a time would
+	// time out.
+	var both sync.WaitGroup
+	both.Add(2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		both.Done()
+		both.Wait()
+	}))
+	defer receiver.Close()
+	d := testDispatcher(db)
+
+	rows := mock.NewRows([]string{"id", "event_type", "payload", "attempts", "url", "secret"}).
+		AddRow(7, "user.created", `{"type":"user.created"}`, 0, receiver.URL, "secret").
+		AddRow(8, "user.deleted", `{"type":"user.deleted"}`, 0, receiver.URL, "secret")
+	mock.ExpectQuery("UPDATE webhook_deliveries d").WillReturnRows(rows)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusDelivered, 1, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusDelivered, 1, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := d.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceRefusesPrivateAddresses(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	received := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer receiver.Close()
+
+	// Validate refuses the name, but one that passed could resolve to a
+	// loopback address by the time the delivery is sent.
+	expectClaim(mock, strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1), 0)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusPending, 1, sqlmock.AnyArg(), errorContaining("receiver address is not public"), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := NewDispatcher(db).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.False(t, received)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunOnceDoesNotFollowRedirects(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	receiver := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	expectClaim(mock, receiver.URL, 0)
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(StatusPending, 1, sqlmock.AnyArg(), "receiver responded 307 Temporary Redirect", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := testDispatcher(db).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	require.False(t, redirected)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnqueueFansOutToSubscribers(t *testing.T) {
+	db, mock := getMockDB()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO webhook_deliveries")
+	occurredAt := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs("4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", "user.deleted",
+			`{"id":"4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10","type":"user.deleted","occurred_at":"2020-09-01T12:00:00Z","user":{"id":1}}`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err := Enqueue(db, "4d6f1c1e-5b1b-4ac5-9c1d-0c6a3f7d2b10", "user.deleted", occurredAt, []byte(`{"id":1}`))
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
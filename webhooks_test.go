@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO webhooks")
+	rows := mock.NewRows([]string{"id", "url", "events"}).AddRow(1, "https://partner.example", "{user.created,user.deleted}")
+	mock.ExpectQuery("INSERT INTO webhooks").WillReturnRows(rows)
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/webhooks",
+		strings.NewReader(`{"url":"https://partner.example","events":["user.created","user.deleted"]}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	webhook := webhookResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &webhook))
+	require.Equal(t, 1, webhook.Id)
+	require.Equal(t, []string{"user.created", "user.deleted"}, webhook.Events)
+	require.NotEmpty(t, webhook.Secret)
+}
+
+func TestCreateWebhookInvalid(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:1234/webhooks",
+		strings.NewReader(`{"url":"partner.example","events":["user.created"]}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Contains(t, recorder.Body.String(), `"field":"url"`)
+}
+
+func TestGetWebhookHidesSecret(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "url", "events"}).AddRow(1, "https://partner.example", "{user.created}")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/webhooks/1", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"id":1,"url":"https://partner.example","events":["user.created"]}`, string(body))
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	created := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectPrepare("SELECT")
+	rows := mock.NewRows([]string{"id", "webhook_id", "event_type", "payload", "status", "attempts", "next_attempt_at", "last_error", "created_at"}).
+		AddRow(9, 1, "user.created", "{}", "dead", 8, created, "receiver responded 500 Internal Server Error", created)
+	mock.ExpectQuery("SELECT").WithArgs(1, webhookDeliveriesLimit).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodGet, "http://localhost:1234/webhooks/1/deliveries", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.JSONEq(t, `[{"id":9,"event":"user.created","status":"dead","attempts":8,
+		"next_attempt_at":"2020-09-01T12:00:00Z","last_error":"receiver responded 500 Internal Server Error",
+		"created_at":"2020-09-01T12:00:00Z"}]`, string(body))
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	now := time.Now().UTC()
+	mock.ExpectPrepare("UPDATE webhook_deliveries")
+	rows := mock.NewRows([]string{"id", "webhook_id", "event_type", "payload", "status", "attempts", "next_attempt_at", "last_error", "created_at"}).
+		AddRow(9, 1, "user.created", "{}", "pending", 0, now, "", now)
+	mock.ExpectQuery("UPDATE webhook_deliveries").WithArgs(9, 1).WillReturnRows(rows)
+
+	body, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/webhooks/1/deliveries/9/redeliver", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Contains(t, string(body), `"status":"pending"`)
+}
+
+func TestRedeliverUnknownDelivery(t *testing.T) {
+	db, mock, router := getMockDBAndRouter()
+	defer db.Close()
+
+	mock.ExpectPrepare("UPDATE webhook_deliveries")
+	mock.ExpectQuery("UPDATE webhook_deliveries").WithArgs(9, 1).WillReturnRows(sqlmock.NewRows(nil))
+
+	_, resp, err := httpRequest(router, http.MethodPost, "http://localhost:1234/webhooks/1/deliveries/9/redeliver", nil)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
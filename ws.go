@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+const (
+	wsWriteWait        = 10 * time.Second
+	wsPongWait         = 60 * time.Second
+	wsPingPeriod       = wsPongWait * 9 / 10
+	wsMaxMessageSize   = 4096
+	wsMaxSubscriptions = 100
+)
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// wsMessage is sent by clients to manage their subscriptions:
+//
+//	{"type": "subscribe", "id": "admins", "user_ids": [1, 2]}
+//	{"type": "subscribe", "id": "kal", "filter": {"name_contains": "kal"}}
+//	{"type": "unsubscribe", "id": "admins"}
+//
+// A subscription with neither user_ids nor a filter matches every user.
+// Field names follow the configured JSON naming, like request bodies.
+type wsMessage struct {
+	Type    string    `json:"type"`
+	Id      string    `json:"id"`
+	UserIds []int     `json:"user_ids"`
+	Filter  *wsFilter `json:"filter"`
+}
+
+type wsFilter struct {
+	Email        string `json:"email"`
+	NameContains string `json:"name_contains"`
+}
+
+// wsReply is sent by the server: "subscribed" and "unsubscribed"
+// acknowledgements, "event" pushes naming the matching subscription, and
+// "error" for messages it could not act on.
+type wsReply struct {
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	User    json.RawMessage `json:"user,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// userFilter matches users against a subscription's filter.
+type userFilter struct {
+	email        string
+	nameContains string
+}
+
+func (f userFilter) matches(user *model.User) bool {
+	if f.email != "" && strings.ToLower(user.Email) != f.email {
+		return false
+	}
+	return strings.Contains(strings.ToLower(user.Name), f.nameContains)
+}
+
+type wsSubscription struct {
+	userIds map[int]bool
+	filter  userFilter
+}
+
+func (s wsSubscription) matches(user *model.User) bool {
+	if len(s.userIds) > 0 && !s.userIds[user.Id] {
+		return false
+	}
+	return s.filter.matches(user)
+}
+
+// wsSubscriptions is shared between a connection's reader, which changes it,
+// and its writer, which matches events against it.
+type wsSubscriptions struct {
+	mu   sync.Mutex
+	byId map[string]wsSubscription
+}
+
+func (s *wsSubscriptions) handle(msg wsMessage) wsReply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Id == "" {
+		return wsReply{Type: "error", Message: "id is required"}
+	}
+	switch msg.Type {
+	case "subscribe":
+		if _, ok := s.byId[msg.Id]; !ok && len(s.byId) >= wsMaxSubscriptions {
+			return wsReply{Type: "error", Id: msg.Id, Message: fmt.Sprintf("at most %d subscriptions per connection", wsMaxSubscriptions)}
+		}
+		sub := wsSubscription{userIds: map[int]bool{}}
+		for _, id := range msg.UserIds {
+			sub.userIds[id] = true
+		}
+		if msg.Filter != nil {
+			sub.filter = userFilter{email: strings.ToLower(msg.Filter.Email), nameContains: strings.ToLower(msg.Filter.NameContains)}
+		}
+		s.byId[msg.Id] = sub
+		return wsReply{Type: "subscribed", Id: msg.Id}
+	case "unsubscribe":
+		delete(s.byId, msg.Id)
+		return wsReply{Type: "unsubscribed", Id: msg.Id}
+	default:
+		return wsReply{Type: "error", Id: msg.Id, Message: fmt.Sprintf("unknown message type %q", msg.Type)}
+	}
+}
+
+// matching returns the ids of the subscriptions that user matches.
+func (s *wsSubscriptions) matching(user *model.User) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	for id, sub := range s.byId {
+		if sub.matches(user) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// wsHandler pushes user changes to WebSocket clients for the users they
+// subscribe to. Changes come from the same broker as /users/events. A client
+// that falls too far behind is disconnected with "try again later" rather
+// than slowing the server down.
+//
+// The handshake is authenticated like any REST request: the proxy in front
+// of the API checks the caller's credentials, whatever form they take, and
+// passes the caller on in auditActorHeader. The API reads no tokens itself.
+// Handshakes that arrive without an actor are refused.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if requestAudit(r.Context()).Actor == anonymousActor {
+		writeProblem(w, r, http.StatusUnauthorized, "WebSocket subscriptions need an authenticated actor", nil)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	stream, cancel := userEvents.Subscribe()
+	defer cancel()
+
+	subscriptions := &wsSubscriptions{byId: map[string]wsSubscription{}}
+	replies := make(chan wsReply)
+	done := make(chan struct{})
+	defer close(done)
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(wsMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg wsMessage
+			reply := wsReply{Type: "error", Message: "message must be a JSON object"}
+			data, err = api.UnrenameJson(data)
+			if err == nil {
+				err = json.Unmarshal(data, &msg)
+			}
+			if err == nil {
+				reply = subscriptions.handle(msg)
+			}
+			select {
+			case replies <- reply:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	write := func(v interface{}) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(v)
+	}
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case reply := <-replies:
+			if err := write(reply); err != nil {
+				return
+			}
+		case event, open := <-stream:
+			if !open {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+			for _, id := range subscriptions.matching(event.User) {
+				if err := write(wsReply{Type: "event", Id: id, Event: event.Type, User: event.Data}); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
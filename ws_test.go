@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/tammiec/go-rest-api/api"
+	"github.com/tammiec/go-rest-api/events"
+	"github.com/tammiec/go-rest-api/model"
+)
+
+func dialWs(t *testing.T) *websocket.Conn {
+	withUserEvents(t)
+	db, _, router := getMockDBAndRouter()
+	server := httptest.NewServer(router)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", http.Header{"X-Forwarded-User": {"admin"}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		server.Close()
+		db.Close()
+	})
+	return conn
+}
+
+func TestWsSubscribeToUserIds(t *testing.T) {
+	conn := dialWs(t)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "subscribe", Id: "watch", UserIds: []int{2}}))
+	var reply wsReply
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, wsReply{Type: "subscribed", Id: "watch"}, reply)
+
+	publishUserEvent(t, events.UserUpdated, &model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"})
+	publishUserEvent(t, events.UserDeleted, &model.User{Id: 2, Name: "Adolin", Email: "a@k.com"})
+
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, "event", reply.Type)
+	require.Equal(t, "watch", reply.Id)
+	require.Equal(t, events.UserDeleted, reply.Event)
+	require.JSONEq(t, `{"id":2,"name":"Adolin","email":"a@k.com"}`, string(reply.User))
+}
+
+func TestWsCamelNaming(t *testing.T) {
+	api.SetNaming(api.NamingCamel)
+	defer api.SetNaming(api.NamingSnake)
+	conn := dialWs(t)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","id":"watch","userIds":[2]}`)))
+	var reply wsReply
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, wsReply{Type: "subscribed", Id: "watch"}, reply)
+
+	publishUserEvent(t, events.UserUpdated, &model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"})
+	publishUserEvent(t, events.UserUpdated, &model.User{Id: 2, Name: "Adolin", Email: "a@k.com"})
+
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, "watch", reply.Id)
+	require.JSONEq(t, `{"id":2,"name":"Adolin","email":"a@k.com"}`, string(reply.User))
+}
+
+func TestWsSubscribeWithFilter(t *testing.T) {
+	conn := dialWs(t)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "subscribe", Id: "kal", Filter: &wsFilter{NameContains: "KAL"}}))
+	var reply wsReply
+	require.NoError(t, conn.ReadJSON(&reply))
+
+	publishUserEvent(t, events.UserCreated, &model.User{Id: 2, Name: "Adolin", Email: "a@k.com"})
+	publishUserEvent(t, events.UserCreated, &model.User{Id: 1, Name: "Kaladin", Email: "k@s.com"})
+
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, "kal", reply.Id)
+	require.JSONEq(t, `{"id":1,"name":"Kaladin","email":"k@s.com"}`, string(reply.User))
+}
+
+func TestWsRejectsBadMessages(t *testing.T) {
+	conn := dialWs(t)
+	var reply wsReply
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, wsReply{Type: "error", Message: "message must be a JSON object"}, reply)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "watch", Id: "a"}))
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, wsReply{Type: "error", Id: "a", Message: `unknown message type "watch"`}, reply)
+}
+
+func TestWsDisconnectsSlowClients(t *testing.T) {
+	conn := dialWs(t)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "subscribe", Id: "none", UserIds: []int{99}}))
+	var reply wsReply
+	require.NoError(t, conn.ReadJSON(&reply))
+
+	// Publishing far faster than the connection's writer is scheduled
+	// overflows its queue even though none of the events match.
+	for i := 0; i < 10000; i++ {
+		publishUserEvent(t, events.UserCreated, &model.User{Id: 1})
+	}
+
+	var err error
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	require.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err.Error())
+}
+
+func TestWsRequiresUpgrade(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+
+	_, resp, err := httpRequest(router, "GET", "http://localhost:1234/ws", map[string]string{"X-Forwarded-User": "admin"})
+
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestWsRefusesAnonymousUpgrades(t *testing.T) {
+	db, _, router := getMockDBAndRouter()
+	defer db.Close()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+
+	require.Equal(t, websocket.ErrBadHandshake, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}